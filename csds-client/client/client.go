@@ -1,6 +1,7 @@
 package client
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -14,9 +15,56 @@ type ClientOptions struct {
 	RequestFile     string
 	RequestYaml     string
 	Jwt             string
+	InputFile       string
+	ReplayFile      string
+	ReplaySpeed     float64
+	RecordFile      string
 	ConfigFile      string
+	OutputDir       string
+	SplitOutput     bool
+	OutputFormat    string
 	MonitorInterval time.Duration
-	Visualization   bool
+	WatchDiff       bool
+	EventLog        string
+	AlertRules      string
+	MetricsAddr     string
+	ServeAddr       string
+	// ConvergeType and ConvergeVersion are the xds type and the config version whose rollout is
+	// tracked in converge mode
+	ConvergeType      string
+	ConvergeVersion   string
+	ConvergeThreshold float64
+	ConvergeTimeout   time.Duration
+	TUI               bool
+	// Lint checks the configs of the clients with the lint rules in LintRules, which is a comma
+	// separated list of rule names, and prints out the findings in LintFormat
+	Lint       bool
+	LintRules  string
+	LintFormat string
+	// ConsistencyGroupBy is the metadata key to group the clients by to compare their configs in
+	// consistency mode
+	ConsistencyGroupBy string
+	// Visualization is the mode of the visualization, e.g. graph or html, it is off if empty
+	Visualization       string
+	VisualizationOnline bool
+	// GraphFormat and GraphFile are the format and the file of the graph of the visualization
+	GraphFormat string
+	GraphFile   string
+	// GraphAddr is the address to serve the html viewer of the graph at in html mode
+	GraphAddr string
+	// GraphClient is the node id of the client whose resources are the only ones in the graph
+	GraphClient string
+	// GraphDetail is the level of the detail of the graph, e.g. resources, routes or endpoints
+	GraphDetail string
+	// GraphRoot is the resource the graph is focused on, e.g. cluster:NAME, and GraphDepth is the
+	// number of edges from it the graph goes up to, 0 for any number
+	GraphRoot  string
+	GraphDepth int
+	// GraphDiff draws the changes of the graph since the previous response, or since the response
+	// in GraphDiffFile if it is set
+	GraphDiff     bool
+	GraphDiffFile string
+	Wide          bool
 }
 
 // Client implements CSDS Client of a particular version. Upon creation of the new client it is
@@ -26,3 +74,18 @@ type Client interface {
 	// options provided during Client creation.
 	Run() error
 }
+
+// ExitError is an error that the client should exit with a particular code for, e.g. when an alert
+// rule is violated in one-shot mode
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("%v (exit code %d)", e.Err, e.Code)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,353 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// Conditions of alert rules
+const (
+	// ConditionNacked is violated by the clients that rejected a config of the control plane
+	ConditionNacked string = "nacked"
+	// ConditionStalePercent is violated if the percentage of clients with a stale config exceeds the threshold
+	ConditionStalePercent string = "stale_percent"
+	// ConditionClientDisappeared is violated by the clients that were connected before but are gone
+	ConditionClientDisappeared string = "client_disappeared"
+	// ConditionNoClients is violated if no client is connected
+	ConditionNoClients string = "no_clients"
+)
+
+// States of alerts passed to hooks
+const (
+	AlertFiring   string = "firing"
+	AlertResolved string = "resolved"
+)
+
+// defaultAlertExitCode is the exit code of a violated rule in one-shot mode if the rule does not set one
+const defaultAlertExitCode = 3
+
+// webhookTimeout is the timeout of posting an alert to a webhook
+const webhookTimeout = 10 * time.Second
+
+// AlertRules is the content of the -alert_rules file
+type AlertRules struct {
+	Rules []*AlertRule `json:"rules"`
+	// Command and Webhook are the hooks of the rules that do not set their own
+	Command string `json:"command"`
+	Webhook string `json:"webhook"`
+}
+
+// AlertRule is a condition on the clients that fires an alert when it is violated
+type AlertRule struct {
+	Name      string `json:"name"`
+	Condition string `json:"condition"`
+	// Threshold is the percentage of clients for ConditionStalePercent
+	Threshold float64 `json:"threshold"`
+	// For is how long the condition must be violated before the alert fires, e.g. 2m
+	For string `json:"for"`
+	// Command is run with the json payload of the alert in its stdin when the alert fires or resolves
+	Command string `json:"command"`
+	// Webhook is the url the json payload of the alert is posted to when the alert fires or resolves
+	Webhook string `json:"webhook"`
+	// ExitCode is the exit code of the client if the rule is violated in one-shot mode
+	ExitCode int `json:"exit_code"`
+
+	duration time.Duration
+	// state of the rule across the responses, violatedSince holds the time each client has violated
+	// the condition since, or the time the clients as a whole have under the empty key for the
+	// conditions that are not violated by single clients
+	violatedSince map[string]time.Time
+	firing        bool
+	// notified holds the keys of violatedSince the firing alerts of the rule have been sent for
+	notified map[string]bool
+}
+
+// Alert is the json payload passed to hooks
+type Alert struct {
+	Rule      string    `json:"rule"`
+	Condition string    `json:"condition"`
+	State     string    `json:"state"`
+	Time      time.Time `json:"time"`
+	// Clients are the node ids of the clients that violate the condition
+	Clients []string `json:"clients,omitempty"`
+	// Value is the percentage of stale clients for ConditionStalePercent
+	Value float64 `json:"value,omitempty"`
+}
+
+// ParseAlertRules loads the alert rules from the yaml file at path
+func ParseAlertRules(path string) (*AlertRules, error) {
+	filename, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	rules := &AlertRules{}
+	if err := yaml.Unmarshal(data, rules); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range rules.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("%v_%d", rule.Condition, i)
+		}
+		switch rule.Condition {
+		case ConditionNacked, ConditionStalePercent, ConditionClientDisappeared, ConditionNoClients:
+		default:
+			return nil, fmt.Errorf("unsupported condition %v of alert rule %v", rule.Condition, rule.Name)
+		}
+		if rule.For != "" {
+			if rule.duration, err = time.ParseDuration(rule.For); err != nil {
+				return nil, fmt.Errorf("invalid duration of alert rule %v: %v", rule.Name, err)
+			}
+		}
+		if rule.Command == "" {
+			rule.Command = rules.Command
+		}
+		if rule.Webhook == "" {
+			rule.Webhook = rules.Webhook
+		}
+		if rule.ExitCode == 0 {
+			rule.ExitCode = defaultAlertExitCode
+		}
+	}
+	return rules, nil
+}
+
+// violation checks if snapshot violates the condition of the rule, and returns the clients that
+// violate it and the value it is compared with. seen holds the clients connected before snapshot.
+func (r *AlertRule) violation(snapshot *Snapshot, seen map[string]bool) (bool, []string, float64) {
+	var clients []string
+	switch r.Condition {
+	case ConditionNacked:
+		for _, c := range snapshot.Clients {
+			if isNacked(c) {
+				clients = append(clients, c.ID)
+			}
+		}
+		return len(clients) != 0, clients, 0
+	case ConditionStalePercent:
+		if len(snapshot.Clients) == 0 {
+			return false, nil, 0
+		}
+		for _, c := range snapshot.Clients {
+			for _, x := range c.Xds {
+				if x.Status == "STALE" {
+					clients = append(clients, c.ID)
+					break
+				}
+			}
+		}
+		percent := float64(len(clients)) * 100 / float64(len(snapshot.Clients))
+		return percent > r.Threshold, clients, percent
+	case ConditionClientDisappeared:
+		for id := range seen {
+			if snapshot.Client(id) == nil {
+				clients = append(clients, id)
+			}
+		}
+		sort.Strings(clients)
+		return len(clients) != 0, clients, 0
+	case ConditionNoClients:
+		return len(snapshot.Clients) == 0, nil, 0
+	}
+	return false, nil, 0
+}
+
+// perClient checks if the condition of the rule is violated by single clients, whose durations
+// are tracked separately, rather than by the clients as a whole
+func (r *AlertRule) perClient() bool {
+	return r.Condition == ConditionNacked || r.Condition == ConditionClientDisappeared
+}
+
+// violators returns the keys violatedSince tracks the violation of the rule under, which are
+// clients if the condition is violated by single clients, or the empty key if it is violated at all
+func (r *AlertRule) violators(violated bool, clients []string) []string {
+	if r.perClient() {
+		return clients
+	}
+	if violated {
+		return []string{""}
+	}
+	return nil
+}
+
+// isNacked checks if the client rejected any config of the control plane
+func isNacked(c *ClientSnapshot) bool {
+	for _, x := range c.Xds {
+		// the control plane reports ERROR for the xds configs the client nacked
+		if x.Status == "ERROR" || x.Status == "NACKED" {
+			return true
+		}
+		for _, r := range x.Resources {
+			if r.State == ResourceNacked {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Evaluate checks the rules against snapshot, and returns the alerts that fire or resolve. seen holds
+// the clients connected before snapshot. If oneShot is set, the rules fire as soon as they are
+// violated, since there will be no other snapshot to wait for. Otherwise, a rule of nacked or
+// client_disappeared fires once a client has violated it for the duration of the rule, and the
+// alert lists those clients, while the other rules fire once the clients as a whole have. While a
+// rule of nacked or client_disappeared is firing, another alert fires for the clients that newly
+// violate it. The clients that are gone are dropped from seen once every rule of
+// client_disappeared has fired for them, so that the rules resolve.
+func (rules *AlertRules) Evaluate(snapshot *Snapshot, seen map[string]bool, oneShot bool) []Alert {
+	var alerts []Alert
+	for _, rule := range rules.Rules {
+		violated, clients, value := rule.violation(snapshot, seen)
+		alert := Alert{
+			Rule:      rule.Name,
+			Condition: rule.Condition,
+			Time:      snapshot.Time,
+			Clients:   clients,
+			Value:     value,
+		}
+		// the clients that have violated the condition for the duration of the rule, and those of
+		// them no alert has been sent for
+		var lasting, added []string
+		since := make(map[string]time.Time)
+		notified := make(map[string]bool)
+		for _, key := range rule.violators(violated, clients) {
+			start, ok := rule.violatedSince[key]
+			if !ok {
+				start = snapshot.Time
+			}
+			since[key] = start
+			if oneShot || snapshot.Time.Sub(start) >= rule.duration {
+				lasting = append(lasting, key)
+				if !rule.notified[key] {
+					added = append(added, key)
+				}
+				notified[key] = true
+			}
+		}
+		rule.violatedSince = since
+		rule.notified = notified
+		if rule.perClient() {
+			alert.Clients = added
+		}
+
+		if len(lasting) == 0 {
+			if rule.firing {
+				rule.firing = false
+				alert.State = AlertResolved
+				alerts = append(alerts, alert)
+			}
+			continue
+		}
+		if len(added) != 0 {
+			rule.firing = true
+			alert.State = AlertFiring
+			alerts = append(alerts, alert)
+		}
+	}
+
+	for id := range seen {
+		if snapshot.Client(id) == nil && rules.reportedGone(id) {
+			delete(seen, id)
+		}
+	}
+	return alerts
+}
+
+// reportedGone checks if every rule of client_disappeared has fired for the client with id
+func (rules *AlertRules) reportedGone(id string) bool {
+	for _, rule := range rules.Rules {
+		if rule.Condition == ConditionClientDisappeared && !rule.notified[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// Firing returns the rules that are firing
+func (rules *AlertRules) Firing() []*AlertRule {
+	var firing []*AlertRule
+	for _, rule := range rules.Rules {
+		if rule.firing {
+			firing = append(firing, rule)
+		}
+	}
+	return firing
+}
+
+// Rule returns the rule with name
+func (rules *AlertRules) Rule(name string) *AlertRule {
+	for _, rule := range rules.Rules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Notify prints out alert to stdout and runs the hooks of its rule, whose output goes to stdout and
+// stderr. A failed hook does not stop monitoring, so the error is only printed out to stderr.
+func (rules *AlertRules) Notify(alert Alert, stdout io.Writer, stderr io.Writer) {
+	if len(alert.Clients) != 0 {
+		fmt.Fprintf(stdout, "Alert %v is %v: %v\n", alert.Rule, alert.State, alert.Clients)
+	} else {
+		fmt.Fprintf(stdout, "Alert %v is %v\n", alert.Rule, alert.State)
+	}
+
+	rule := rules.Rule(alert.Rule)
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to marshal alert %v: %v\n", alert.Rule, err)
+		return
+	}
+	if rule.Command != "" {
+		if err := runAlertCommand(rule.Command, alert, payload, stdout, stderr); err != nil {
+			fmt.Fprintf(stderr, "failed to run command of alert %v: %v\n", alert.Rule, err)
+		}
+	}
+	if rule.Webhook != "" {
+		if err := postAlert(rule.Webhook, payload); err != nil {
+			fmt.Fprintf(stderr, "failed to post alert %v: %v\n", alert.Rule, err)
+		}
+	}
+}
+
+// runAlertCommand runs command in the shell with payload in its stdin and its output in stdout and
+// stderr, the rule and the state of the alert are also passed in the ALERT_RULE and ALERT_STATE
+// environment variables
+func runAlertCommand(command string, alert Alert, payload []byte, stdout io.Writer, stderr io.Writer) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(), "ALERT_RULE="+alert.Rule, "ALERT_STATE="+alert.State)
+	return cmd.Run()
+}
+
+// postAlert posts payload to webhook
+func postAlert(webhook string, payload []byte) error {
+	httpClient := &http.Client{Timeout: webhookTimeout}
+	resp, err := httpClient.Post(webhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook responded %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// searchKeys maps the query parameters of /search to the xds types of the resources they search for
+var searchKeys = map[string]string{
+	"listener":     "LDS",
+	"route":        "RDS",
+	"scoped_route": "SRDS",
+	"cluster":      "CDS",
+	"endpoint":     "EDS",
+}
+
+// API answers the queries of the http json api in serve mode from the latest snapshot
+type API struct {
+	mu       sync.RWMutex
+	snapshot *Snapshot
+}
+
+// apiClient is a client in the responses of the api
+type apiClient struct {
+	ID         string                 `json:"id"`
+	StreamType string                 `json:"stream_type,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Xds        []apiXds               `json:"xds"`
+}
+
+// apiXds is an xds config of a client in the responses of the api
+type apiXds struct {
+	Type      string        `json:"type"`
+	Status    string        `json:"status"`
+	Version   string        `json:"version,omitempty"`
+	Resources []apiResource `json:"resources,omitempty"`
+}
+
+// apiResource is a resource in the responses of the api, the config is only included when the
+// resources of a client are queried
+type apiResource struct {
+	NodeID      string          `json:"node_id,omitempty"`
+	Xds         string          `json:"xds,omitempty"`
+	Name        string          `json:"name"`
+	State       string          `json:"state"`
+	Version     string          `json:"version,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	LastUpdated *time.Time      `json:"last_updated,omitempty"`
+	Config      json.RawMessage `json:"config,omitempty"`
+}
+
+// NewAPI creates an API without any snapshot
+func NewAPI() *API {
+	return &API{}
+}
+
+// Update replaces the snapshot the api answers from
+func (a *API) Update(snapshot *Snapshot) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.snapshot = snapshot
+}
+
+// Handler returns the handler of the endpoints of the api:
+//
+//	/clients                               lists the clients and the status of their xds configs
+//	/clients/{id}                          shows a client and the resources of its xds configs
+//	/clients/{id}/resources?type=&name=    lists the resources of a client with their configs
+//	/search?cluster=&listener=&route=...   finds the clients that have the resources
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clients", a.handleClients)
+	mux.HandleFunc("/clients/", a.handleClient)
+	mux.HandleFunc("/search", a.handleSearch)
+	return mux
+}
+
+// writeJson writes v as the json response with status code
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// writeError writes the json error response with status code
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJson(w, code, map[string]string{"error": message})
+}
+
+// latest returns the snapshot to answer from, or writes an error response if there is none yet
+func (a *API) latest(w http.ResponseWriter) *Snapshot {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.snapshot == nil {
+		writeError(w, http.StatusServiceUnavailable, "no response from the control plane yet")
+	}
+	return a.snapshot
+}
+
+// newApiClient converts client c to the api format, the resources are only listed if withResources is set
+func newApiClient(c *ClientSnapshot, withResources bool) apiClient {
+	client := apiClient{ID: c.ID, StreamType: c.StreamType, Metadata: c.Metadata, Xds: []apiXds{}}
+	for _, x := range c.Xds {
+		xds := apiXds{Type: x.Type, Status: x.Status, Version: x.Version}
+		if withResources {
+			for _, r := range x.Resources {
+				xds.Resources = append(xds.Resources, newApiResource(r, false))
+			}
+		}
+		client.Xds = append(client.Xds, xds)
+	}
+	return client
+}
+
+// newApiResource converts resource r to the api format, the config is only included if withConfig is set
+func newApiResource(r *ResourceSnapshot, withConfig bool) apiResource {
+	resource := apiResource{Name: r.Name, State: r.State, Version: r.Version, Error: r.Error}
+	if !r.LastUpdated.IsZero() {
+		lastUpdated := r.LastUpdated
+		resource.LastUpdated = &lastUpdated
+	}
+	if withConfig && r.Config != nil {
+		if config, err := marshalConfig(r.Config); err == nil {
+			resource.Config = config
+		}
+	}
+	return resource
+}
+
+// handleClients serves /clients
+func (a *API) handleClients(w http.ResponseWriter, r *http.Request) {
+	snapshot := a.latest(w)
+	if snapshot == nil {
+		return
+	}
+	clients := []apiClient{}
+	for _, c := range snapshot.Clients {
+		clients = append(clients, newApiClient(c, false))
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{"time": snapshot.Time, "clients": clients})
+}
+
+// handleClient serves /clients/{id} and /clients/{id}/resources
+func (a *API) handleClient(w http.ResponseWriter, r *http.Request) {
+	snapshot := a.latest(w)
+	if snapshot == nil {
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/clients/")
+	id, resources := path, false
+	if strings.HasSuffix(path, "/resources") {
+		id, resources = strings.TrimSuffix(path, "/resources"), true
+	}
+	c := snapshot.Client(id)
+	if c == nil {
+		writeError(w, http.StatusNotFound, "client "+id+" not found")
+		return
+	}
+	if !resources {
+		writeJson(w, http.StatusOK, newApiClient(c, true))
+		return
+	}
+
+	xdsType := strings.ToUpper(r.URL.Query().Get("type"))
+	name := r.URL.Query().Get("name")
+	list := []apiResource{}
+	for _, x := range c.Xds {
+		if xdsType != "" && x.Type != xdsType {
+			continue
+		}
+		for _, res := range x.Resources {
+			if name != "" && res.Name != name {
+				continue
+			}
+			resource := newApiResource(res, true)
+			resource.Xds = x.Type
+			list = append(list, resource)
+		}
+	}
+	writeJson(w, http.StatusOK, list)
+}
+
+// handleSearch serves /search
+func (a *API) handleSearch(w http.ResponseWriter, r *http.Request) {
+	snapshot := a.latest(w)
+	if snapshot == nil {
+		return
+	}
+	query := r.URL.Query()
+	names := make(map[string]string)
+	for key, xdsType := range searchKeys {
+		if name := query.Get(key); name != "" {
+			names[xdsType] = name
+		}
+	}
+	if len(names) == 0 {
+		writeError(w, http.StatusBadRequest, "missing query, e.g. /search?cluster=foo")
+		return
+	}
+
+	list := []apiResource{}
+	for _, c := range snapshot.Clients {
+		for _, x := range c.Xds {
+			name, ok := names[x.Type]
+			if !ok {
+				continue
+			}
+			if res := x.Resource(name); res != nil {
+				resource := newApiResource(res, false)
+				resource.NodeID = c.ID
+				resource.Xds = x.Type
+				list = append(list, resource)
+			}
+		}
+	}
+	writeJson(w, http.StatusOK, list)
+}
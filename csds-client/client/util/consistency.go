@@ -0,0 +1,270 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// consistencyExitCode is the exit code of consistency in one-shot mode, or at the end of a replay,
+// if any client is inconsistent with its group
+const consistencyExitCode = 2
+
+// Consistency compares the configs of the clients in the same group, which is the value of a
+// metadata key of the clients
+type Consistency struct {
+	// GroupBy is the metadata key to group the clients by, the keys of nested structs are joined
+	// with dots, e.g. labels.app
+	GroupBy string
+}
+
+// ConsistencyReport is the result of comparing the clients of a snapshot
+type ConsistencyReport struct {
+	Groups []ConsistencyGroup
+	// Ungrouped holds the clients without the metadata key, which are not compared
+	Ungrouped []string
+}
+
+// ConsistencyGroup is the clients with the same value of the metadata key and their resources that
+// differ from the majority of the group
+type ConsistencyGroup struct {
+	Value           string
+	Clients         []string
+	Inconsistencies []Inconsistency
+}
+
+// Inconsistency is a resource of a client whose version or content differs from the one most of
+// the clients of its group hold
+type Inconsistency struct {
+	Client   string
+	Xds      string
+	Resource string
+	// Version and MajorityVersion are the versions of the resource of the client and of the
+	// majority, empty if the resource is missing
+	Version         string
+	MajorityVersion string
+	// Missing is set if the client does not have the resource the majority has, and Extra is set if
+	// the client has the resource the majority does not have
+	Missing bool
+	Extra   bool
+	// Majority holds the clients of the group that hold the resource as the majority does
+	Majority []string
+	// Fields holds the fields of the resource of the client that differ from the one of the majority
+	Fields []FieldDiff
+}
+
+// NewConsistency creates a Consistency that groups the clients by the metadata key groupBy
+func NewConsistency(groupBy string) *Consistency {
+	return &Consistency{GroupBy: groupBy}
+}
+
+// consistencyKey identifies a resource across the clients
+type consistencyKey struct {
+	xds  string
+	name string
+}
+
+// Check groups the clients of snapshot and compares each resource of the clients in each group.
+// The clients holding the same version and content of a resource form a variant, and the clients
+// outside of the variant with the most clients are inconsistent. A tie goes to the variant of the
+// client that comes first in the response. The groups are sorted by their value.
+func (c *Consistency) Check(snapshot *Snapshot) ConsistencyReport {
+	report := ConsistencyReport{}
+	groups := make(map[string][]*ClientSnapshot)
+	for _, client := range snapshot.Clients {
+		value, ok := c.groupValue(client)
+		if !ok {
+			report.Ungrouped = append(report.Ungrouped, client.ID)
+			continue
+		}
+		groups[value] = append(groups[value], client)
+	}
+	var values []string
+	for value := range groups {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		report.Groups = append(report.Groups, checkGroup(value, groups[value]))
+	}
+	return report
+}
+
+// groupValue returns the value of the metadata key of client, or false if the client does not have it
+func (c *Consistency) groupValue(client *ClientSnapshot) (string, bool) {
+	var value interface{} = client.Metadata
+	for _, key := range strings.Split(c.GroupBy, ".") {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = fields[key]; !ok {
+			return "", false
+		}
+	}
+	if s, ok := value.(string); ok {
+		return s, true
+	}
+	b, err := json.Marshal(value)
+	return string(b), err == nil
+}
+
+// checkGroup compares the resources of clients, which have value as the metadata key
+func checkGroup(value string, clients []*ClientSnapshot) ConsistencyGroup {
+	// the inconsistencies are listed by client in the order of the response, then by resource
+	group := ConsistencyGroup{Value: value}
+	var keys []consistencyKey
+	seen := make(map[consistencyKey]bool)
+	for _, client := range clients {
+		group.Clients = append(group.Clients, client.ID)
+		for _, x := range client.Xds {
+			for _, r := range x.Resources {
+				key := consistencyKey{xds: x.Type, name: r.Name}
+				if !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+	if len(clients) < 2 {
+		return group
+	}
+
+	for _, key := range keys {
+		// the resources of the clients, nil if a client does not have it, and the clients of each variant
+		resources := make([]*ResourceSnapshot, len(clients))
+		var variants [][]int
+		for i, client := range clients {
+			if x := client.XdsConfig(key.xds); x != nil {
+				resources[i] = x.Resource(key.name)
+			}
+			found := false
+			for v, variant := range variants {
+				if sameResource(resources[variant[0]], resources[i]) {
+					variants[v] = append(variant, i)
+					found = true
+					break
+				}
+			}
+			if !found {
+				variants = append(variants, []int{i})
+			}
+		}
+		if len(variants) == 1 {
+			continue
+		}
+		majority := variants[0]
+		for _, variant := range variants[1:] {
+			if len(variant) > len(majority) {
+				majority = variant
+			}
+		}
+		var majorityClients []string
+		for _, i := range majority {
+			majorityClients = append(majorityClients, clients[i].ID)
+		}
+		majorityResource := resources[majority[0]]
+
+		inMajority := make(map[int]bool)
+		for _, i := range majority {
+			inMajority[i] = true
+		}
+		for i, client := range clients {
+			if inMajority[i] {
+				continue
+			}
+			resource := resources[i]
+			inconsistency := Inconsistency{
+				Client:   client.ID,
+				Xds:      key.xds,
+				Resource: key.name,
+				Missing:  resource == nil,
+				Extra:    majorityResource == nil,
+				Majority: majorityClients,
+			}
+			if resource != nil {
+				inconsistency.Version = resource.Version
+			}
+			if majorityResource != nil {
+				inconsistency.MajorityVersion = majorityResource.Version
+			}
+			if resource != nil && majorityResource != nil {
+				inconsistency.Fields = diffResource(majorityResource, resource)
+			}
+			group.Inconsistencies = append(group.Inconsistencies, inconsistency)
+		}
+	}
+	order := make(map[string]int)
+	for i, client := range group.Clients {
+		order[client] = i
+	}
+	sort.SliceStable(group.Inconsistencies, func(i, j int) bool {
+		return order[group.Inconsistencies[i].Client] < order[group.Inconsistencies[j].Client]
+	})
+	return group
+}
+
+// sameResource checks if the resources a and b, which may be nil, have the same version and content.
+// The contents are compared field by field, since the same config can be encoded in different bytes.
+func sameResource(a *ResourceSnapshot, b *ResourceSnapshot) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Version == b.Version && len(diffResource(a, b)) == 0
+}
+
+// Inconsistent checks if any client of the report is inconsistent with its group
+func (r ConsistencyReport) Inconsistent() bool {
+	for _, group := range r.Groups {
+		if len(group.Inconsistencies) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the inconsistency in a line, followed by a line for each field that differs
+// from the majority
+func (i Inconsistency) String() string {
+	resource := i.Client + " " + i.Xds + " " + i.Resource
+	majority := fmt.Sprintf("majority has version %v (%v)", i.MajorityVersion, listClients(i.Majority))
+	switch {
+	case i.Missing:
+		return fmt.Sprintf("- %v missing, %v", resource, majority)
+	case i.Extra:
+		return fmt.Sprintf("+ %v extra, majority does not have it (%v)", resource, listClients(i.Majority))
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "~ %v version %v, %v", resource, i.Version, majority)
+	for _, field := range i.Fields {
+		path := field.Path
+		if path == "" {
+			path = "<resource>"
+		}
+		fmt.Fprintf(&buf, "\n    %v: %v -> %v", path, formatJsonValue(field.Old), formatJsonValue(field.New))
+	}
+	return buf.String()
+}
+
+// Print prints out report at t to w, with a line for each group followed by its inconsistencies
+func (c *Consistency) Print(w io.Writer, report ConsistencyReport, t time.Time) {
+	fmt.Fprintf(w, "[%v] %d group(s) by %v\n", t.Format(time.RFC3339), len(report.Groups), c.GroupBy)
+	for _, group := range report.Groups {
+		if len(group.Inconsistencies) == 0 {
+			fmt.Fprintf(w, "%v=%v: %d client(s), consistent\n", c.GroupBy, group.Value, len(group.Clients))
+			continue
+		}
+		fmt.Fprintf(w, "%v=%v: %d client(s), %d inconsistent resource(s)\n", c.GroupBy, group.Value, len(group.Clients), len(group.Inconsistencies))
+		for _, inconsistency := range group.Inconsistencies {
+			fmt.Fprintln(w, "  "+strings.ReplaceAll(inconsistency.String(), "\n", "\n  "))
+		}
+	}
+	if len(report.Ungrouped) != 0 {
+		fmt.Fprintf(w, "without %v, not compared: %v\n", c.GroupBy, listClients(report.Ungrouped))
+	}
+}
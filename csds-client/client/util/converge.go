@@ -0,0 +1,149 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// notConvergedExitCode is the exit code of converge with -input_file or in replay if the rollout
+// has not converged after the last response
+const notConvergedExitCode = 1
+
+// maxListedClients is the maximum number of laggards and nackers listed in the progress of a rollout
+const maxListedClients = 10
+
+// Convergence tracks the rollout of a config version of an xds type to the clients
+type Convergence struct {
+	Xds       string
+	Version   string
+	Threshold float64
+	Timeout   time.Duration
+
+	start time.Time
+	// converged holds the time each client was first seen with the version
+	converged map[string]time.Time
+	// percent is the percentage of clients with the version in the latest snapshot
+	percent float64
+}
+
+// ConvergenceProgress is the state of a rollout in a snapshot
+type ConvergenceProgress struct {
+	Clients   int
+	Converged []string
+	// Laggards and Nackers hold the clients without the version as "id (version status)"
+	Laggards []string
+	Nackers  []string
+	Percent  float64
+}
+
+// NewConvergence starts tracking the rollout of version of xds at start
+func NewConvergence(xds string, version string, threshold float64, timeout time.Duration, start time.Time) *Convergence {
+	return &Convergence{
+		Xds:       xds,
+		Version:   version,
+		Threshold: threshold,
+		Timeout:   timeout,
+		start:     start,
+		converged: make(map[string]time.Time),
+	}
+}
+
+// Observe updates the rollout with snapshot and returns the progress of it
+func (c *Convergence) Observe(snapshot *Snapshot) ConvergenceProgress {
+	progress := ConvergenceProgress{Clients: len(snapshot.Clients)}
+	for _, client := range snapshot.Clients {
+		x := client.XdsConfig(c.Xds)
+		if x == nil {
+			x = &XdsSnapshot{Type: c.Xds, Status: "N/A"}
+		}
+		state := fmt.Sprintf("%v (%v %v)", client.ID, x.Version, x.Status)
+		nacked := x.Status == "ERROR" || x.Status == "NACKED"
+		for _, r := range x.Resources {
+			nacked = nacked || r.State == ResourceNacked
+		}
+		switch {
+		case nacked:
+			progress.Nackers = append(progress.Nackers, state)
+		case x.Version == c.Version:
+			progress.Converged = append(progress.Converged, client.ID)
+			if _, ok := c.converged[client.ID]; !ok {
+				c.converged[client.ID] = snapshot.Time
+			}
+		default:
+			progress.Laggards = append(progress.Laggards, state)
+		}
+	}
+	if progress.Clients != 0 {
+		progress.Percent = float64(len(progress.Converged)) * 100 / float64(progress.Clients)
+	}
+	c.percent = progress.Percent
+	return progress
+}
+
+// Done checks if the rollout reached the threshold, or failed to within the timeout at now. The
+// returned error is not nil if the rollout failed.
+func (c *Convergence) Done(now time.Time) (bool, error) {
+	if c.Converged() {
+		return true, nil
+	}
+	if c.Timeout > 0 && now.Sub(c.start) >= c.Timeout {
+		return true, fmt.Errorf("%v version %v did not converge to %v%% of the clients within %v (%.1f%%)", c.Xds, c.Version, c.Threshold, c.Timeout, c.percent)
+	}
+	return false, nil
+}
+
+// Converged checks if the rollout reached the threshold in the latest snapshot
+func (c *Convergence) Converged() bool {
+	return c.percent > 0 && c.percent >= c.Threshold
+}
+
+// Percentile returns the p-th percentile of the time it took the clients to converge, which is
+// rounded to seconds
+func (c *Convergence) Percentile(p float64) time.Duration {
+	if len(c.converged) == 0 {
+		return 0
+	}
+	var durations []time.Duration
+	for _, t := range c.converged {
+		d := t.Sub(c.start)
+		if d < 0 {
+			d = 0
+		}
+		durations = append(durations, d)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	// nearest-rank percentile
+	rank := int(math.Ceil(p / 100 * float64(len(durations))))
+	if rank < 1 {
+		rank = 1
+	}
+	return durations[rank-1].Round(time.Second)
+}
+
+// listClients formats at most maxListedClients of clients
+func listClients(clients []string) string {
+	if len(clients) <= maxListedClients {
+		return strings.Join(clients, ", ")
+	}
+	return fmt.Sprintf("%v and %d more", strings.Join(clients[:maxListedClients], ", "), len(clients)-maxListedClients)
+}
+
+// PrintProgress prints out the progress of the rollout at t to w
+func (c *Convergence) PrintProgress(w io.Writer, progress ConvergenceProgress, t time.Time) {
+	fmt.Fprintf(w, "[%v] %v %v: %d/%d clients (%.1f%%) converged, %d lagging, %d nacked\n",
+		t.Format(time.RFC3339), c.Xds, c.Version, len(progress.Converged), progress.Clients, progress.Percent,
+		len(progress.Laggards), len(progress.Nackers))
+	if len(progress.Laggards) != 0 {
+		fmt.Fprintf(w, "  lagging: %v\n", listClients(progress.Laggards))
+	}
+	if len(progress.Nackers) != 0 {
+		fmt.Fprintf(w, "  nacked: %v\n", listClients(progress.Nackers))
+	}
+	if len(c.converged) != 0 {
+		fmt.Fprintf(w, "  time to converge: p50 %v, p90 %v, p99 %v\n", c.Percentile(50), c.Percentile(90), c.Percentile(99))
+	}
+}
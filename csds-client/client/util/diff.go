@@ -0,0 +1,266 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Kinds of the changes between two snapshots
+const (
+	ClientConnected        string = "CLIENT_CONNECTED"
+	ClientDisconnected     string = "CLIENT_DISCONNECTED"
+	StatusChanged          string = "STATUS_CHANGED"
+	VersionChanged         string = "VERSION_CHANGED"
+	ResourceAdded          string = "RESOURCE_ADDED"
+	ResourceRemoved        string = "RESOURCE_REMOVED"
+	ResourceStateChanged   string = "RESOURCE_STATE_CHANGED"
+	ResourceVersionChanged string = "RESOURCE_VERSION_CHANGED"
+	ResourceContentChanged string = "RESOURCE_CONTENT_CHANGED"
+)
+
+// Change is a change of the clients between two snapshots
+type Change struct {
+	Kind   string
+	NodeID string
+	// Xds is the short name of the xds the change happens in, empty for changes of the client
+	Xds string
+	// Resource is the name of the resource the change happens to, empty for changes of the client or the xds
+	Resource string
+	// Old and New are the status, version or state before and after the change
+	Old string
+	New string
+	// Details holds the reason of the rejection if the resource is nacked after the change
+	Details string
+	// Fields holds the changed fields of the resource if Kind is ResourceContentChanged
+	Fields []FieldDiff
+}
+
+// FieldDiff is a changed field of a resource
+type FieldDiff struct {
+	// Path locates the field in the json of the resource, e.g. virtualHosts[0].routes[1].route.cluster
+	Path string
+	// Old and New are the json values of the field, nil if the field is not present
+	Old interface{}
+	New interface{}
+}
+
+// DiffSnapshots lists the changes from the snapshot old to the snapshot new
+func DiffSnapshots(old *Snapshot, new *Snapshot) []Change {
+	var changes []Change
+	for _, c := range old.Clients {
+		if new.Client(c.ID) == nil {
+			changes = append(changes, Change{Kind: ClientDisconnected, NodeID: c.ID})
+		}
+	}
+	for _, c := range new.Clients {
+		oldClient := old.Client(c.ID)
+		if oldClient == nil {
+			changes = append(changes, Change{Kind: ClientConnected, NodeID: c.ID, New: c.StreamType})
+			continue
+		}
+		for _, x := range c.Xds {
+			oldXds := oldClient.XdsConfig(x.Type)
+			if oldXds == nil {
+				oldXds = &XdsSnapshot{Type: x.Type}
+			}
+			changes = append(changes, diffXds(c.ID, oldXds, x)...)
+		}
+		for _, x := range oldClient.Xds {
+			if c.XdsConfig(x.Type) == nil {
+				changes = append(changes, diffXds(c.ID, x, &XdsSnapshot{Type: x.Type})...)
+			}
+		}
+	}
+	return changes
+}
+
+// ConnectionChanges lists the changes of client c from nothing to its state in the snapshot, i.e.
+// the initial status, versions and resources of each of its xds configs
+func ConnectionChanges(c *ClientSnapshot) []Change {
+	var changes []Change
+	for _, x := range c.Xds {
+		changes = append(changes, diffXds(c.ID, &XdsSnapshot{Type: x.Type}, x)...)
+	}
+	return changes
+}
+
+// diffXds lists the changes from the xds config old to the xds config new of the client with node id
+func diffXds(id string, old *XdsSnapshot, new *XdsSnapshot) []Change {
+	var changes []Change
+	if old.Status != new.Status {
+		changes = append(changes, Change{Kind: StatusChanged, NodeID: id, Xds: new.Type, Old: old.Status, New: new.Status})
+	}
+	if old.Version != new.Version {
+		changes = append(changes, Change{Kind: VersionChanged, NodeID: id, Xds: new.Type, Old: old.Version, New: new.Version})
+	}
+
+	for _, r := range old.Resources {
+		if new.Resource(r.Name) == nil {
+			changes = append(changes, Change{Kind: ResourceRemoved, NodeID: id, Xds: new.Type, Resource: r.Name, Old: r.State})
+		}
+	}
+	for _, r := range new.Resources {
+		oldResource := old.Resource(r.Name)
+		if oldResource == nil {
+			changes = append(changes, Change{Kind: ResourceAdded, NodeID: id, Xds: new.Type, Resource: r.Name, New: r.State, Details: r.Error})
+			continue
+		}
+		if oldResource.State != r.State {
+			changes = append(changes, Change{Kind: ResourceStateChanged, NodeID: id, Xds: new.Type, Resource: r.Name, Old: oldResource.State, New: r.State, Details: r.Error})
+		}
+		if oldResource.Version != r.Version {
+			changes = append(changes, Change{Kind: ResourceVersionChanged, NodeID: id, Xds: new.Type, Resource: r.Name, Old: oldResource.Version, New: r.Version})
+		}
+		if fields := diffResource(oldResource, r); len(fields) != 0 {
+			changes = append(changes, Change{Kind: ResourceContentChanged, NodeID: id, Xds: new.Type, Resource: r.Name, Fields: fields})
+		}
+	}
+	return changes
+}
+
+// diffResource lists the changed fields from the resource old to the resource new
+func diffResource(old *ResourceSnapshot, new *ResourceSnapshot) []FieldDiff {
+	if bytes.Equal(old.Config.GetValue(), new.Config.GetValue()) && old.Config.GetTypeUrl() == new.Config.GetTypeUrl() {
+		return nil
+	}
+	oldJson, oldErr := resourceJson(old)
+	newJson, newErr := resourceJson(new)
+	if oldErr != nil || newErr != nil {
+		// the resource cannot be parsed, so only the fact that it changed is known
+		return []FieldDiff{{Path: "", Old: "<unparsable>", New: "<unparsable>"}}
+	}
+	return DiffJson(oldJson, newJson)
+}
+
+// resourceJson parses the config of resource to a json value
+func resourceJson(resource *ResourceSnapshot) (interface{}, error) {
+	if resource.Config == nil {
+		return nil, nil
+	}
+	b, err := marshalConfig(resource.Config)
+	if err != nil {
+		return nil, err
+	}
+	var js interface{}
+	err = json.Unmarshal(b, &js)
+	return js, err
+}
+
+// DiffJson lists the changed fields from the json value old to the json value new. Objects are
+// compared field by field and lists are compared element by element.
+func DiffJson(old interface{}, new interface{}) []FieldDiff {
+	var fields []FieldDiff
+	diffJsonValue("", old, new, &fields)
+	return fields
+}
+
+// diffJsonValue appends the changed fields under path from old to new to fields
+func diffJsonValue(path string, old interface{}, new interface{}, fields *[]FieldDiff) {
+	switch oldValue := old.(type) {
+	case map[string]interface{}:
+		if newValue, ok := new.(map[string]interface{}); ok {
+			keys := make(map[string]bool)
+			for key := range oldValue {
+				keys[key] = true
+			}
+			for key := range newValue {
+				keys[key] = true
+			}
+			var sorted []string
+			for key := range keys {
+				sorted = append(sorted, key)
+			}
+			sort.Strings(sorted)
+			for _, key := range sorted {
+				fieldPath := key
+				if path != "" {
+					fieldPath = path + "." + key
+				}
+				diffJsonValue(fieldPath, oldValue[key], newValue[key], fields)
+			}
+			return
+		}
+	case []interface{}:
+		if newValue, ok := new.([]interface{}); ok {
+			for i := 0; i < len(oldValue) || i < len(newValue); i++ {
+				var oldElement, newElement interface{}
+				if i < len(oldValue) {
+					oldElement = oldValue[i]
+				}
+				if i < len(newValue) {
+					newElement = newValue[i]
+				}
+				diffJsonValue(fmt.Sprintf("%v[%d]", path, i), oldElement, newElement, fields)
+			}
+			return
+		}
+	}
+	oldJson, _ := json.Marshal(old)
+	newJson, _ := json.Marshal(new)
+	if !bytes.Equal(oldJson, newJson) {
+		*fields = append(*fields, FieldDiff{Path: path, Old: old, New: new})
+	}
+}
+
+// formatJsonValue formats a json value in a diff, where nil means the value is not present
+func formatJsonValue(value interface{}) string {
+	if value == nil {
+		return "<none>"
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
+
+// String formats the change in a line, followed by a line for each changed field
+func (c Change) String() string {
+	resource := c.NodeID + " " + c.Xds + " " + c.Resource
+	switch c.Kind {
+	case ClientConnected:
+		if c.New == "" {
+			return fmt.Sprintf("+ client %v connected", c.NodeID)
+		}
+		return fmt.Sprintf("+ client %v connected (%v)", c.NodeID, c.New)
+	case ClientDisconnected:
+		return fmt.Sprintf("- client %v disconnected", c.NodeID)
+	case StatusChanged:
+		return fmt.Sprintf("~ %v %v status: %v -> %v", c.NodeID, c.Xds, c.Old, c.New)
+	case VersionChanged:
+		return fmt.Sprintf("~ %v %v version: %v -> %v", c.NodeID, c.Xds, c.Old, c.New)
+	case ResourceAdded:
+		return fmt.Sprintf("+ %v added (%v)", resource, c.New)
+	case ResourceRemoved:
+		return fmt.Sprintf("- %v removed", resource)
+	case ResourceStateChanged:
+		return fmt.Sprintf("~ %v state: %v -> %v", resource, c.Old, c.New)
+	case ResourceVersionChanged:
+		return fmt.Sprintf("~ %v version: %v -> %v", resource, c.Old, c.New)
+	case ResourceContentChanged:
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "~ %v changed:", resource)
+		for _, field := range c.Fields {
+			path := field.Path
+			if path == "" {
+				path = "<resource>"
+			}
+			fmt.Fprintf(&buf, "\n    %v: %v -> %v", path, formatJsonValue(field.Old), formatJsonValue(field.New))
+		}
+		return buf.String()
+	default:
+		return fmt.Sprintf("? %v %v", c.Kind, resource)
+	}
+}
+
+// PrintChanges prints out the changes found at t to w, nothing is printed if there is no change
+func PrintChanges(w io.Writer, changes []Change, t time.Time) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "[%v] %d change(s)\n", t.Format(time.RFC3339), len(changes))
+	for _, change := range changes {
+		fmt.Fprintln(w, change.String())
+	}
+}
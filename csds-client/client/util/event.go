@@ -0,0 +1,74 @@
+package util
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Event is a line of the event log
+type Event struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	NodeID   string    `json:"node_id"`
+	Xds      string    `json:"xds,omitempty"`
+	Resource string    `json:"resource,omitempty"`
+	Old      string    `json:"old"`
+	New      string    `json:"new"`
+	Details  string    `json:"details,omitempty"`
+	// Fields holds the paths of the changed fields if Kind is ResourceContentChanged
+	Fields []string `json:"fields,omitempty"`
+	// Initial is set for the events of the first response of a run, which record the state the
+	// clients are in when the run starts rather than changes
+	Initial bool `json:"initial,omitempty"`
+}
+
+// EventLog appends the changes of the clients as json lines to a file
+type EventLog struct {
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// OpenEventLog opens the event log at path, the events are appended if the file already exists
+func OpenEventLog(path string) (*EventLog, error) {
+	filename, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{file: f, encoder: json.NewEncoder(f)}, nil
+}
+
+// Write appends an event for each of changes found at t, initial is set if the changes are the
+// state of the clients in the first response of the run
+func (l *EventLog) Write(changes []Change, t time.Time, initial bool) error {
+	for _, change := range changes {
+		event := Event{
+			Time:     t,
+			Kind:     change.Kind,
+			NodeID:   change.NodeID,
+			Xds:      change.Xds,
+			Resource: change.Resource,
+			Old:      change.Old,
+			New:      change.New,
+			Details:  change.Details,
+			Initial:  initial,
+		}
+		for _, field := range change.Fields {
+			event.Fields = append(event.Fields, field.Path)
+		}
+		if err := l.encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the file of the event log
+func (l *EventLog) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,173 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ToV3NodeMatchers converts NodeMatchers of any xds api version to v3 NodeMatchers
+func ToV3NodeMatchers(nodeMatchers []proto.Message) ([]*envoy_type_matcher_v3.NodeMatcher, error) {
+	var v3NodeMatchers []*envoy_type_matcher_v3.NodeMatcher
+	for _, nodeMatcher := range nodeMatchers {
+		v3NodeMatcher := &envoy_type_matcher_v3.NodeMatcher{}
+		if err := ConvertMessage(nodeMatcher, v3NodeMatcher); err != nil {
+			return nil, err
+		}
+		v3NodeMatchers = append(v3NodeMatchers, v3NodeMatcher)
+	}
+	return v3NodeMatchers, nil
+}
+
+// FilterClients removes the clients that do not match any of nodeMatchers from response, the way
+// the control plane would have matched them. It is used when the response is not returned by a
+// control plane, e.g. in offline mode.
+func FilterClients(response proto.Message, nodeMatchers []*envoy_type_matcher_v3.NodeMatcher) error {
+	if len(nodeMatchers) == 0 {
+		return nil
+	}
+	m := response.ProtoReflect()
+	fd := m.Descriptor().Fields().ByName("config")
+	if fd == nil || !fd.IsList() {
+		return fmt.Errorf("%v is not a csds response", m.Descriptor().FullName())
+	}
+	if !m.Has(fd) {
+		return nil
+	}
+
+	list := m.Mutable(fd).List()
+	n := 0
+	for i := 0; i < list.Len(); i++ {
+		config, err := ToV3ClientConfig(list.Get(i).Message().Interface())
+		if err != nil {
+			return err
+		}
+		matched, err := MatchNode(config.GetNode(), nodeMatchers)
+		if err != nil {
+			return err
+		}
+		if matched {
+			list.Set(n, list.Get(i))
+			n++
+		}
+	}
+	list.Truncate(n)
+	return nil
+}
+
+// MatchNode checks if node matches any of nodeMatchers
+func MatchNode(node *envoy_config_core_v3.Node, nodeMatchers []*envoy_type_matcher_v3.NodeMatcher) (bool, error) {
+	for _, nodeMatcher := range nodeMatchers {
+		matched, err := matchNode(node, nodeMatcher)
+		if err != nil {
+			return false, err
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchNode checks if node matches the node id and all the metadata matchers of nodeMatcher
+func matchNode(node *envoy_config_core_v3.Node, nodeMatcher *envoy_type_matcher_v3.NodeMatcher) (bool, error) {
+	if nodeMatcher.GetNodeId() != nil {
+		matched, err := matchString(node.GetId(), nodeMatcher.GetNodeId())
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	for _, structMatcher := range nodeMatcher.GetNodeMetadatas() {
+		value := structpb.NewStructValue(node.GetMetadata())
+		for _, segment := range structMatcher.GetPath() {
+			value = value.GetStructValue().GetFields()[segment.GetKey()]
+		}
+		matched, err := matchValue(value, structMatcher.GetValue())
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchValue checks if value matches valueMatcher, value is nil if it is not present
+func matchValue(value *structpb.Value, valueMatcher *envoy_type_matcher_v3.ValueMatcher) (bool, error) {
+	switch pattern := valueMatcher.GetMatchPattern().(type) {
+	case *envoy_type_matcher_v3.ValueMatcher_NullMatch_:
+		_, ok := value.GetKind().(*structpb.Value_NullValue)
+		return ok, nil
+	case *envoy_type_matcher_v3.ValueMatcher_DoubleMatch:
+		number, ok := value.GetKind().(*structpb.Value_NumberValue)
+		if !ok {
+			return false, nil
+		}
+		if r := pattern.DoubleMatch.GetRange(); r != nil {
+			return number.NumberValue >= r.GetStart() && number.NumberValue < r.GetEnd(), nil
+		}
+		return number.NumberValue == pattern.DoubleMatch.GetExact(), nil
+	case *envoy_type_matcher_v3.ValueMatcher_StringMatch:
+		str, ok := value.GetKind().(*structpb.Value_StringValue)
+		if !ok {
+			return false, nil
+		}
+		return matchString(str.StringValue, pattern.StringMatch)
+	case *envoy_type_matcher_v3.ValueMatcher_BoolMatch:
+		b, ok := value.GetKind().(*structpb.Value_BoolValue)
+		return ok && b.BoolValue == pattern.BoolMatch, nil
+	case *envoy_type_matcher_v3.ValueMatcher_PresentMatch:
+		return (value != nil) == pattern.PresentMatch, nil
+	case *envoy_type_matcher_v3.ValueMatcher_ListMatch:
+		for _, element := range value.GetListValue().GetValues() {
+			matched, err := matchValue(element, pattern.ListMatch.GetOneOf())
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("unsupported value matcher: %v", valueMatcher)
+	}
+}
+
+// matchString checks if str matches stringMatcher
+func matchString(str string, stringMatcher *envoy_type_matcher_v3.StringMatcher) (bool, error) {
+	// ignore_case has no effect for regular expressions
+	hasAffix := func(affix string, has func(string, string) bool) bool {
+		if stringMatcher.GetIgnoreCase() {
+			return has(strings.ToLower(str), strings.ToLower(affix))
+		}
+		return has(str, affix)
+	}
+
+	switch pattern := stringMatcher.GetMatchPattern().(type) {
+	case *envoy_type_matcher_v3.StringMatcher_Exact:
+		if stringMatcher.GetIgnoreCase() {
+			return strings.EqualFold(str, pattern.Exact), nil
+		}
+		return str == pattern.Exact, nil
+	case *envoy_type_matcher_v3.StringMatcher_Prefix:
+		return hasAffix(pattern.Prefix, strings.HasPrefix), nil
+	case *envoy_type_matcher_v3.StringMatcher_Suffix:
+		return hasAffix(pattern.Suffix, strings.HasSuffix), nil
+	case *envoy_type_matcher_v3.StringMatcher_SafeRegex:
+		return matchRegex(str, pattern.SafeRegex.GetRegex())
+	case *envoy_type_matcher_v3.StringMatcher_Contains:
+		return hasAffix(pattern.Contains, strings.Contains), nil
+	default:
+		return false, fmt.Errorf("unsupported string matcher: %v", stringMatcher)
+	}
+}
+
+// matchRegex checks if the whole str matches the regular expression expr
+func matchRegex(str string, expr string) (bool, error) {
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(str), nil
+}
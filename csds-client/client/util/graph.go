@@ -0,0 +1,803 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoy_extensions_filters_network_http_connection_manager_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	envoy_extensions_filters_network_tcp_proxy_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	envoy_extensions_transport_sockets_tls_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// graphXds lists the xds types of the resources in a response that are parsed into a Graph. The
+// resources of ECDS, VHDS, SDS and RTDS are only in the generic xds configs of a response.
+var graphXds = []string{"LDS", "ECDS", "SRDS", "RDS", "VHDS", "CDS", "EDS", "SDS", "RTDS"}
+
+// Kinds of the nodes of a Graph that are a part of a resource rather than a resource, which are
+// only in the graphs with more detail than GraphDetailResources
+const (
+	GraphFilterChain string = "FilterChain"
+	GraphVirtualHost string = "VirtualHost"
+	GraphRoute       string = "Route"
+	GraphEndpoint    string = "Endpoint"
+)
+
+// graphColumns lists the xds types and the kinds of the nodes of a Graph in the order they are shown
+var graphColumns = []string{
+	"LDS", GraphFilterChain, "ECDS", "SRDS", "RDS", "VHDS", GraphVirtualHost, GraphRoute, "CDS", "EDS", GraphEndpoint, "SDS", "RTDS",
+}
+
+// Detail levels of a Graph
+const (
+	// GraphDetailResources only has the resources
+	GraphDetailResources string = "resources"
+	// GraphDetailRoutes adds the filter chains of the listeners, and the virtual hosts and the
+	// routes of the route configurations, so the path of a request from a listener to a cluster
+	// can be traced
+	GraphDetailRoutes string = "routes"
+	// GraphDetailEndpoints adds the endpoints of the clusters to GraphDetailRoutes
+	GraphDetailEndpoints string = "endpoints"
+)
+
+// graphDetailLevels orders the detail levels of a Graph
+var graphDetailLevels = map[string]int{GraphDetailResources: 0, GraphDetailRoutes: 1, GraphDetailEndpoints: 2}
+
+// ResourceInline is the state of a route configuration or a scoped route configuration that is
+// defined inline in a listener rather than fetched with RDS or SRDS
+const ResourceInline string = "INLINE"
+
+// ResourceReferenced is the state of a resource of ECDS, VHDS or SDS that is referenced but not in
+// the csds response, which only has them in its generic xds configs, so it is only known to be
+// referenced
+const ResourceReferenced string = "REFERENCED"
+
+// Graph is the relationship between the xds resources of the clients in a response: the listeners
+// use route configurations or clusters, the route configurations use clusters and the clusters
+// have endpoints. Each client has its own nodes, so the same resource of two clients is two nodes.
+type Graph struct {
+	// Clients holds the ids of the clients in the order of the response, which are their node ids
+	// made unique as in a Snapshot, e.g. node_a#2 for the second client with node id node_a
+	Clients []string
+	// Nodes holds the resources ordered by client, then by xds type (LDS, RDS, CDS, EDS, ...) with
+	// the parts of the resources of a type after them, and in the order they are found within a
+	// type or a kind
+	Nodes []*GraphNode
+	// Edges holds the references between the resources in the order they are found
+	Edges []*GraphEdge
+}
+
+// GraphNode is a resource of a client, or a part of it, in a Graph
+type GraphNode struct {
+	// ID identifies the node in the graph, it is the node id of the client, the xds type and the
+	// name of the resource joined by "/", e.g. client_1/LDS/listener_1, so it stays the same
+	// across responses. The id of a part is the id of its parent, the kind and the name or the
+	// index of the part, e.g. client_1/RDS/route_1/VirtualHost/host_1/Route/0.
+	ID string
+	// Client is the id of the client of the resource in Clients
+	Client string
+	// Xds is the short name of the xds type of the resource, e.g. LDS
+	Xds string
+	// Kind is the kind of the part of the resource, e.g. Route, it is empty if the node is the
+	// resource itself
+	Kind string
+	Name string
+	// Detail describes the node in the graphs with more detail than GraphDetailResources, e.g. the
+	// address of a listener or the domains of a virtual host
+	Detail []string
+	// State is the state of the resource, e.g. ACTIVE, WARMING or DRAINING. It is empty if the
+	// resource is referenced by another one but is not in the response.
+	State string
+	// Error holds the details of the rejected update if State is NACKED
+	Error string
+	// Config is the resource itself, e.g. a Listener or a Cluster. It is nil if the resource is
+	// not in the response.
+	Config *anypb.Any
+	// Diff is the change of the node in a graph diff, i.e. GraphAdded, GraphRemoved or
+	// GraphChanged. It is empty if the node is unchanged or the graph is not a diff.
+	Diff string
+	// Changes describes what changed if Diff is GraphChanged, e.g. the state or the paths of the
+	// changed fields of the config
+	Changes []string
+}
+
+// GraphEdge is a reference from a resource to another one of the same client in a Graph
+type GraphEdge struct {
+	From *GraphNode
+	To   *GraphNode
+	// Label describes the reference in the graphs with more detail than GraphDetailResources, e.g.
+	// the share of the requests of a weighted cluster
+	Label string
+	// Diff is the change of the edge in a graph diff as GraphNode.Diff is
+	Diff string
+}
+
+// column returns the xds type or the kind of node, which groups the nodes when they are shown
+func (node *GraphNode) column() string {
+	if node.Kind != "" {
+		return node.Kind
+	}
+	return node.Xds
+}
+
+// GraphNodeID returns the id of the node of the resource of xds with name of client, which is the id
+// of the client in the graph
+func GraphNodeID(client string, xds string, name string) string {
+	return client + "/" + xds + "/" + name
+}
+
+// Node returns the node with id, or nil if it is not in the graph
+func (g *Graph) Node(id string) *GraphNode {
+	for _, node := range g.Nodes {
+		if node.ID == id {
+			return node
+		}
+	}
+	return nil
+}
+
+// ForClient returns the graph of the resources of client, or nil if the client is not in the graph
+func (g *Graph) ForClient(client string) *Graph {
+	for _, c := range g.Clients {
+		if c != client {
+			continue
+		}
+		graph := &Graph{Clients: []string{client}}
+		for _, node := range g.Nodes {
+			if node.Client == client {
+				graph.Nodes = append(graph.Nodes, node)
+			}
+		}
+		for _, edge := range g.Edges {
+			if edge.From.Client == client {
+				graph.Edges = append(graph.Edges, edge)
+			}
+		}
+		return graph
+	}
+	return nil
+}
+
+// graphRootXds maps the kinds of the roots of a focused Graph to the xds types of the resources
+var graphRootXds = map[string]string{"listener": "LDS", "route": "RDS", "cluster": "CDS"}
+
+// ParseGraphRoot parses root of the form kind:NAME, where kind is listener, route or cluster, into
+// the xds type and the name of the resource
+func ParseGraphRoot(root string) (string, string, error) {
+	kind, name := root, ""
+	if i := strings.Index(root, ":"); i >= 0 {
+		kind, name = root[:i], root[i+1:]
+	}
+	xds, ok := graphRootXds[kind]
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid graph root %v, want listener:NAME, route:NAME or cluster:NAME", root)
+	}
+	return xds, name, nil
+}
+
+// Focus returns the graph of the resources that are reachable from, or lead to, the resource of
+// xds with name of each client, within depth edges or at any distance if depth is 0. It returns an
+// error if no client has the resource.
+func (g *Graph) Focus(xds string, name string, depth int) (*Graph, error) {
+	kept := make(map[*GraphNode]bool)
+	var roots []*GraphNode
+	for _, node := range g.Nodes {
+		if node.Kind == "" && node.Xds == xds && node.Name == name {
+			roots = append(roots, node)
+			kept[node] = true
+		}
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("%v %v is not in the graph", xds, name)
+	}
+	// walk the edges from the roots in each direction, one step of depth at a time
+	for _, forward := range []bool{true, false} {
+		frontier := roots
+		visited := make(map[*GraphNode]bool)
+		for _, root := range roots {
+			visited[root] = true
+		}
+		for step := 0; len(frontier) > 0 && (depth == 0 || step < depth); step++ {
+			next := []*GraphNode{}
+			for _, node := range frontier {
+				for _, edge := range g.Edges {
+					from, to := edge.From, edge.To
+					if !forward {
+						from, to = to, from
+					}
+					if from == node && !visited[to] {
+						visited[to] = true
+						kept[to] = true
+						next = append(next, to)
+					}
+				}
+			}
+			frontier = next
+		}
+	}
+
+	graph := &Graph{}
+	clients := make(map[string]bool)
+	for _, node := range g.Nodes {
+		if kept[node] {
+			graph.Nodes = append(graph.Nodes, node)
+			clients[node.Client] = true
+		}
+	}
+	for _, client := range g.Clients {
+		if clients[client] {
+			graph.Clients = append(graph.Clients, client)
+		}
+	}
+	for _, edge := range g.Edges {
+		if kept[edge.From] && kept[edge.To] {
+			graph.Edges = append(graph.Edges, edge)
+		}
+	}
+	return graph, nil
+}
+
+// graphBuilder collects the nodes and edges of a client in a Graph
+type graphBuilder struct {
+	client string
+	// detail is the level of the detail of the graph in graphDetailLevels
+	detail int
+	// scopes holds the scoped route configurations in the response by the name of their scoped
+	// routes
+	scopes map[string][]*GraphNode
+	// vhds holds the route configurations whose virtual hosts of VHDS are in the response
+	vhds  map[string]bool
+	nodes map[string][]*GraphNode
+	index map[string]*GraphNode
+	edges map[[2]*GraphNode]bool
+	graph *Graph
+}
+
+// node returns the node of the resource of xds with name, which is added if it is new
+func (b *graphBuilder) node(xds string, name string) *GraphNode {
+	id := GraphNodeID(b.client, xds, name)
+	if node, ok := b.index[id]; ok {
+		return node
+	}
+	node := &GraphNode{ID: id, Client: b.client, Xds: xds, Name: name}
+	b.nodes[xds] = append(b.nodes[xds], node)
+	b.index[id] = node
+	return node
+}
+
+// shows checks if the graph has the parts of the resources of detail
+func (b *graphBuilder) shows(detail string) bool {
+	return b.detail >= graphDetailLevels[detail]
+}
+
+// part returns the node of the part of parent of kind with key, which is added with name and
+// detail, and linked from parent, if it is new
+func (b *graphBuilder) part(parent *GraphNode, kind string, key string, name string, detail []string) *GraphNode {
+	id := parent.ID + "/" + kind + "/" + key
+	if node, ok := b.index[id]; ok {
+		return node
+	}
+	node := &GraphNode{ID: id, Client: b.client, Xds: parent.Xds, Kind: kind, Name: name, Detail: detail, State: parent.State}
+	b.nodes[kind] = append(b.nodes[kind], node)
+	b.index[id] = node
+	b.link(parent, node, "")
+	return node
+}
+
+// edge adds the reference from node to the resource of xds with name, the empty names of unset
+// references are skipped
+func (b *graphBuilder) edge(from *GraphNode, xds string, name string) {
+	b.labelledEdge(from, xds, name, "")
+}
+
+// labelledEdge adds the reference from node to the resource of xds with name as edge does, with
+// label in the graphs with more detail than GraphDetailResources
+func (b *graphBuilder) labelledEdge(from *GraphNode, xds string, name string, label string) {
+	if name == "" {
+		return
+	}
+	if !b.shows(GraphDetailRoutes) {
+		label = ""
+	}
+	b.link(from, b.node(xds, name), label)
+}
+
+// referenced adds the reference from node to the resource of xds with name, which is in no
+// config dump of the response
+func (b *graphBuilder) referenced(from *GraphNode, xds string, name string) {
+	b.edge(from, xds, name)
+	if to := b.index[GraphNodeID(b.client, xds, name)]; to != nil && to.State == "" {
+		to.State = ResourceReferenced
+	}
+}
+
+// link adds the edge from from to to with label unless they are linked already
+func (b *graphBuilder) link(from *GraphNode, to *GraphNode, label string) {
+	if b.edges[[2]*GraphNode{from, to}] {
+		return
+	}
+	b.edges[[2]*GraphNode{from, to}] = true
+	b.graph.Edges = append(b.graph.Edges, &GraphEdge{From: from, To: to, Label: label})
+}
+
+// ParseXdsRelationship parses the relationship between the xds resources of each client in
+// response, which is a ClientStatusResponse of any xds api version. The resources that cannot be
+// parsed, and the references that are not to a named resource (e.g. a redirect or a cluster
+// header), are skipped.
+func ParseXdsRelationship(response proto.Message) (*Graph, error) {
+	return ParseXdsRelationshipDetail(response, GraphDetailResources)
+}
+
+// ParseXdsRelationshipDetail parses the relationship between the xds resources of each client in
+// response as ParseXdsRelationship does, with the parts of the resources of detail, which is one of
+// resources, routes and endpoints
+func ParseXdsRelationshipDetail(response proto.Message, detail string) (*Graph, error) {
+	if _, ok := graphDetailLevels[detail]; !ok {
+		return nil, fmt.Errorf("unsupported graph detail: %v", detail)
+	}
+	snapshot, err := NewSnapshot(response, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return snapshotGraph(snapshot, detail), nil
+}
+
+// snapshotGraph parses the relationship between the xds resources of each client in snapshot with
+// the parts of the resources of detail
+func snapshotGraph(snapshot *Snapshot, detail string) *Graph {
+	level := graphDetailLevels[detail]
+	graph := &Graph{}
+	for _, c := range snapshot.Clients {
+		graph.Clients = append(graph.Clients, c.ID)
+		b := &graphBuilder{
+			client: c.ID,
+			detail: level,
+			scopes: make(map[string][]*GraphNode),
+			vhds:   make(map[string]bool),
+			nodes:  make(map[string][]*GraphNode),
+			index:  make(map[string]*GraphNode),
+			edges:  make(map[[2]*GraphNode]bool),
+			graph:  graph,
+		}
+		b.addClient(c)
+		// the nodes of the referenced resources are added after the ones of their type in the response
+		for _, column := range graphColumns {
+			graph.Nodes = append(graph.Nodes, b.nodes[column]...)
+		}
+	}
+	return graph
+}
+
+// addClient adds the resources of client c and the references between them
+func (b *graphBuilder) addClient(c *ClientSnapshot) {
+	// add the resources in the response before the references, so that they come first
+	var resources []*ResourceSnapshot
+	var nodes []*GraphNode
+	for _, xds := range graphXds {
+		x := c.XdsConfig(xds)
+		if x == nil {
+			continue
+		}
+		for _, r := range x.Resources {
+			node := b.node(xds, r.Name)
+			if node.State == "" {
+				node.State = r.State
+				node.Error = r.Error
+				node.Config = r.Config
+			}
+			resources = append(resources, r)
+			nodes = append(nodes, node)
+			if xds == "SRDS" {
+				b.scopes[r.Group] = append(b.scopes[r.Group], node)
+			}
+		}
+	}
+
+	// the endpoints of a cluster are named after its eds service name if it is set
+	edsClusters := make(map[string]string)
+	for i, r := range resources {
+		switch nodes[i].Xds {
+		case "CDS":
+			cluster := &envoy_config_cluster_v3.Cluster{}
+			if unpackAny(r.Config, cluster) == nil && cluster.GetEdsClusterConfig().GetServiceName() != "" {
+				edsClusters[cluster.GetEdsClusterConfig().GetServiceName()] = cluster.GetName()
+			}
+		case "VHDS":
+			if routeConfig, ok := vhdsRouteConfig(r.Name); ok {
+				b.vhds[routeConfig] = true
+			}
+		}
+	}
+
+	for i, r := range resources {
+		node := nodes[i]
+		switch node.Xds {
+		case "LDS":
+			listener := &envoy_config_listener_v3.Listener{}
+			if unpackAny(r.Config, listener) == nil {
+				b.listenerEdges(node, listener)
+			}
+		case "SRDS":
+			scope := &envoy_config_route_v3.ScopedRouteConfiguration{}
+			if unpackAny(r.Config, scope) == nil {
+				b.edge(node, "RDS", scope.GetRouteConfigurationName())
+			}
+		case "RDS":
+			routeConfig := &envoy_config_route_v3.RouteConfiguration{}
+			if unpackAny(r.Config, routeConfig) == nil {
+				b.routeConfigEdges(node, routeConfig)
+			}
+		case "VHDS":
+			if routeConfig, ok := vhdsRouteConfig(node.Name); ok {
+				b.link(b.node("RDS", routeConfig), node, "")
+			}
+			virtualHost := &envoy_config_route_v3.VirtualHost{}
+			if unpackAny(r.Config, virtualHost) == nil {
+				b.virtualHostEdges(node, virtualHost)
+			}
+		case "CDS":
+			cluster := &envoy_config_cluster_v3.Cluster{}
+			if unpackAny(r.Config, cluster) == nil {
+				b.secretEdges(node, cluster.GetTransportSocket())
+				for _, match := range cluster.GetTransportSocketMatches() {
+					b.secretEdges(node, match.GetTransportSocket())
+				}
+			}
+		case "EDS":
+			cluster := node.Name
+			if name, ok := edsClusters[node.Name]; ok {
+				cluster = name
+			}
+			b.edge(b.node("CDS", cluster), "EDS", node.Name)
+			clusterLoadAssignment := &envoy_config_endpoint_v3.ClusterLoadAssignment{}
+			if b.shows(GraphDetailEndpoints) && unpackAny(r.Config, clusterLoadAssignment) == nil {
+				b.endpoints(node, clusterLoadAssignment)
+			}
+		}
+	}
+}
+
+// vhdsRouteConfig returns the route configuration of the virtual host of VHDS with name, which is
+// <route configuration name>/<domain>
+func vhdsRouteConfig(name string) (string, bool) {
+	i := strings.LastIndex(name, "/")
+	if i <= 0 {
+		return "", false
+	}
+	return name[:i], true
+}
+
+// listenerEdges adds the references of the filter chains of listener, i.e. the route
+// configurations, the scoped route configurations and the filter configs of ECDS of the http
+// connection managers, the clusters of the tcp proxies and the secrets of SDS of the transport
+// sockets. With more detail than GraphDetailResources, they are referenced from the filter chains
+// of the listener.
+func (b *graphBuilder) listenerEdges(node *GraphNode, listener *envoy_config_listener_v3.Listener) {
+	if b.shows(GraphDetailRoutes) && node.Detail == nil && listener.GetAddress() != nil {
+		node.Detail = []string{"address: " + formatAddress(listener.GetAddress())}
+	}
+	for i, filterChain := range listener.GetFilterChains() {
+		from := node
+		if b.shows(GraphDetailRoutes) {
+			name := filterChain.GetName()
+			if name == "" {
+				name = "filter_chain_" + strconv.Itoa(i)
+			}
+			from = b.part(node, GraphFilterChain, strconv.Itoa(i), name, filterChainDetail(filterChain))
+		}
+		b.secretEdges(from, filterChain.GetTransportSocket())
+		for _, filter := range filterChain.GetFilters() {
+			typedConfig := filter.GetTypedConfig()
+			switch {
+			case typedConfig == nil:
+				// the deprecated untyped config is not parsed
+			case strings.HasSuffix(typedConfig.GetTypeUrl(), ".HttpConnectionManager"):
+				hcm := &envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager{}
+				if unpackAny(typedConfig, hcm) != nil {
+					continue
+				}
+				b.edge(from, "RDS", hcm.GetRds().GetRouteConfigName())
+				if routeConfig := hcm.GetRouteConfig(); routeConfig != nil {
+					// the unnamed inline route configurations of the filter chains are told apart,
+					// so that their virtual hosts are not merged
+					name := routeConfig.GetName()
+					if name == "" {
+						name = listener.GetName() + "/inline"
+						if i > 0 {
+							name += "_" + strconv.Itoa(i)
+						}
+					}
+					b.edge(from, "RDS", name)
+					inline := b.node("RDS", name)
+					if inline.State == "" {
+						inline.State = ResourceInline
+						inline.Config, _ = anypb.New(routeConfig)
+					}
+					b.routeConfigEdges(inline, routeConfig)
+				}
+				b.scopedRoutesEdges(from, hcm.GetScopedRoutes())
+				// the filter configs of ECDS are named after their http filters
+				for _, httpFilter := range hcm.GetHttpFilters() {
+					if httpFilter.GetConfigDiscovery() != nil {
+						b.referenced(from, "ECDS", httpFilter.GetName())
+					}
+				}
+			case strings.HasSuffix(typedConfig.GetTypeUrl(), ".TcpProxy"):
+				tcpProxy := &envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy{}
+				if unpackAny(typedConfig, tcpProxy) != nil {
+					continue
+				}
+				b.edge(from, "CDS", tcpProxy.GetCluster())
+				weights := tcpProxy.GetWeightedClusters().GetClusters()
+				total := uint32(0)
+				for _, cluster := range weights {
+					total += cluster.GetWeight()
+				}
+				for _, cluster := range weights {
+					b.labelledEdge(from, "CDS", cluster.GetName(), weightLabel(cluster.GetWeight(), total))
+				}
+			}
+		}
+	}
+}
+
+// scopedRoutesEdges adds the scoped route configurations of scopedRoutes, which are either inline
+// or the ones in the response with the name of scopedRoutes
+func (b *graphBuilder) scopedRoutesEdges(from *GraphNode, scopedRoutes *envoy_extensions_filters_network_http_connection_manager_v3.ScopedRoutes) {
+	if scopedRoutes == nil {
+		return
+	}
+	if list := scopedRoutes.GetScopedRouteConfigurationsList(); list != nil {
+		for _, scope := range list.GetScopedRouteConfigurations() {
+			b.edge(from, "SRDS", scope.GetName())
+			inline := b.node("SRDS", scope.GetName())
+			if inline.State == "" {
+				inline.State = ResourceInline
+				inline.Config, _ = anypb.New(scope)
+			}
+			b.edge(inline, "RDS", scope.GetRouteConfigurationName())
+		}
+		return
+	}
+	scopes, ok := b.scopes[scopedRoutes.GetName()]
+	if !ok {
+		// the scoped routes are not in the response, so they are shown as missing
+		b.edge(from, "SRDS", scopedRoutes.GetName())
+	}
+	for _, scope := range scopes {
+		b.link(from, scope, "")
+	}
+}
+
+// secretEdges adds the secrets of SDS of the tls context of transportSocket, which is either an
+// upstream or a downstream one
+func (b *graphBuilder) secretEdges(from *GraphNode, transportSocket *envoy_config_core_v3.TransportSocket) {
+	typedConfig := transportSocket.GetTypedConfig()
+	var tlsContext *envoy_extensions_transport_sockets_tls_v3.CommonTlsContext
+	switch {
+	case typedConfig == nil:
+		return
+	case strings.HasSuffix(typedConfig.GetTypeUrl(), ".UpstreamTlsContext"):
+		upstream := &envoy_extensions_transport_sockets_tls_v3.UpstreamTlsContext{}
+		if unpackAny(typedConfig, upstream) != nil {
+			return
+		}
+		tlsContext = upstream.GetCommonTlsContext()
+	case strings.HasSuffix(typedConfig.GetTypeUrl(), ".DownstreamTlsContext"):
+		downstream := &envoy_extensions_transport_sockets_tls_v3.DownstreamTlsContext{}
+		if unpackAny(typedConfig, downstream) != nil {
+			return
+		}
+		tlsContext = downstream.GetCommonTlsContext()
+	default:
+		return
+	}
+	for _, secret := range tlsContext.GetTlsCertificateSdsSecretConfigs() {
+		b.referenced(from, "SDS", secret.GetName())
+	}
+	b.referenced(from, "SDS", tlsContext.GetValidationContextSdsSecretConfig().GetName())
+	b.referenced(from, "SDS", tlsContext.GetCombinedValidationContext().GetValidationContextSdsSecretConfig().GetName())
+}
+
+// filterChainDetail describes the match of filterChain and the http filters of its http
+// connection managers
+func filterChainDetail(filterChain *envoy_config_listener_v3.FilterChain) []string {
+	var detail []string
+	match := filterChain.GetFilterChainMatch()
+	if match.GetDestinationPort() != nil {
+		detail = append(detail, "port: "+strconv.FormatUint(uint64(match.GetDestinationPort().GetValue()), 10))
+	}
+	if len(match.GetServerNames()) > 0 {
+		detail = append(detail, "server names: "+strings.Join(match.GetServerNames(), ", "))
+	}
+	if match.GetTransportProtocol() != "" {
+		detail = append(detail, "transport protocol: "+match.GetTransportProtocol())
+	}
+	if len(match.GetApplicationProtocols()) > 0 {
+		detail = append(detail, "application protocols: "+strings.Join(match.GetApplicationProtocols(), ", "))
+	}
+	for _, filter := range filterChain.GetFilters() {
+		hcm := &envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager{}
+		if filter.GetTypedConfig() == nil || !strings.HasSuffix(filter.GetTypedConfig().GetTypeUrl(), ".HttpConnectionManager") || unpackAny(filter.GetTypedConfig(), hcm) != nil {
+			continue
+		}
+		var names []string
+		for _, httpFilter := range hcm.GetHttpFilters() {
+			names = append(names, httpFilter.GetName())
+		}
+		if len(names) > 0 {
+			detail = append(detail, "http filters: "+strings.Join(names, ", "))
+		}
+	}
+	return detail
+}
+
+// routeConfigEdges adds the clusters the routes of routeConfig forward or mirror requests to, and
+// the virtual hosts of VHDS of routeConfig. The
+// redirects, direct responses and clusters taken from a request header have no cluster to add.
+// With more detail than GraphDetailResources, the clusters are referenced from the routes, which
+// are parts of the virtual hosts of routeConfig.
+func (b *graphBuilder) routeConfigEdges(node *GraphNode, routeConfig *envoy_config_route_v3.RouteConfiguration) {
+	// the virtual hosts of VHDS are fetched on demand for the route configuration, and linked
+	// from it in addClient if they are in the response
+	if routeConfig.GetVhds() != nil && !b.vhds[routeConfig.GetName()] {
+		b.referenced(node, "VHDS", routeConfig.GetName())
+	}
+	for _, virtualHost := range routeConfig.GetVirtualHosts() {
+		host := node
+		if b.shows(GraphDetailRoutes) {
+			host = b.part(node, GraphVirtualHost, virtualHost.GetName(), virtualHost.GetName(), []string{"domains: " + strings.Join(virtualHost.GetDomains(), ", ")})
+		}
+		b.virtualHostEdges(host, virtualHost)
+	}
+}
+
+// virtualHostEdges adds the clusters the routes of virtualHost forward or mirror requests to, which
+// are referenced from host, or from the routes as parts of host with more detail than
+// GraphDetailResources. host is the node of the virtual host, or of its route configuration if the
+// graph has no parts.
+func (b *graphBuilder) virtualHostEdges(host *GraphNode, virtualHost *envoy_config_route_v3.VirtualHost) {
+	for i, route := range virtualHost.GetRoutes() {
+		from := host
+		if b.shows(GraphDetailRoutes) {
+			name := route.GetName()
+			if name == "" {
+				name = formatRouteMatch(route.GetMatch())
+			}
+			from = b.part(host, GraphRoute, strconv.Itoa(i), name, routeDetail(route))
+		}
+		action := route.GetRoute()
+		b.edge(from, "CDS", action.GetCluster())
+		weights := action.GetWeightedClusters()
+		total := weights.GetTotalWeight().GetValue()
+		if total == 0 {
+			for _, cluster := range weights.GetClusters() {
+				total += cluster.GetWeight().GetValue()
+			}
+		}
+		for _, cluster := range weights.GetClusters() {
+			b.labelledEdge(from, "CDS", cluster.GetName(), weightLabel(cluster.GetWeight().GetValue(), total))
+		}
+		for _, mirror := range action.GetRequestMirrorPolicies() {
+			b.labelledEdge(from, "CDS", mirror.GetCluster(), "mirror")
+		}
+	}
+}
+
+// weightLabel returns the share of weight in total as a percentage
+func weightLabel(weight uint32, total uint32) string {
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.3g%%", float64(weight)*100/float64(total))
+}
+
+// formatRouteMatch describes the path of match, e.g. prefix /api
+func formatRouteMatch(match *envoy_config_route_v3.RouteMatch) string {
+	switch {
+	case match.GetSafeRegex() != nil:
+		return "regex " + match.GetSafeRegex().GetRegex()
+	case match.GetConnectMatcher() != nil:
+		return "connect"
+	case match.GetPath() != "":
+		return "path " + match.GetPath()
+	default:
+		return "prefix " + match.GetPrefix()
+	}
+}
+
+// routeDetail describes the header matches of route, and the action of route if it is not to a
+// named cluster
+func routeDetail(route *envoy_config_route_v3.Route) []string {
+	var detail []string
+	if route.GetName() != "" {
+		detail = append(detail, "match: "+formatRouteMatch(route.GetMatch()))
+	}
+	for _, header := range route.GetMatch().GetHeaders() {
+		var match string
+		switch {
+		case header.GetSafeRegexMatch() != nil:
+			match = "~ " + header.GetSafeRegexMatch().GetRegex()
+		case header.GetRangeMatch() != nil:
+			match = fmt.Sprintf("in [%d, %d)", header.GetRangeMatch().GetStart(), header.GetRangeMatch().GetEnd())
+		case header.GetPresentMatch():
+			match = "present"
+		case header.GetPrefixMatch() != "":
+			match = "prefix " + header.GetPrefixMatch()
+		case header.GetSuffixMatch() != "":
+			match = "suffix " + header.GetSuffixMatch()
+		default:
+			match = "= " + header.GetExactMatch()
+		}
+		if header.GetInvertMatch() {
+			match = "not " + match
+		}
+		detail = append(detail, "header "+header.GetName()+" "+match)
+	}
+	switch {
+	case route.GetRedirect() != nil:
+		detail = append(detail, "redirect")
+	case route.GetDirectResponse() != nil:
+		detail = append(detail, "direct response "+strconv.FormatUint(uint64(route.GetDirectResponse().GetStatus()), 10))
+	case route.GetRoute().GetClusterHeader() != "":
+		detail = append(detail, "cluster from header "+route.GetRoute().GetClusterHeader())
+	}
+	return detail
+}
+
+// endpoints adds the endpoints of clusterLoadAssignment as the parts of node with their locality
+// and health. The endpoints are keyed by the index of their locality and priority as well as their
+// address, since the same address can be in more than one of them.
+func (b *graphBuilder) endpoints(node *GraphNode, clusterLoadAssignment *envoy_config_endpoint_v3.ClusterLoadAssignment) {
+	for i, localityEndpoints := range clusterLoadAssignment.GetEndpoints() {
+		var detail []string
+		locality := localityEndpoints.GetLocality()
+		if locality != nil {
+			var parts []string
+			for _, part := range []string{locality.GetRegion(), locality.GetZone(), locality.GetSubZone()} {
+				if part != "" {
+					parts = append(parts, part)
+				}
+			}
+			detail = append(detail, "locality: "+strings.Join(parts, "/"))
+		}
+		if localityEndpoints.GetPriority() > 0 {
+			detail = append(detail, "priority: "+strconv.FormatUint(uint64(localityEndpoints.GetPriority()), 10))
+		}
+		for _, endpoint := range localityEndpoints.GetLbEndpoints() {
+			address := formatAddress(endpoint.GetEndpoint().GetAddress())
+			if address == "" {
+				address = endpoint.GetEndpointName()
+			}
+			endpointDetail := append(append([]string{}, detail...), "health: "+endpoint.GetHealthStatus().String())
+			if endpoint.GetLoadBalancingWeight() != nil {
+				endpointDetail = append(endpointDetail, "weight: "+strconv.FormatUint(uint64(endpoint.GetLoadBalancingWeight().GetValue()), 10))
+			}
+			b.part(node, GraphEndpoint, strconv.Itoa(i)+"/"+address, address, endpointDetail)
+		}
+	}
+}
+
+// formatAddress returns address as host:port, or the path of a pipe
+func formatAddress(address *envoy_config_core_v3.Address) string {
+	if pipe := address.GetPipe(); pipe != nil {
+		return pipe.GetPath()
+	}
+	socketAddress := address.GetSocketAddress()
+	if socketAddress == nil {
+		return ""
+	}
+	port := socketAddress.GetNamedPort()
+	if port == "" {
+		port = strconv.FormatUint(uint64(socketAddress.GetPortValue()), 10)
+	}
+	return socketAddress.GetAddress() + ":" + port
+}
@@ -0,0 +1,118 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Changes of the nodes and the edges of a graph diff
+const (
+	GraphAdded   string = "ADDED"
+	GraphRemoved string = "REMOVED"
+	GraphChanged string = "CHANGED"
+)
+
+// graphDiffColors are the colors of the added, the removed and the changed nodes and edges of a
+// graph diff
+var graphDiffColors = map[string]string{
+	GraphAdded:   "#188038",
+	GraphRemoved: "#D93025",
+	GraphChanged: "#F29900",
+}
+
+// DiffGraphs returns the union of the graphs old and new, whose nodes and edges are marked as
+// added, removed or changed from old to new. A node is changed if the state, the error, the
+// detail or the config of its resource is, and an edge is changed if its label is. The nodes and
+// the edges of new come first in their order, followed by the removed ones in the order of old.
+func DiffGraphs(old *Graph, new *Graph) *Graph {
+	graph := &Graph{}
+	clients := make(map[string]bool)
+	for _, g := range []*Graph{new, old} {
+		for _, client := range g.Clients {
+			if !clients[client] {
+				clients[client] = true
+				graph.Clients = append(graph.Clients, client)
+			}
+		}
+	}
+
+	oldNodes := make(map[string]*GraphNode)
+	for _, node := range old.Nodes {
+		oldNodes[node.ID] = node
+	}
+	// the nodes are copied, so the graphs are not marked
+	nodes := make(map[string]*GraphNode)
+	for _, node := range new.Nodes {
+		n := *node
+		if oldNode := oldNodes[node.ID]; oldNode == nil {
+			n.Diff = GraphAdded
+		} else if n.Changes = diffGraphNode(oldNode, node); len(n.Changes) > 0 {
+			n.Diff = GraphChanged
+		}
+		nodes[n.ID] = &n
+		graph.Nodes = append(graph.Nodes, &n)
+	}
+	for _, node := range old.Nodes {
+		if nodes[node.ID] == nil {
+			n := *node
+			n.Diff = GraphRemoved
+			nodes[n.ID] = &n
+			graph.Nodes = append(graph.Nodes, &n)
+		}
+	}
+
+	oldEdges := make(map[[2]string]*GraphEdge)
+	for _, edge := range old.Edges {
+		oldEdges[[2]string{edge.From.ID, edge.To.ID}] = edge
+	}
+	newEdges := make(map[[2]string]bool)
+	for _, edge := range new.Edges {
+		key := [2]string{edge.From.ID, edge.To.ID}
+		newEdges[key] = true
+		e := &GraphEdge{From: nodes[edge.From.ID], To: nodes[edge.To.ID], Label: edge.Label}
+		if oldEdge := oldEdges[key]; oldEdge == nil {
+			e.Diff = GraphAdded
+		} else if oldEdge.Label != edge.Label {
+			e.Diff = GraphChanged
+			e.Label = fmt.Sprintf("%v -> %v", oldEdge.Label, edge.Label)
+		}
+		graph.Edges = append(graph.Edges, e)
+	}
+	for _, edge := range old.Edges {
+		if !newEdges[[2]string{edge.From.ID, edge.To.ID}] {
+			graph.Edges = append(graph.Edges, &GraphEdge{From: nodes[edge.From.ID], To: nodes[edge.To.ID], Label: edge.Label, Diff: GraphRemoved})
+		}
+	}
+	return graph
+}
+
+// diffGraphNode describes the changes from the node old to the node new, the changed fields of
+// the config are listed by their paths
+func diffGraphNode(old *GraphNode, new *GraphNode) []string {
+	var changes []string
+	if old.State != new.State {
+		changes = append(changes, fmt.Sprintf("state: %v -> %v", formatGraphState(old.State), formatGraphState(new.State)))
+	}
+	if old.Error != new.Error {
+		changes = append(changes, "error")
+	}
+	if !reflect.DeepEqual(old.Detail, new.Detail) {
+		changes = append(changes, "detail")
+	}
+	for _, field := range diffResource(&ResourceSnapshot{Config: old.Config}, &ResourceSnapshot{Config: new.Config}) {
+		path := field.Path
+		if path == "" {
+			path = "<resource>"
+		}
+		changes = append(changes, path)
+	}
+	return changes
+}
+
+// formatGraphState formats the state of a node in the changes of a graph diff
+func formatGraphState(state string) string {
+	if state == "" {
+		return "<missing>"
+	}
+	return state
+}
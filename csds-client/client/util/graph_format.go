@@ -0,0 +1,461 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// Formats of the graph of the visualization
+const (
+	// GraphDot is the dot language of Graphviz
+	GraphDot string = "dot"
+	// GraphMermaid is a Mermaid flowchart, which can be embedded in Markdown
+	GraphMermaid string = "mermaid"
+	// GraphD2 is the D2 diagram language
+	GraphD2 string = "d2"
+	// GraphJson is the Cytoscape.js json format, which Cytoscape imports
+	GraphJson string = "json"
+	// GraphGraphML is GraphML, which Gephi and Cytoscape import
+	GraphGraphML string = "graphml"
+)
+
+// graphExtensions maps the graph formats to the extensions of their files
+var graphExtensions = map[string]string{
+	GraphDot:     ".dot",
+	GraphMermaid: ".mmd",
+	GraphD2:      ".d2",
+	GraphJson:    ".json",
+	GraphGraphML: ".graphml",
+	// the html viewer is not a format of -graph_format, but is saved the same way
+	VisualizationHtml: ".html",
+}
+
+// graphColors are the colors of the nodes of each xds type, and the lighter ones of the parts of
+// the resources
+var graphColors = map[string]string{
+	"LDS":            "#4285F4",
+	GraphFilterChain: "#669DF6",
+	"ECDS":           "#12B5CB",
+	"SRDS":           "#A142F4",
+	"RDS":            "#EA4335",
+	"VHDS":           "#E52592",
+	GraphVirtualHost: "#EE675C",
+	GraphRoute:       "#F28B82",
+	"CDS":            "#FBBC04",
+	"EDS":            "#34A853",
+	GraphEndpoint:    "#5BB974",
+	"SDS":            "#80868B",
+	"RTDS":           "#E8710A",
+}
+
+// GraphFileName returns the default name of the file the graph is saved to in format
+func GraphFileName(format string) string {
+	return "config_graph" + graphExtensions[format]
+}
+
+// FormatGraph generates the graph in format, which is one of dot, mermaid, d2, json and graphml
+func FormatGraph(graph *Graph, format string) (string, error) {
+	switch format {
+	case GraphDot, "":
+		return GenerateGraph(graph)
+	case GraphMermaid:
+		return generateMermaid(graph), nil
+	case GraphD2:
+		return generateD2(graph), nil
+	case GraphJson:
+		return generateGraphJson(graph)
+	case GraphGraphML:
+		return generateGraphML(graph)
+	default:
+		return "", fmt.Errorf("unsupported graph format: %v", format)
+	}
+}
+
+// Colors of the nodes and the edges that show a problem of the config
+const (
+	// graphNackedColor fills the nodes of the rejected resources, and draws the broken links
+	graphNackedColor string = "#D93025"
+	// graphMarkedColor is the border of the nodes of the warming and the draining resources
+	graphMarkedColor string = "#3C4043"
+)
+
+// graphStyle is how a node is drawn for the state of its resource
+type graphStyle struct {
+	// fill is the color of the node, it is empty if the node is hollow
+	fill   string
+	border string
+	font   string
+	// dashed draws the border of the node with dashes
+	dashed bool
+	// bold draws a thick border around the node
+	bold bool
+	// note is added to the label of the node, e.g. (warming)
+	note string
+}
+
+// style returns how node is drawn: a rejected resource is red, a resource that is referenced but
+// not in the response is hollow and dashed, a resource of an xds type the response has no config
+// dump of is hollow, a warming or draining resource has a bold border and a note, and the others,
+// including the parts of the resources, are filled with the color of their xds type or kind. In a
+// graph diff, an added node is green, a removed node is red and a changed node has a bold orange
+// border, with a note of the change.
+func (node *GraphNode) style() graphStyle {
+	style := node.stateStyle()
+	switch node.Diff {
+	case GraphAdded, GraphRemoved:
+		color := graphDiffColors[node.Diff]
+		style.fill, style.border, style.font = color, color, "white"
+	case GraphChanged:
+		style.border, style.bold = graphDiffColors[GraphChanged], true
+	default:
+		return style
+	}
+	style.note = strings.TrimSpace(style.note + " (" + strings.ToLower(node.Diff) + ")")
+	return style
+}
+
+// stateStyle returns how node is drawn for the state of its resource
+func (node *GraphNode) stateStyle() graphStyle {
+	color := graphColors[node.column()]
+	switch {
+	case node.Kind != "":
+		return graphStyle{fill: color, border: color, font: "white"}
+	case node.State == "":
+		return graphStyle{border: color, font: color, dashed: true, note: "(missing)"}
+	case node.State == ResourceReferenced:
+		return graphStyle{border: color, font: color, note: "(referenced)"}
+	case node.State == ResourceNacked:
+		return graphStyle{fill: graphNackedColor, border: graphNackedColor, font: "white", note: "(nacked)"}
+	case node.State == ResourceWarming || node.State == ResourceDraining:
+		return graphStyle{fill: color, border: graphMarkedColor, font: "white", bold: true, note: "(" + strings.ToLower(node.State) + ")"}
+	default:
+		return graphStyle{fill: color, border: color, font: "white"}
+	}
+}
+
+// label returns the name of node followed by the lines of its detail and the note of its state
+func (node *GraphNode) label() string {
+	lines := append([]string{node.Name}, node.Detail...)
+	if note := node.style().note; note != "" {
+		lines = append(lines, note)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tooltip describes the state of node, with the error of the rejected update if there is one
+func (node *GraphNode) tooltip() string {
+	state := node.State
+	if state == "" {
+		state = "not in the response"
+	}
+	tooltip := node.ID + "\n" + state
+	if node.Error != "" {
+		tooltip += "\n" + node.Error
+	}
+	if node.Diff != "" {
+		tooltip += "\n" + node.Diff
+	}
+	for _, change := range node.Changes {
+		tooltip += "\n" + change
+	}
+	return tooltip
+}
+
+// broken checks if edge refers to a resource that is not in the response
+func (edge *GraphEdge) broken() bool {
+	return edge.To.State == ""
+}
+
+// color returns the color of the line of edge: the color of its change in a graph diff, red if
+// it is broken, or empty for the default color
+func (edge *GraphEdge) color() string {
+	if edge.Diff != "" {
+		return graphDiffColors[edge.Diff]
+	}
+	if edge.broken() {
+		return graphNackedColor
+	}
+	return ""
+}
+
+// dashed checks if the line of edge is dashed, i.e. it is broken or removed in a graph diff
+func (edge *GraphEdge) dashed() bool {
+	return edge.broken() || edge.Diff == GraphRemoved
+}
+
+// groupedByClient checks if the resources of each client are drawn in a group, which is the
+// case when the graph has many clients
+func (g *Graph) groupedByClient() bool {
+	return len(g.Clients) > 1
+}
+
+// generateMermaid generates the graph as a Mermaid flowchart, whose node ids are numbered since
+// the ids of the graph may have characters mermaid does not allow
+func generateMermaid(graph *Graph) string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	ids := make(map[*GraphNode]string)
+	for i, node := range graph.Nodes {
+		ids[node] = fmt.Sprintf("n%d", i)
+	}
+	// quotes are written as entity codes in the labels of mermaid
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+	}
+	for i, client := range graph.Clients {
+		indent := "  "
+		if graph.groupedByClient() {
+			fmt.Fprintf(&b, "  subgraph c%d[%v]\n", i, quote(client))
+			indent = "    "
+		}
+		for _, node := range graph.Nodes {
+			if node.Client == client {
+				fmt.Fprintf(&b, "%v%v[%v]\n", indent, ids[node], quote(strings.ReplaceAll(node.label(), "\n", "<br/>")))
+			}
+		}
+		if graph.groupedByClient() {
+			b.WriteString("  end\n")
+		}
+	}
+	for _, edge := range graph.Edges {
+		// a broken link is a dotted line ending in a cross, and a removed one is a dotted arrow
+		arrow := "-->"
+		if edge.broken() {
+			arrow = "-.-x"
+		} else if edge.dashed() {
+			arrow = "-.->"
+		}
+		if edge.Label != "" {
+			fmt.Fprintf(&b, "  %v %v|%v| %v\n", ids[edge.From], arrow, quote(edge.Label), ids[edge.To])
+		} else {
+			fmt.Fprintf(&b, "  %v %v %v\n", ids[edge.From], arrow, ids[edge.To])
+		}
+	}
+	for _, column := range graphColumns {
+		var members []string
+		for _, node := range graph.Nodes {
+			if node.column() == column {
+				members = append(members, ids[node])
+			}
+		}
+		if len(members) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "  classDef %v fill:%v,stroke:%v,color:#fff\n", column, graphColors[column], graphColors[column])
+		fmt.Fprintf(&b, "  class %v %v\n", strings.Join(members, ","), column)
+	}
+	// the links are numbered in the order they are written, the changes of a graph diff are colored
+	for i, edge := range graph.Edges {
+		if edge.Diff != "" {
+			fmt.Fprintf(&b, "  linkStyle %d stroke:%v,stroke-width:2px\n", i, edge.color())
+		}
+	}
+	// the nodes whose state is noted are styled on their own
+	for _, node := range graph.Nodes {
+		style := node.style()
+		if style.note == "" {
+			continue
+		}
+		fill := style.fill
+		if fill == "" {
+			fill = "#fff"
+		}
+		fmt.Fprintf(&b, "  style %v fill:%v,stroke:%v,color:%v", ids[node], fill, style.border, style.font)
+		if style.dashed {
+			b.WriteString(",stroke-dasharray:5 5")
+		}
+		if style.bold {
+			b.WriteString(",stroke-width:3px")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// d2Quote quotes s as a string of the D2 diagram language, the line breaks are escaped as \n
+func d2Quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// generateD2 generates the graph in the D2 diagram language, the resources of each client are in
+// a container if there are many clients
+func generateD2(graph *Graph) string {
+	var b strings.Builder
+	b.WriteString("direction: right\n")
+	// the key of a node is its id without the client, which is the container of the node
+	key := func(node *GraphNode) string {
+		id := d2Quote(strings.TrimPrefix(node.ID, node.Client+"/"))
+		if graph.groupedByClient() {
+			return d2Quote(node.Client) + "." + id
+		}
+		return id
+	}
+	for _, client := range graph.Clients {
+		if graph.groupedByClient() {
+			fmt.Fprintf(&b, "%v: {\n  label: %v\n}\n", d2Quote(client), d2Quote(client))
+		}
+		for _, node := range graph.Nodes {
+			if node.Client == client {
+				style := node.style()
+				fill := style.fill
+				if fill == "" {
+					fill = "white"
+				}
+				fmt.Fprintf(&b, "%v: %v {\n  style.fill: %v\n  style.stroke: %v\n  style.font-color: %v\n", key(node), d2Quote(node.label()), d2Quote(fill), d2Quote(style.border), d2Quote(style.font))
+				if style.dashed {
+					b.WriteString("  style.stroke-dash: 3\n")
+				}
+				if style.bold {
+					b.WriteString("  style.stroke-width: 3\n")
+				}
+				if node.Error != "" {
+					fmt.Fprintf(&b, "  tooltip: %v\n", d2Quote(node.Error))
+				}
+				b.WriteString("}\n")
+			}
+		}
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&b, "%v -> %v", key(edge.From), key(edge.To))
+		if edge.Label != "" {
+			fmt.Fprintf(&b, ": %v", d2Quote(edge.Label))
+		}
+		// a broken link is a red dashed line, and the changes of a graph diff are in their colors
+		if color := edge.color(); color != "" {
+			fmt.Fprintf(&b, " {\n  style.stroke: %v\n", d2Quote(color))
+			if edge.dashed() {
+				b.WriteString("  style.stroke-dash: 3\n")
+			}
+			b.WriteString("}")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// cytoscapeElement is a node or an edge in the Cytoscape.js json format
+type cytoscapeElement struct {
+	Data map[string]string `json:"data"`
+}
+
+// generateGraphJson generates the graph in the Cytoscape.js json format, the resources of each
+// client are children of a compound node of the client if there are many clients
+func generateGraphJson(graph *Graph) (string, error) {
+	nodes := []cytoscapeElement{}
+	if graph.groupedByClient() {
+		for _, client := range graph.Clients {
+			nodes = append(nodes, cytoscapeElement{Data: map[string]string{"id": client, "name": client}})
+		}
+	}
+	for _, node := range graph.Nodes {
+		data := map[string]string{
+			"id":     node.ID,
+			"client": node.Client,
+			"name":   node.Name,
+			"xds":    node.Xds,
+			"state":  node.State,
+		}
+		if graph.groupedByClient() {
+			data["parent"] = node.Client
+		}
+		if node.Kind != "" {
+			data["kind"] = node.Kind
+		}
+		if len(node.Detail) > 0 {
+			data["detail"] = strings.Join(node.Detail, "\n")
+		}
+		if node.Error != "" {
+			data["error"] = node.Error
+		}
+		if node.Diff != "" {
+			data["diff"] = node.Diff
+		}
+		if len(node.Changes) > 0 {
+			data["changes"] = strings.Join(node.Changes, "\n")
+		}
+		nodes = append(nodes, cytoscapeElement{Data: data})
+	}
+	edges := []cytoscapeElement{}
+	for _, edge := range graph.Edges {
+		data := map[string]string{
+			"id":     edge.From.ID + "->" + edge.To.ID,
+			"source": edge.From.ID,
+			"target": edge.To.ID,
+		}
+		if edge.Label != "" {
+			data["label"] = edge.Label
+		}
+		if edge.broken() {
+			data["broken"] = "true"
+		}
+		if edge.Diff != "" {
+			data["diff"] = edge.Diff
+		}
+		edges = append(edges, cytoscapeElement{Data: data})
+	}
+	out, err := json.MarshalIndent(map[string]interface{}{
+		"elements": map[string]interface{}{"nodes": nodes, "edges": edges},
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out) + "\n", nil
+}
+
+// graphMLKeys are the attributes of the nodes in GraphML, the detail and the changes are joined by
+// line breaks
+var graphMLKeys = []string{"client", "name", "xds", "state", "kind", "detail", "error", "diff", "changes"}
+
+// generateGraphML generates the graph in GraphML
+func generateGraphML(graph *Graph) (string, error) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	for _, key := range graphMLKeys {
+		fmt.Fprintf(&b, "  <key id=\"%v\" for=\"node\" attr.name=\"%v\" attr.type=\"string\"/>\n", key, key)
+	}
+	b.WriteString(`  <key id="label" for="edge" attr.name="label" attr.type="string"/>` + "\n")
+	b.WriteString(`  <key id="broken" for="edge" attr.name="broken" attr.type="boolean"/>` + "\n")
+	b.WriteString(`  <key id="edge_diff" for="edge" attr.name="diff" attr.type="string"/>` + "\n")
+	b.WriteString(`  <graph id="G" edgedefault="directed">` + "\n")
+	escape := func(s string) (string, error) {
+		var escaped bytes.Buffer
+		err := xml.EscapeText(&escaped, []byte(s))
+		return escaped.String(), err
+	}
+	for _, node := range graph.Nodes {
+		id, err := escape(node.ID)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "    <node id=\"%v\">\n", id)
+		for i, value := range []string{node.Client, node.Name, node.Xds, node.State, node.Kind, strings.Join(node.Detail, "\n"), node.Error, node.Diff, strings.Join(node.Changes, "\n")} {
+			escaped, err := escape(value)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "      <data key=\"%v\">%v</data>\n", graphMLKeys[i], escaped)
+		}
+		b.WriteString("    </node>\n")
+	}
+	for _, edge := range graph.Edges {
+		source, err := escape(edge.From.ID)
+		if err != nil {
+			return "", err
+		}
+		target, err := escape(edge.To.ID)
+		if err != nil {
+			return "", err
+		}
+		label, err := escape(edge.Label)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "    <edge source=\"%v\" target=\"%v\">\n      <data key=\"label\">%v</data>\n      <data key=\"broken\">%v</data>\n      <data key=\"edge_diff\">%v</data>\n    </edge>\n", source, target, label, edge.broken(), edge.Diff)
+	}
+	b.WriteString("  </graph>\n</graphml>\n")
+	return b.String(), nil
+}
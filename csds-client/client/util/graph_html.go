@@ -0,0 +1,364 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sync"
+)
+
+// Modes of the visualization
+const (
+	// VisualizationGraph saves the graph in -graph_format and renders a dot graph to an image
+	VisualizationGraph string = "graph"
+	// VisualizationHtml saves the graph as a self-contained interactive html page
+	VisualizationHtml string = "html"
+	// VisualizationTree prints the graph as a tree to the terminal, which needs no browser or Graphviz
+	VisualizationTree string = "tree"
+)
+
+// htmlNode is a node of the graph embedded in the html viewer
+type htmlNode struct {
+	ID     string   `json:"id"`
+	Client string   `json:"client"`
+	Xds    string   `json:"xds"`
+	Column string   `json:"column"`
+	Name   string   `json:"name"`
+	Detail []string `json:"detail,omitempty"`
+	State  string   `json:"state"`
+	Error  string   `json:"error,omitempty"`
+	// Diff and Changes are the change of the node in a graph diff
+	Diff    string   `json:"diff,omitempty"`
+	Changes []string `json:"changes,omitempty"`
+	// Fill, Stroke, Font, Dashed, Bold and Note are the style of the node for the state of its
+	// resource
+	Fill   string          `json:"fill"`
+	Stroke string          `json:"stroke"`
+	Font   string          `json:"font"`
+	Dashed bool            `json:"dashed,omitempty"`
+	Bold   bool            `json:"bold,omitempty"`
+	Note   string          `json:"note,omitempty"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// htmlEdge is an edge of the graph embedded in the html viewer
+type htmlEdge struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Label  string `json:"label,omitempty"`
+	Broken bool   `json:"broken,omitempty"`
+	// Diff is the change of the edge in a graph diff, and Color and Dashed are the style of its
+	// line if it is broken or changed
+	Diff   string `json:"diff,omitempty"`
+	Color  string `json:"color,omitempty"`
+	Dashed bool   `json:"dashed,omitempty"`
+}
+
+// htmlGraph is the graph embedded in the html viewer
+type htmlGraph struct {
+	Clients []string `json:"clients"`
+	// Columns are the xds types, and the kinds of the parts of the resources, of the nodes
+	Columns []string   `json:"columns"`
+	Nodes   []htmlNode `json:"nodes"`
+	Edges   []htmlEdge `json:"edges"`
+}
+
+// GenerateHtml generates a self-contained html page that shows graph with pan and zoom, tooltips,
+// search, and the config of the resource that is clicked. It does not load anything from the
+// network, so it can be viewed offline.
+func GenerateHtml(graph *Graph) (string, error) {
+	data := htmlGraph{Clients: graph.Clients, Columns: []string{}, Nodes: []htmlNode{}, Edges: []htmlEdge{}}
+	if data.Clients == nil {
+		data.Clients = []string{}
+	}
+	// only the columns with nodes are shown
+	shown := make(map[string]bool)
+	for _, node := range graph.Nodes {
+		shown[node.column()] = true
+	}
+	for _, column := range graphColumns {
+		if shown[column] {
+			data.Columns = append(data.Columns, column)
+		}
+	}
+	for _, node := range graph.Nodes {
+		style := node.style()
+		n := htmlNode{
+			ID:      node.ID,
+			Client:  node.Client,
+			Xds:     node.Xds,
+			Column:  node.column(),
+			Name:    node.Name,
+			Detail:  node.Detail,
+			State:   node.State,
+			Error:   node.Error,
+			Diff:    node.Diff,
+			Changes: node.Changes,
+			Fill:    style.fill,
+			Stroke:  style.border,
+			Font:    style.font,
+			Dashed:  style.dashed,
+			Bold:    style.bold,
+			Note:    style.note,
+		}
+		if n.Fill == "" {
+			n.Fill = "white"
+		}
+		if node.Config != nil {
+			if config, err := marshalConfig(node.Config); err == nil {
+				n.Config = config
+			}
+		}
+		data.Nodes = append(data.Nodes, n)
+	}
+	for _, edge := range graph.Edges {
+		data.Edges = append(data.Edges, htmlEdge{From: edge.From.ID, To: edge.To.ID, Label: edge.Label, Broken: edge.broken(), Diff: edge.Diff, Color: edge.color(), Dashed: edge.dashed()})
+	}
+
+	var out bytes.Buffer
+	if err := graphHtmlTemplate.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// GraphViewer serves the html viewer of the graph of the latest response
+type GraphViewer struct {
+	mu   sync.RWMutex
+	page string
+}
+
+// NewGraphViewer creates a GraphViewer without any graph
+func NewGraphViewer() *GraphViewer {
+	return &GraphViewer{}
+}
+
+// Update replaces the graph the viewer serves with graph
+func (v *GraphViewer) Update(graph *Graph) error {
+	page, err := GenerateHtml(graph)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.page = page
+	return nil
+}
+
+// ServeHTTP serves the html viewer
+func (v *GraphViewer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.page == "" {
+		http.Error(w, "no response from the control plane yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(v.page))
+}
+
+// graphHtmlTemplate is the html viewer, the graph of each client is laid out in a block with a
+// column per xds type or kind of part, and the blocks are stacked
+var graphHtmlTemplate = template.Must(template.New("graph").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>xDS config graph</title>
+<style>
+  body { margin: 0; font-family: Roboto, Arial, sans-serif; display: flex; height: 100vh; overflow: hidden; }
+  #main { flex: 1; position: relative; }
+  #toolbar { position: absolute; top: 8px; left: 8px; z-index: 1; }
+  #search { width: 240px; padding: 4px 8px; }
+  #count { margin-left: 8px; color: #555; font-size: 13px; }
+  svg { width: 100%; height: 100%; cursor: grab; background: #fafafa; }
+  svg.dragging { cursor: grabbing; }
+  .node rect { rx: 6; ry: 6; stroke-width: 2; cursor: pointer; }
+  .node text { font-size: 13px; pointer-events: none; }
+  .node.dim { opacity: 0.2; }
+  .node.match rect { stroke: black; stroke-width: 3; }
+  .node.selected rect { stroke: black; stroke-width: 4; }
+  .node.dashed rect { stroke-dasharray: 4 3; }
+  .node.bold rect { stroke-width: 4; }
+  .edge { fill: none; stroke: #888; stroke-width: 1.2; }
+  .edge.dashed { stroke-dasharray: 5 4; }
+  .edge.diff { stroke-width: 2.5; }
+  .edge.dim { opacity: 0.1; }
+  .label { font-size: 11px; fill: #555; }
+  .label.dim { opacity: 0.1; }
+  .header { font-size: 15px; font-weight: bold; fill: #333; }
+  .client { font-size: 17px; font-weight: bold; fill: #111; }
+  .block { fill: none; stroke: #ccc; stroke-dasharray: 6 4; }
+  #tooltip { position: absolute; display: none; background: #333; color: white; padding: 6px 8px;
+    border-radius: 4px; font-size: 12px; pointer-events: none; white-space: pre; z-index: 2; }
+  #panel { width: 40%; max-width: 640px; border-left: 1px solid #ddd; overflow: auto; padding: 12px; box-sizing: border-box; }
+  #panel pre { font-size: 12px; white-space: pre-wrap; word-break: break-all; }
+</style>
+</head>
+<body>
+<div id="main">
+  <div id="toolbar"><input id="search" placeholder="Search resources" autofocus><span id="count"></span></div>
+  <svg id="graph"><defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto"><path d="M0,0L10,5L0,10z" fill="#888"/></marker><marker id="broken" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto"><path d="M8,0L10,0L10,10L8,10z" fill="#D93025"/></marker></defs><g id="viewport"></g></svg>
+  <div id="tooltip"></div>
+</div>
+<div id="panel"><p>Click a resource to show its config. Drag to pan, scroll to zoom.</p></div>
+<script>
+const graph = {{.}};
+const svgNS = "http://www.w3.org/2000/svg";
+const nodeWidth = 220, nodeHeight = 32, columnGap = 120, rowGap = 16, headerHeight = 60, blockGap = 40;
+const grouped = graph.clients.length > 1;
+const svg = document.getElementById("graph");
+const viewport = document.getElementById("viewport");
+const tooltip = document.getElementById("tooltip");
+const panel = document.getElementById("panel");
+
+function element(name, attrs, parent) {
+  const e = document.createElementNS(svgNS, name);
+  for (const key in attrs) e.setAttribute(key, attrs[key]);
+  parent.appendChild(e);
+  return e;
+}
+
+function truncate(s, n) {
+  return s.length > n ? s.slice(0, n - 1) + "…" : s;
+}
+
+// lay out the nodes of each client in a block with a column per xds type or kind, the name of the client
+// is shown above its block if there are many clients
+const positions = {};
+let blockTop = 0;
+graph.clients.forEach(client => {
+  const clientTop = blockTop + (grouped ? 30 : 0);
+  let rows = 0;
+  graph.columns.forEach((name, column) => {
+    const x = 20 + column * (nodeWidth + columnGap);
+    const members = graph.nodes.filter(n => n.client === client && n.column === name);
+    if (members.length > 0 || !grouped) {
+      element("text", {x: x, y: clientTop + headerHeight - 20, class: "header"}, viewport).textContent = name;
+    }
+    members.forEach((node, row) => {
+      positions[node.id] = {x: x, y: clientTop + headerHeight + row * (nodeHeight + rowGap)};
+    });
+    rows = Math.max(rows, members.length);
+  });
+  const bottom = clientTop + headerHeight + rows * (nodeHeight + rowGap);
+  if (grouped) {
+    element("text", {x: 10, y: blockTop + 20, class: "client"}, viewport).textContent = client;
+    element("rect", {x: 5, y: blockTop, width: graph.columns.length * (nodeWidth + columnGap) - columnGap + 30, height: bottom - blockTop, class: "block"}, viewport);
+  }
+  blockTop = bottom + blockGap;
+});
+
+const edges = graph.edges.map(edge => {
+  const from = positions[edge.from], to = positions[edge.to];
+  const x1 = from.x + nodeWidth, y1 = from.y + nodeHeight / 2, x2 = to.x, y2 = to.y + nodeHeight / 2;
+  const path = element("path", {
+    class: "edge" + (edge.dashed ? " dashed" : "") + (edge.diff ? " diff" : ""), "marker-end": edge.broken ? "url(#broken)" : "url(#arrow)",
+    style: edge.color ? "stroke: " + edge.color : "",
+    d: "M" + x1 + "," + y1 + " C" + (x1 + columnGap / 2) + "," + y1 + " " + (x2 - columnGap / 2) + "," + y2 + " " + x2 + "," + y2,
+  }, viewport);
+  let label = null;
+  if (edge.label) {
+    label = element("text", {x: (x1 + x2) / 2, y: (y1 + y2) / 2 - 4, "text-anchor": "middle", class: "label"}, viewport);
+    label.textContent = edge.label;
+  }
+  return {edge: edge, path: path, label: label};
+});
+
+const nodes = graph.nodes.map(node => {
+  const p = positions[node.id];
+  const g = element("g", {class: "node" + (node.dashed ? " dashed" : "") + (node.bold ? " bold" : ""), transform: "translate(" + p.x + "," + p.y + ")"}, viewport);
+  element("rect", {width: nodeWidth, height: nodeHeight, fill: node.fill, stroke: node.stroke}, g);
+  element("text", {x: 10, y: 21, fill: node.font}, g).textContent = truncate(node.name + (node.note ? " " + node.note : ""), 28);
+  g.addEventListener("mousemove", e => {
+    tooltip.textContent = [node.name, node.client + "  " + node.column + "  " + (node.state || "not in the response")].concat(node.detail || [], node.error ? [node.error] : [], node.diff ? [node.diff] : [], node.changes || []).join("\n");
+    tooltip.style.display = "block";
+    tooltip.style.left = (e.offsetX + 12) + "px";
+    tooltip.style.top = (e.offsetY + 12) + "px";
+  });
+  g.addEventListener("mouseleave", () => { tooltip.style.display = "none"; });
+  g.addEventListener("click", e => { e.stopPropagation(); select(node); });
+  return {node: node, g: g};
+});
+
+function select(node) {
+  nodes.forEach(n => n.g.classList.toggle("selected", n.node === node));
+  panel.innerHTML = "";
+  const title = document.createElement("h3");
+  title.textContent = node.name;
+  panel.appendChild(title);
+  const info = document.createElement("p");
+  info.textContent = node.column + " of " + node.client + ", " + (node.state || "not in the response");
+  panel.appendChild(info);
+  (node.detail || []).forEach(line => {
+    const detail = document.createElement("div");
+    detail.textContent = line;
+    panel.appendChild(detail);
+  });
+  if (node.error) {
+    const error = document.createElement("p");
+    error.style.color = "#D93025";
+    error.textContent = "Rejected: " + node.error;
+    panel.appendChild(error);
+  }
+  if (node.diff) {
+    const diff = document.createElement("p");
+    diff.style.color = node.stroke;
+    diff.textContent = node.diff.charAt(0) + node.diff.slice(1).toLowerCase() + (node.changes ? ": " + node.changes.join(", ") : "");
+    panel.appendChild(diff);
+  }
+  const pre = document.createElement("pre");
+  pre.textContent = node.config ? JSON.stringify(node.config, null, 2) : "No config.";
+  panel.appendChild(pre);
+}
+
+// pan and zoom
+let scale = 1, tx = 0, ty = 0, drag = null;
+function transform() {
+  viewport.setAttribute("transform", "translate(" + tx + "," + ty + ") scale(" + scale + ")");
+}
+svg.addEventListener("mousedown", e => { drag = {x: e.clientX - tx, y: e.clientY - ty}; svg.classList.add("dragging"); });
+window.addEventListener("mousemove", e => { if (drag) { tx = e.clientX - drag.x; ty = e.clientY - drag.y; transform(); } });
+window.addEventListener("mouseup", () => { drag = null; svg.classList.remove("dragging"); });
+svg.addEventListener("wheel", e => {
+  e.preventDefault();
+  const factor = e.deltaY < 0 ? 1.1 : 1 / 1.1;
+  tx = e.offsetX - (e.offsetX - tx) * factor;
+  ty = e.offsetY - (e.offsetY - ty) * factor;
+  scale *= factor;
+  transform();
+}, {passive: false});
+
+// search highlights the matching resources and centers the first one on enter
+const search = document.getElementById("search");
+const count = document.getElementById("count");
+function matches() {
+  const query = search.value.toLowerCase();
+  return query ? nodes.filter(n => n.node.name.toLowerCase().includes(query) || n.node.id.toLowerCase().includes(query)) : [];
+}
+search.addEventListener("input", () => {
+  const found = matches();
+  const query = search.value;
+  nodes.forEach(n => {
+    n.g.classList.toggle("match", found.includes(n));
+    n.g.classList.toggle("dim", query !== "" && !found.includes(n));
+  });
+  edges.forEach(e => {
+    e.path.classList.toggle("dim", query !== "");
+    if (e.label) e.label.classList.toggle("dim", query !== "");
+  });
+  count.textContent = query ? found.length + " found" : "";
+});
+search.addEventListener("keydown", e => {
+  const found = matches();
+  if (e.key !== "Enter" || found.length === 0) return;
+  const p = positions[found[0].node.id];
+  tx = svg.clientWidth / 2 - (p.x + nodeWidth / 2) * scale;
+  ty = svg.clientHeight / 2 - (p.y + nodeHeight / 2) * scale;
+  transform();
+  select(found[0].node);
+});
+</script>
+</body>
+</html>
+`))
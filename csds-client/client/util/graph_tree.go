@@ -0,0 +1,134 @@
+package util
+
+import (
+	"strings"
+)
+
+// treeHiddenKinds are the kinds of the parts that are left out of the tree, their children are
+// shown under their parents with the description of the part
+var treeHiddenKinds = map[string]bool{GraphFilterChain: true, GraphRoute: true}
+
+// treeBranch is a node of the tree with the names of the hidden parts and the label of the edge
+// it is reached through
+type treeBranch struct {
+	node *GraphNode
+	via  []string
+}
+
+// GenerateTree generates graph as a tree of text, with a root for each client and the resources
+// that no other resource refers to, e.g. the listeners, under it. Each resource is followed by
+// the resources it refers to, e.g. listener, route configuration, virtual host, cluster, then
+// endpoints. The filter chains and the routes are left out, and the matches of the routes and the
+// weights of the clusters are noted next to the clusters. A resource that is referred to many
+// times is repeated. The states are colored if color is set.
+func GenerateTree(graph *Graph, color bool) string {
+	referred := make(map[*GraphNode]bool)
+	for _, edge := range graph.Edges {
+		referred[edge.To] = true
+	}
+	var b strings.Builder
+	for _, client := range graph.Clients {
+		b.WriteString(client + "\n")
+		var roots []treeBranch
+		for _, node := range graph.Nodes {
+			if node.Client == client && !referred[node] {
+				roots = append(roots, treeBranch{node: node})
+			}
+		}
+		writeTreeBranches(&b, graph, roots, "", map[*GraphNode]bool{}, color)
+	}
+	return b.String()
+}
+
+// treeChildren returns the branches under node, the children of its hidden parts are shown in
+// place of the parts
+func treeChildren(graph *Graph, node *GraphNode, via []string) []treeBranch {
+	var children []treeBranch
+	for _, edge := range graph.Edges {
+		if edge.From != node {
+			continue
+		}
+		path := append([]string{}, via...)
+		if edge.Label != "" {
+			path = append(path, edge.Label)
+		}
+		if treeHiddenKinds[edge.To.Kind] {
+			// a filter chain is described by its detail, e.g. its matches, since its name is usually
+			// generated, and a route is described by its match and its detail
+			if edge.To.Kind == GraphRoute {
+				path = append(path, edge.To.Name)
+			}
+			path = append(path, edge.To.Detail...)
+			children = append(children, treeChildren(graph, edge.To, path)...)
+			continue
+		}
+		children = append(children, treeBranch{node: edge.To, via: path})
+	}
+	return children
+}
+
+// writeTreeBranches writes branches and the branches under them with prefix, ancestors holds the
+// nodes on the path to branches, which are not expanded again
+func writeTreeBranches(b *strings.Builder, graph *Graph, branches []treeBranch, prefix string, ancestors map[*GraphNode]bool, color bool) {
+	for i, branch := range branches {
+		connector, indent := "├── ", "│   "
+		if i == len(branches)-1 {
+			connector, indent = "└── ", "    "
+		}
+		b.WriteString(prefix + connector + treeLine(branch, color) + "\n")
+		if ancestors[branch.node] {
+			continue
+		}
+		ancestors[branch.node] = true
+		writeTreeBranches(b, graph, treeChildren(graph, branch.node, nil), prefix+indent, ancestors, color)
+		delete(ancestors, branch.node)
+	}
+}
+
+// treeLine describes the node of branch in a line: the xds type or the kind, the name, the state
+// and the detail of the node, the changes of a graph diff and the hidden parts it is reached through
+func treeLine(branch treeBranch, color bool) string {
+	node := branch.node
+	line := node.column() + " " + node.Name
+	// the parts have the state of their resources
+	if node.Kind == "" {
+		state := node.State
+		if state == "" {
+			state = "MISSING"
+		}
+		line += " " + colorTreeState(state, color)
+	}
+	if node.Error != "" {
+		line += ": " + node.Error
+	}
+	if node.Diff != "" {
+		line += " " + colorTreeState(node.Diff, color)
+	}
+	if len(node.Detail) > 0 {
+		line += " (" + strings.Join(node.Detail, ", ") + ")"
+	}
+	if len(branch.via) > 0 {
+		line += " [" + strings.Join(branch.via, ", ") + "]"
+	}
+	return line
+}
+
+// treeStateColors maps the states of the resources and the changes of a graph diff to the colors
+// they are shown in
+var treeStateColors = map[string]string{
+	ResourceNacked:   colorRed,
+	"MISSING":        colorRed,
+	ResourceWarming:  colorYellow,
+	ResourceDraining: colorYellow,
+	GraphAdded:       colorGreen,
+	GraphRemoved:     colorRed,
+	GraphChanged:     colorYellow,
+}
+
+// colorTreeState wraps state in its color if color is enabled
+func colorTreeState(state string, color bool) string {
+	if c, ok := treeStateColors[state]; ok && color {
+		return c + state + colorReset
+	}
+	return state
+}
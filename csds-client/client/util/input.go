@@ -0,0 +1,173 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	envoy_admin_v3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// structTypeUrl is the type url of google.protobuf.Struct, which wraps the google.protobuf.Any
+// values whose types cannot be resolved
+const structTypeUrl = "type.googleapis.com/google.protobuf.Struct"
+
+// ReadResponseFile loads a saved csds response into response, which is a ClientStatusResponse of
+// any xds api version. The file may be a csds response in json or binary proto format, or the
+// json output of the /config_dump endpoint of Envoy admin.
+func ReadResponseFile(path string, response proto.Message) error {
+	filename, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		// binary proto
+		if err := proto.Unmarshal(data, response); err != nil {
+			return fmt.Errorf("failed to parse %v as csds response: %v", path, err)
+		}
+		return nil
+	}
+
+	var js map[string]interface{}
+	if err := json.Unmarshal(data, &js); err != nil {
+		return err
+	}
+	if _, ok := js["configs"]; ok {
+		// Envoy admin config dump, whose dumps are wrapped separately below
+		v3Response, err := configDumpToResponse(js)
+		if err != nil {
+			return fmt.Errorf("failed to parse %v as config dump: %v", path, err)
+		}
+		return ConvertMessage(v3Response, response)
+	}
+	if err := unmarshalJson(wrapUnresolvedAny(js), response); err != nil {
+		return fmt.Errorf("failed to parse %v as csds response: %v", path, err)
+	}
+	return nil
+}
+
+// unmarshalJson parses the json object js to message and resolves google.protobuf.Any types
+func unmarshalJson(js interface{}, message proto.Message) error {
+	b, err := json.Marshal(js)
+	if err != nil {
+		return err
+	}
+	u := protojson.UnmarshalOptions{DiscardUnknown: true, Resolver: &TypeResolver{}}
+	return u.Unmarshal(b, message)
+}
+
+// wrapUnresolvedAny wraps the google.protobuf.Any values in js whose types cannot be resolved in
+// google.protobuf.Struct, so that configs using extensions unknown to the client can still be loaded.
+// The original type url is kept in the "@type" field of the struct.
+func wrapUnresolvedAny(js interface{}) interface{} {
+	switch value := js.(type) {
+	case map[string]interface{}:
+		if typeUrl, ok := value["@type"].(string); ok && typeUrl != structTypeUrl {
+			if _, err := (&TypeResolver{}).FindMessageByURL(typeUrl); err != nil {
+				return map[string]interface{}{"@type": structTypeUrl, "value": value}
+			}
+		}
+		for key, field := range value {
+			value[key] = wrapUnresolvedAny(field)
+		}
+	case []interface{}:
+		for i, element := range value {
+			value[i] = wrapUnresolvedAny(element)
+		}
+	}
+	return js
+}
+
+// configDumpToResponse converts the json of an Envoy admin config dump to a csds response with a
+// single client. As Envoy does not know whether its config is synced with the control plane, the
+// config status of each xds config is UNKNOWN.
+func configDumpToResponse(js map[string]interface{}) (*csdspb_v3.ClientStatusResponse, error) {
+	configs, ok := js["configs"].([]interface{})
+	if !ok {
+		return nil, errors.New("configs is not a list")
+	}
+
+	config := &csdspb_v3.ClientConfig{}
+	for _, c := range configs {
+		dump, ok := c.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("config is not an object")
+		}
+		typeUrl, _ := dump["@type"].(string)
+		if typeUrl == structTypeUrl {
+			// the dump is wrapped since its type is not resolved
+			if dump, ok = dump["value"].(map[string]interface{}); !ok {
+				return nil, errors.New("config is not an object")
+			}
+			typeUrl, _ = dump["@type"].(string)
+		}
+
+		// the dumps of v2alpha and v3 admin api share the same json format
+		fields := make(map[string]interface{}, len(dump))
+		for key, value := range dump {
+			if key != "@type" {
+				fields[key] = wrapUnresolvedAny(value)
+			}
+		}
+		perXdsConfig := &csdspb_v3.PerXdsConfig{}
+		switch typeUrl[strings.LastIndex(typeUrl, ".")+1:] {
+		case "BootstrapConfigDump":
+			bootstrap, _ := fields["bootstrap"].(map[string]interface{})
+			if node, ok := bootstrap["node"]; ok {
+				config.Node = &envoy_config_core_v3.Node{}
+				if err := unmarshalJson(node, config.Node); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		case "ListenersConfigDump":
+			dump := &envoy_admin_v3.ListenersConfigDump{}
+			perXdsConfig.PerXdsConfig = &csdspb_v3.PerXdsConfig_ListenerConfig{ListenerConfig: dump}
+			if err := unmarshalJson(fields, dump); err != nil {
+				return nil, err
+			}
+		case "RoutesConfigDump":
+			dump := &envoy_admin_v3.RoutesConfigDump{}
+			perXdsConfig.PerXdsConfig = &csdspb_v3.PerXdsConfig_RouteConfig{RouteConfig: dump}
+			if err := unmarshalJson(fields, dump); err != nil {
+				return nil, err
+			}
+		case "ScopedRoutesConfigDump":
+			dump := &envoy_admin_v3.ScopedRoutesConfigDump{}
+			perXdsConfig.PerXdsConfig = &csdspb_v3.PerXdsConfig_ScopedRouteConfig{ScopedRouteConfig: dump}
+			if err := unmarshalJson(fields, dump); err != nil {
+				return nil, err
+			}
+		case "ClustersConfigDump":
+			dump := &envoy_admin_v3.ClustersConfigDump{}
+			perXdsConfig.PerXdsConfig = &csdspb_v3.PerXdsConfig_ClusterConfig{ClusterConfig: dump}
+			if err := unmarshalJson(fields, dump); err != nil {
+				return nil, err
+			}
+		case "EndpointsConfigDump":
+			dump := &envoy_admin_v3.EndpointsConfigDump{}
+			perXdsConfig.PerXdsConfig = &csdspb_v3.PerXdsConfig_EndpointConfig{EndpointConfig: dump}
+			if err := unmarshalJson(fields, dump); err != nil {
+				return nil, err
+			}
+		default:
+			// other dumps (e.g. secrets) cannot be carried by csds response
+			continue
+		}
+		config.XdsConfig = append(config.XdsConfig, perXdsConfig)
+	}
+	return &csdspb_v3.ClientStatusResponse{Config: []*csdspb_v3.ClientConfig{config}}, nil
+}
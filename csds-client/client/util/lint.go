@@ -0,0 +1,366 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+)
+
+// Severities of the findings of the lint rules
+const (
+	LintError   string = "error"
+	LintWarning string = "warning"
+	LintInfo    string = "info"
+)
+
+// Formats of the findings of lint
+const (
+	LintText string = "text"
+	LintJson string = "json"
+)
+
+// lintExitCode is the exit code of lint in one-shot mode, or at the end of a replay, if any
+// finding is an error
+const lintExitCode = 2
+
+// maxLintRetries is the number of retries above which a retry policy is reported by the
+// retry-storm rule
+const maxLintRetries = 3
+
+// defaultTotalWeight is the total weight of the weighted clusters of a route that do not set one
+const defaultTotalWeight = 100
+
+// severityColors maps the severities of the findings to the colors they are shown in
+var severityColors = map[string]string{
+	LintError:   colorRed,
+	LintWarning: colorYellow,
+}
+
+// LintFinding is a problem found in the config of a client by a lint rule
+type LintFinding struct {
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Client   string `json:"client"`
+	// Resource is the xds type and the name of the resource, e.g. RDS/route_1
+	Resource string `json:"resource"`
+	Message  string `json:"message"`
+}
+
+// lintReport reports a finding of a lint rule in node with the message formatted from format and args
+type lintReport func(node *GraphNode, format string, args ...interface{})
+
+// LintRule is a check of the config of each client
+type LintRule struct {
+	Name     string
+	Severity string
+	// check reports the problems of client c, whose resources and the references between them are
+	// in graph
+	check func(c *ClientSnapshot, graph *Graph, report lintReport)
+}
+
+// lintRules are the lint rules in the order they run
+var lintRules = []*LintRule{
+	{Name: "missing-cluster", Severity: LintError, check: lintMissingCluster},
+	{Name: "missing-endpoints", Severity: LintWarning, check: lintMissingEndpoints},
+	{Name: "orphaned-resource", Severity: LintInfo, check: lintOrphanedResource},
+	{Name: "weighted-cluster-total", Severity: LintError, check: lintRoutes(lintWeightedClusterTotal)},
+	{Name: "missing-route-timeout", Severity: LintInfo, check: lintRoutes(lintMissingRouteTimeout)},
+	{Name: "retry-storm", Severity: LintWarning, check: lintRoutes(lintRetryStorm)},
+	{Name: "duplicate-domain", Severity: LintError, check: lintRoutes(lintDuplicateDomain)},
+}
+
+// Linter runs the enabled lint rules over the clients in the responses
+type Linter struct {
+	Rules  []*LintRule
+	Format string
+}
+
+// NewLinter creates a Linter that prints out the findings in format, which is text or json. rules
+// is a comma separated list of the names of the rules to run, all of them run if it is empty or
+// only lists disabled rules. A name prefixed with - disables the rule.
+func NewLinter(rules string, format string) (*Linter, error) {
+	switch format {
+	case "", LintText:
+		format = LintText
+	case LintJson:
+	default:
+		return nil, fmt.Errorf("unsupported lint format: %v", format)
+	}
+	enabled := make(map[string]bool)
+	disabled := make(map[string]bool)
+	for _, name := range strings.Split(rules, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		selected := enabled
+		if strings.HasPrefix(name, "-") {
+			name, selected = name[1:], disabled
+		}
+		if lintRule(name) == nil {
+			return nil, fmt.Errorf("unsupported lint rule: %v", name)
+		}
+		selected[name] = true
+	}
+	l := &Linter{Format: format}
+	for _, rule := range lintRules {
+		if (len(enabled) == 0 || enabled[rule.Name]) && !disabled[rule.Name] {
+			l.Rules = append(l.Rules, rule)
+		}
+	}
+	return l, nil
+}
+
+// lintRule returns the lint rule with name, or nil if there is none
+func lintRule(name string) *LintRule {
+	for _, rule := range lintRules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Lint runs the rules over each client in snapshot, and returns the findings ordered by client,
+// then by rule
+func (l *Linter) Lint(snapshot *Snapshot) []LintFinding {
+	graph := snapshotGraph(snapshot, GraphDetailResources)
+	findings := []LintFinding{}
+	for _, c := range snapshot.Clients {
+		clientGraph := graph.ForClient(c.ID)
+		for _, rule := range l.Rules {
+			rule.check(c, clientGraph, func(node *GraphNode, format string, args ...interface{}) {
+				findings = append(findings, LintFinding{
+					Severity: rule.Severity,
+					Rule:     rule.Name,
+					Client:   c.ID,
+					Resource: node.Xds + "/" + node.Name,
+					Message:  fmt.Sprintf(format, args...),
+				})
+			})
+		}
+	}
+	return findings
+}
+
+// Print prints out findings to w, as a table followed by the number of findings of each severity,
+// or as a json array. The severities are colored in the table if color is set.
+func (l *Linter) Print(w io.Writer, findings []LintFinding, color bool) error {
+	if l.Format == LintJson {
+		out, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(out))
+		return err
+	}
+
+	if len(findings) == 0 {
+		_, err := fmt.Fprintln(w, "No lint findings.")
+		return err
+	}
+	rows := [][]string{{"Severity", "Client", "Resource", "Rule", "Message"}}
+	counts := make(map[string]int)
+	for _, f := range findings {
+		rows = append(rows, []string{f.Severity, f.Client, f.Resource, f.Rule, f.Message})
+		counts[f.Severity]++
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if width := utf8.RuneCountInString(cell); width > widths[i] {
+				widths[i] = width
+			}
+		}
+	}
+	for r, row := range rows {
+		var line strings.Builder
+		for i, cell := range row {
+			padding := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+			if c, ok := severityColors[cell]; ok && color && r > 0 && i == 0 {
+				cell = c + cell + colorReset
+			}
+			line.WriteString(cell + padding + columnSeparator)
+		}
+		fmt.Fprintln(w, strings.TrimRight(line.String(), " "))
+	}
+	_, err := fmt.Fprintf(w, "%d finding(s): %d error(s), %d warning(s), %d info\n", len(findings), counts[LintError], counts[LintWarning], counts[LintInfo])
+	return err
+}
+
+// hasLintErrors checks if any of findings is an error
+func hasLintErrors(findings []LintFinding) bool {
+	for _, f := range findings {
+		if f.Severity == LintError {
+			return true
+		}
+	}
+	return false
+}
+
+// lintMissingCluster reports the references to the clusters that are not in CDS, which is only
+// checked if the client has a CDS config in the response
+func lintMissingCluster(c *ClientSnapshot, graph *Graph, report lintReport) {
+	if c.XdsConfig("CDS") == nil {
+		return
+	}
+	for _, edge := range graph.Edges {
+		if edge.To.Xds == "CDS" && edge.To.State == "" {
+			report(edge.From, "refers to cluster %v, which is not in CDS", edge.To.Name)
+		}
+	}
+}
+
+// lintMissingEndpoints reports the clusters of EDS type without a cluster load assignment, or
+// whose cluster load assignment has no endpoints, which is only checked if the client has an EDS
+// config in the response
+func lintMissingEndpoints(c *ClientSnapshot, graph *Graph, report lintReport) {
+	if c.XdsConfig("EDS") == nil {
+		return
+	}
+	for _, node := range graph.Nodes {
+		cluster := &envoy_config_cluster_v3.Cluster{}
+		if node.Xds != "CDS" || node.Config == nil || unpackAny(node.Config, cluster) != nil || cluster.GetType() != envoy_config_cluster_v3.Cluster_EDS {
+			continue
+		}
+		var assignment *GraphNode
+		for _, edge := range graph.Edges {
+			if edge.From == node && edge.To.Xds == "EDS" && edge.To.Config != nil {
+				assignment = edge.To
+			}
+		}
+		if assignment == nil {
+			report(node, "uses EDS but has no cluster load assignment")
+			continue
+		}
+		clusterLoadAssignment := &envoy_config_endpoint_v3.ClusterLoadAssignment{}
+		if unpackAny(assignment.Config, clusterLoadAssignment) != nil {
+			continue
+		}
+		endpoints := 0
+		for _, localityEndpoints := range clusterLoadAssignment.GetEndpoints() {
+			endpoints += len(localityEndpoints.GetLbEndpoints())
+		}
+		if endpoints == 0 {
+			report(node, "has a cluster load assignment without endpoints")
+		}
+	}
+}
+
+// lintOrphanedResource reports the resources in the response other than the listeners that no
+// resource in the response refers to
+func lintOrphanedResource(c *ClientSnapshot, graph *Graph, report lintReport) {
+	referred := make(map[*GraphNode]bool)
+	for _, edge := range graph.Edges {
+		if edge.From.State != "" {
+			referred[edge.To] = true
+		}
+	}
+	for _, node := range graph.Nodes {
+		if node.Xds != "LDS" && node.Config != nil && node.State != ResourceInline && !referred[node] {
+			report(node, "is not referred to by any other resource")
+		}
+	}
+}
+
+// lintRoutes returns a check of the route configurations in the response and inline in the
+// listeners with check
+func lintRoutes(check func(routeConfig *envoy_config_route_v3.RouteConfiguration, report func(format string, args ...interface{}))) func(*ClientSnapshot, *Graph, lintReport) {
+	return func(c *ClientSnapshot, graph *Graph, report lintReport) {
+		for _, node := range graph.Nodes {
+			routeConfig := &envoy_config_route_v3.RouteConfiguration{}
+			if node.Xds != "RDS" || node.Config == nil || unpackAny(node.Config, routeConfig) != nil {
+				continue
+			}
+			check(routeConfig, func(format string, args ...interface{}) {
+				report(node, format, args...)
+			})
+		}
+	}
+}
+
+// lintRouteName describes the i-th route of virtualHost in the findings
+func lintRouteName(virtualHost *envoy_config_route_v3.VirtualHost, i int, route *envoy_config_route_v3.Route) string {
+	name := route.GetName()
+	if name == "" {
+		name = strconv.Itoa(i) + " (" + formatRouteMatch(route.GetMatch()) + ")"
+	}
+	return "virtual host " + virtualHost.GetName() + " route " + name
+}
+
+// lintWeightedClusterTotal reports the weighted clusters whose weights do not add up to their
+// total weight, which is 100 by default
+func lintWeightedClusterTotal(routeConfig *envoy_config_route_v3.RouteConfiguration, report func(format string, args ...interface{})) {
+	for _, virtualHost := range routeConfig.GetVirtualHosts() {
+		for i, route := range virtualHost.GetRoutes() {
+			weights := route.GetRoute().GetWeightedClusters()
+			if weights == nil {
+				continue
+			}
+			total := uint32(defaultTotalWeight)
+			if weights.GetTotalWeight() != nil {
+				total = weights.GetTotalWeight().GetValue()
+			}
+			sum := uint32(0)
+			for _, cluster := range weights.GetClusters() {
+				sum += cluster.GetWeight().GetValue()
+			}
+			if sum != total {
+				report("%v: the weights of the clusters add up to %d, not %d", lintRouteName(virtualHost, i, route), sum, total)
+			}
+		}
+	}
+}
+
+// lintMissingRouteTimeout reports the routes to clusters without a timeout, which then have the
+// default timeout of 15s
+func lintMissingRouteTimeout(routeConfig *envoy_config_route_v3.RouteConfiguration, report func(format string, args ...interface{})) {
+	for _, virtualHost := range routeConfig.GetVirtualHosts() {
+		for i, route := range virtualHost.GetRoutes() {
+			if route.GetRoute() != nil && route.GetRoute().GetTimeout() == nil {
+				report("%v has no timeout, so the default of 15s applies", lintRouteName(virtualHost, i, route))
+			}
+		}
+	}
+}
+
+// lintRetryStorm reports the retry policies of the virtual hosts and the routes with more than
+// maxLintRetries retries, which multiply the load on a failing upstream
+func lintRetryStorm(routeConfig *envoy_config_route_v3.RouteConfiguration, report func(format string, args ...interface{})) {
+	check := func(name string, policy *envoy_config_route_v3.RetryPolicy) {
+		if policy == nil || policy.GetNumRetries() == nil || policy.GetNumRetries().GetValue() <= maxLintRetries {
+			return
+		}
+		retries := policy.GetNumRetries().GetValue()
+		report("%v retries up to %d times, which multiplies the load on a failing upstream by up to %d", name, retries, retries+1)
+	}
+	for _, virtualHost := range routeConfig.GetVirtualHosts() {
+		check("virtual host "+virtualHost.GetName(), virtualHost.GetRetryPolicy())
+		for i, route := range virtualHost.GetRoutes() {
+			check(lintRouteName(virtualHost, i, route), route.GetRoute().GetRetryPolicy())
+		}
+	}
+}
+
+// lintDuplicateDomain reports the domains that are in more than one virtual host of a route
+// configuration, the domains are compared regardless of case
+func lintDuplicateDomain(routeConfig *envoy_config_route_v3.RouteConfiguration, report func(format string, args ...interface{})) {
+	hosts := make(map[string]string)
+	for _, virtualHost := range routeConfig.GetVirtualHosts() {
+		for _, domain := range virtualHost.GetDomains() {
+			key := strings.ToLower(domain)
+			if host, ok := hosts[key]; ok && host != virtualHost.GetName() {
+				report("domain %v is in virtual hosts %v and %v", domain, host, virtualHost.GetName())
+				continue
+			}
+			hosts[key] = virtualHost.GetName()
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the buckets of the csds request latency histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics holds the metrics of the clients and the csds requests exported in the Prometheus text format
+type Metrics struct {
+	mu       sync.Mutex
+	snapshot *Snapshot
+	// versions holds the versions of the xds configs of each client, joined in a string
+	versions map[string]string
+	// lastVersionChange holds the time the versions of each client last changed
+	lastVersionChange map[string]time.Time
+
+	requestErrors uint64
+	latencyCounts []uint64
+	latencySum    float64
+	latencyCount  uint64
+}
+
+// NewMetrics creates an empty Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		versions:          make(map[string]string),
+		lastVersionChange: make(map[string]time.Time),
+		latencyCounts:     make([]uint64, len(latencyBuckets)),
+	}
+}
+
+// Update sets the metrics of the clients to the ones of snapshot
+func (m *Metrics) Update(snapshot *Snapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	versions := make(map[string]string)
+	lastVersionChange := make(map[string]time.Time)
+	for _, c := range snapshot.Clients {
+		var v []string
+		for _, x := range c.Xds {
+			v = append(v, x.Type+"="+x.Version)
+		}
+		versions[c.ID] = strings.Join(v, ",")
+
+		changed, ok := m.lastVersionChange[c.ID]
+		if !ok {
+			// the client is new, so the best guess is the time its resources were last updated
+			changed = lastUpdated(c)
+			if changed.IsZero() {
+				changed = snapshot.Time
+			}
+		} else if m.versions[c.ID] != versions[c.ID] {
+			changed = snapshot.Time
+		}
+		lastVersionChange[c.ID] = changed
+	}
+	m.snapshot = snapshot
+	m.versions = versions
+	m.lastVersionChange = lastVersionChange
+}
+
+// lastUpdated returns the latest time the resources of client c were updated
+func lastUpdated(c *ClientSnapshot) time.Time {
+	var latest time.Time
+	for _, x := range c.Xds {
+		for _, r := range x.Resources {
+			if r.LastUpdated.After(latest) {
+				latest = r.LastUpdated
+			}
+		}
+	}
+	return latest
+}
+
+// ObserveRequest records a csds request that took d and failed with err if it is not nil
+func (m *Metrics) ObserveRequest(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		m.requestErrors++
+	}
+	seconds := d.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			m.latencyCounts[i]++
+		}
+	}
+	m.latencySum += seconds
+	m.latencyCount++
+}
+
+// escapeLabel escapes the value of a label in the Prometheus text format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// writeSamples writes the samples of a metric, whose keys are the formatted labels, in sorted order
+func writeSamples(w io.Writer, name string, help string, metricType string, samples map[string]float64) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, metricType)
+	var labels []string
+	for label := range samples {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fmt.Fprintf(w, "%v%v %v\n", name, label, samples[label])
+	}
+}
+
+// Export writes the metrics at now to w in the Prometheus text format
+func (m *Metrics) Export(w io.Writer, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	connected := make(map[string]float64)
+	statuses := make(map[string]float64)
+	nacked := make(map[string]float64)
+	sinceVersionChange := make(map[string]float64)
+	if m.snapshot != nil {
+		for _, c := range m.snapshot.Clients {
+			connected[fmt.Sprintf(`{stream_type="%v"}`, escapeLabel(c.StreamType))]++
+			for _, x := range c.Xds {
+				statuses[fmt.Sprintf(`{xds="%v",status="%v"}`, x.Type, x.Status)]++
+				label := fmt.Sprintf(`{xds="%v"}`, x.Type)
+				nacked[label] += 0
+				for _, r := range x.Resources {
+					if r.State == ResourceNacked {
+						nacked[label]++
+					}
+				}
+			}
+			sinceVersionChange[fmt.Sprintf(`{node_id="%v"}`, escapeLabel(c.ID))] = now.Sub(m.lastVersionChange[c.ID]).Seconds()
+		}
+	}
+	writeSamples(w, "csds_connected_clients", "Number of connected clients per xDS stream type.", "gauge", connected)
+	writeSamples(w, "csds_clients", "Number of clients per xDS type and config status.", "gauge", statuses)
+	writeSamples(w, "csds_nacked_resources", "Number of resources rejected by the clients per xDS type.", "gauge", nacked)
+	writeSamples(w, "csds_seconds_since_last_version_change", "Seconds since the xDS versions of each client last changed.", "gauge", sinceVersionChange)
+	writeSamples(w, "csds_request_errors_total", "Number of failed CSDS requests.", "counter", map[string]float64{"": float64(m.requestErrors)})
+
+	name := "csds_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %v Latency of CSDS requests.\n# TYPE %v histogram\n", name, name)
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "%v_bucket{le=\"%v\"} %v\n", name, bound, m.latencyCounts[i])
+	}
+	fmt.Fprintf(w, "%v_bucket{le=\"+Inf\"} %v\n", name, m.latencyCount)
+	fmt.Fprintf(w, "%v_sum %v\n", name, m.latencySum)
+	fmt.Fprintf(w, "%v_count %v\n", name, m.latencyCount)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.Export(w, time.Now())
+}
@@ -0,0 +1,513 @@
+package util
+
+import (
+	"envoy-tools/csds-client/client"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Monitor keeps the state of a client across the responses it receives, e.g. in monitor mode
+type Monitor struct {
+	opts     client.ClientOptions
+	snapshot *Snapshot
+	eventLog *EventLog
+	rules    *AlertRules
+	// seen holds the clients that have been connected
+	seen    map[string]bool
+	metrics *Metrics
+	api     *API
+	// viewer serves the html viewer of the graph in html visualization mode with opts.GraphAddr
+	viewer *GraphViewer
+	// graph is the graph of the previous response, which the graph of a response is diffed against
+	// in graph-diff mode
+	graph    *Graph
+	recorder *Recorder
+	// convergence tracks a rollout in converge mode
+	convergence *Convergence
+	// linter checks the configs of the clients in lint mode
+	linter *Linter
+	// consistency compares the configs of the clients in the same group in consistency mode
+	consistency *Consistency
+	// lintErrors and inconsistent are set if the latest response has lint errors or inconsistent
+	// clients
+	lintErrors   bool
+	inconsistent bool
+	servers      []*http.Server
+	// tui is the terminal ui in tui mode, quit is closed when it exits with tuiErr and closed
+	// is closed to stop it
+	tui        *TUI
+	tuiRunning bool
+	tuiErr     error
+	quit       chan struct{}
+	closed     chan struct{}
+	// now returns the time a response is received at, it is replaced in tests
+	now func() time.Time
+}
+
+// NewMonitor creates a Monitor with the options of the client, the event log is opened if
+// opts.EventLog is set, the alert rules are loaded if opts.AlertRules is set, the metrics are
+// collected if opts.MetricsAddr is set, the snapshots are kept for the api if opts.ServeAddr is set
+// and the session is recorded if opts.RecordFile is set. In converge mode, the rollout of
+// opts.ConvergeVersion is tracked. In tui mode, the snapshots are browsed in the terminal ui. In
+// lint mode, the configs are checked with the lint rules of opts.LintRules. In consistency mode,
+// the configs of the clients are compared within the groups of opts.ConsistencyGroupBy.
+func NewMonitor(opts client.ClientOptions) (monitor *Monitor, err error) {
+	m := &Monitor{
+		opts:   opts,
+		now:    time.Now,
+		seen:   make(map[string]bool),
+		quit:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	// the files opened before a failure are closed
+	defer func() {
+		if err != nil {
+			m.Close()
+		}
+	}()
+	if opts.TUI {
+		m.tui = NewTUI(".", ColorEnabled(os.Stdout))
+	}
+	if opts.AlertRules != "" {
+		rules, err := ParseAlertRules(opts.AlertRules)
+		if err != nil {
+			return nil, err
+		}
+		m.rules = rules
+	}
+	if opts.MetricsAddr != "" {
+		m.metrics = NewMetrics()
+	}
+	if opts.ServeAddr != "" {
+		m.api = NewAPI()
+	}
+	if opts.Visualization == VisualizationHtml && opts.GraphAddr != "" {
+		m.viewer = NewGraphViewer()
+	}
+	if opts.Lint {
+		linter, err := NewLinter(opts.LintRules, opts.LintFormat)
+		if err != nil {
+			return nil, err
+		}
+		m.linter = linter
+	}
+	if opts.ConsistencyGroupBy != "" {
+		m.consistency = NewConsistency(opts.ConsistencyGroupBy)
+	}
+	if opts.ConvergeVersion != "" {
+		m.convergence = NewConvergence(opts.ConvergeType, opts.ConvergeVersion, opts.ConvergeThreshold, opts.ConvergeTimeout, m.now())
+	}
+	if opts.EventLog != "" {
+		eventLog, err := OpenEventLog(opts.EventLog)
+		if err != nil {
+			return nil, err
+		}
+		m.eventLog = eventLog
+	}
+	// the recording is created last, since it replaces the file of a previous recording
+	if opts.RecordFile != "" {
+		recorder, err := OpenRecorder(opts.RecordFile)
+		if err != nil {
+			return nil, err
+		}
+		m.recorder = recorder
+	}
+	return m, nil
+}
+
+// Process handles a response, which is a ClientStatusResponse of any xds api version. print is
+// called to print out the response, except in -watch_diff mode where only the changes since the
+// previous response are printed after the first one. The changes are also appended to the event
+// log if there is one, and the alert rules are evaluated if there are any. In one-shot mode, an
+// ExitError is returned if any of the alert rules is violated. In serve mode, the response is
+// not printed out but answered from by the api. In converge mode, the progress of the rollout is
+// printed out instead of the response. In tui mode, the response is shown in the terminal ui. In
+// lint mode, the findings of the lint rules are printed out instead of the response, and an
+// ExitError is returned in one-shot mode if any of them is an error. In consistency mode, the
+// clients that are inconsistent with their groups are printed out instead of the response, and an
+// ExitError is returned in one-shot mode if there is any.
+func (m *Monitor) Process(response proto.Message, print func() error) error {
+	return m.ProcessAt(response, m.now(), print)
+}
+
+// ProcessAt handles a response received at t the same way as Process, e.g. a replayed response. In
+// graph-diff mode, the changes of the graph since the previous response are visualized after the
+// response is printed out.
+func (m *Monitor) ProcessAt(response proto.Message, t time.Time, print func() error) error {
+	if m.api != nil || m.convergence != nil || m.tui != nil || m.linter != nil || m.consistency != nil {
+		print = func() error { return nil }
+	}
+	if m.viewer != nil || m.opts.GraphDiff {
+		graph, err := m.parseGraph(response)
+		if err != nil {
+			return err
+		}
+		if m.opts.GraphDiff {
+			printResponse := print
+			print = func() error {
+				if err := printResponse(); err != nil {
+					return err
+				}
+				// a replay shows many responses as monitor mode does
+				return VisualizeGraph(graph, m.opts.MonitorInterval != 0 || m.opts.ReplayFile != "", m.opts)
+			}
+		}
+		if m.viewer != nil {
+			if err := m.viewer.Update(graph); err != nil {
+				return err
+			}
+		}
+	}
+	if !m.opts.WatchDiff && m.eventLog == nil && m.rules == nil && m.metrics == nil && m.api == nil && m.convergence == nil && m.tui == nil && m.linter == nil && m.consistency == nil {
+		return print()
+	}
+
+	snapshot, err := NewSnapshot(response, t)
+	if err != nil {
+		return err
+	}
+	previous := m.snapshot
+	m.snapshot = snapshot
+	first := previous == nil
+	if first {
+		previous = &Snapshot{}
+	}
+	changes := DiffSnapshots(previous, snapshot)
+	if m.metrics != nil {
+		m.metrics.Update(snapshot)
+	}
+	if m.api != nil {
+		m.api.Update(snapshot)
+	}
+	if m.tui != nil {
+		m.tui.Update(snapshot)
+	}
+
+	if m.eventLog != nil {
+		// the initial state of the connected clients is logged, so that the log tells the whole story
+		var events []Change
+		for _, change := range changes {
+			events = append(events, change)
+			if change.Kind == ClientConnected {
+				events = append(events, ConnectionChanges(snapshot.Client(change.NodeID))...)
+			}
+		}
+		if err := m.eventLog.Write(events, snapshot.Time, first); err != nil {
+			return err
+		}
+	}
+
+	if m.tui != nil {
+		// the changes would mess up the screen of the terminal ui
+	} else if !m.opts.WatchDiff || first {
+		if err := print(); err != nil {
+			return err
+		}
+	} else {
+		PrintChanges(os.Stdout, changes, snapshot.Time)
+	}
+	if m.convergence != nil {
+		progress := m.convergence.Observe(snapshot)
+		m.convergence.PrintProgress(os.Stdout, progress, snapshot.Time)
+	}
+	if err := m.lint(snapshot); err != nil {
+		return err
+	}
+	if err := m.checkConsistency(snapshot); err != nil {
+		return err
+	}
+
+	return m.alert(snapshot)
+}
+
+// lint checks the configs of the clients in snapshot with the lint rules and prints out the
+// findings. In one-shot mode, an ExitError is returned if any of the findings is an error.
+func (m *Monitor) lint(snapshot *Snapshot) error {
+	if m.linter == nil {
+		return nil
+	}
+	findings := m.linter.Lint(snapshot)
+	if err := m.linter.Print(os.Stdout, findings, ColorEnabled(os.Stdout)); err != nil {
+		return err
+	}
+	m.lintErrors = hasLintErrors(findings)
+	if !m.oneShot() {
+		return nil
+	}
+	return m.lintError()
+}
+
+// lintError returns an ExitError if any of the findings of the latest response is an error
+func (m *Monitor) lintError() error {
+	if !m.lintErrors {
+		return nil
+	}
+	return &client.ExitError{Code: lintExitCode, Err: errors.New("lint found errors in the configs")}
+}
+
+// checkConsistency compares the configs of the clients in the same group of snapshot and prints
+// out the report. In one-shot mode, an ExitError is returned if any client is inconsistent.
+func (m *Monitor) checkConsistency(snapshot *Snapshot) error {
+	if m.consistency == nil {
+		return nil
+	}
+	report := m.consistency.Check(snapshot)
+	m.consistency.Print(os.Stdout, report, snapshot.Time)
+	m.inconsistent = report.Inconsistent()
+	if !m.oneShot() {
+		return nil
+	}
+	return m.consistencyError()
+}
+
+// consistencyError returns an ExitError if any client of the latest response is inconsistent with
+// its group
+func (m *Monitor) consistencyError() error {
+	if !m.inconsistent {
+		return nil
+	}
+	return &client.ExitError{Code: consistencyExitCode, Err: errors.New("some clients are inconsistent with their groups")}
+}
+
+// parseGraph parses the graph of response with the options of the monitor. In graph-diff mode, it
+// is the diff against the graph of the previous response, except for the first response. The whole
+// graphs are diffed, so the resources and the clients that are added or removed are kept by the
+// filters.
+func (m *Monitor) parseGraph(response proto.Message) (*Graph, error) {
+	if !m.opts.GraphDiff {
+		return parseGraph(response, m.opts)
+	}
+	graph, err := parseFullGraph(response, m.opts)
+	if err != nil {
+		return nil, err
+	}
+	previous := m.graph
+	m.graph = graph
+	if previous != nil {
+		graph = DiffGraphs(previous, graph)
+	}
+	return filterGraph(graph, m.opts)
+}
+
+// SetBaseGraph parses the graph of response, e.g. a saved one, for the graph of the next response
+// to be diffed against in graph-diff mode
+func (m *Monitor) SetBaseGraph(response proto.Message) error {
+	graph, err := parseFullGraph(response, m.opts)
+	if err != nil {
+		return err
+	}
+	m.graph = graph
+	return nil
+}
+
+// oneShot checks if the client processes a single response, i.e. without -monitor_interval or with
+// -input_file, so that there is no later response to wait for before exiting with an error
+func (m *Monitor) oneShot() bool {
+	return (m.opts.MonitorInterval == 0 || m.opts.InputFile != "") && m.opts.ReplayFile == ""
+}
+
+// alert evaluates the alert rules against snapshot and notifies the alerts that fire or resolve
+func (m *Monitor) alert(snapshot *Snapshot) error {
+	if m.rules == nil {
+		return nil
+	}
+	for _, alert := range m.rules.Evaluate(snapshot, m.seen, m.oneShot()) {
+		m.rules.Notify(alert, m.Stdout(), m.Stderr())
+	}
+	for _, c := range snapshot.Clients {
+		m.seen[c.ID] = true
+	}
+
+	if !m.oneShot() {
+		return nil
+	}
+	return m.firingError()
+}
+
+// firingError returns an ExitError if any of the alert rules is firing, e.g. at the end of one-shot
+// mode or replay
+func (m *Monitor) firingError() error {
+	if m.rules == nil {
+		return nil
+	}
+	firing := m.rules.Firing()
+	if len(firing) == 0 {
+		return nil
+	}
+	var names []string
+	for _, rule := range firing {
+		names = append(names, rule.Name)
+	}
+	return &client.ExitError{
+		Code: firing[0].ExitCode,
+		Err:  fmt.Errorf("alert rules violated: %v", strings.Join(names, ", ")),
+	}
+}
+
+// EndError returns an ExitError at the end of the responses of -input_file or of a replay if any
+// of the alert rules is firing, the rollout in converge mode has not converged, or the last
+// response has lint errors or inconsistent clients
+func (m *Monitor) EndError() error {
+	for _, err := range []error{m.firingError(), m.lintError(), m.consistencyError()} {
+		if err != nil {
+			return err
+		}
+	}
+	if m.convergence != nil && !m.convergence.Converged() {
+		c := m.convergence
+		return &client.ExitError{
+			Code: notConvergedExitCode,
+			Err:  fmt.Errorf("%v version %v did not converge to %v%% of the clients (%.1f%%)", c.Xds, c.Version, c.Threshold, c.percent),
+		}
+	}
+	return nil
+}
+
+// Stdout returns the writer of the messages of the client, e.g. alerts, which is the status line
+// of the terminal ui in tui mode, so that they do not mess up its screen
+func (m *Monitor) Stdout() io.Writer {
+	if m.tui != nil {
+		return m.tui
+	}
+	return os.Stdout
+}
+
+// Stderr returns the writer of the errors of the client that do not stop it, e.g. failed requests,
+// which is the status line of the terminal ui in tui mode
+func (m *Monitor) Stderr() io.Writer {
+	if m.tui != nil {
+		return m.tui
+	}
+	return os.Stderr
+}
+
+// Serve starts serving the metrics at /metrics of opts.MetricsAddr and the api at opts.ServeAddr
+// in the background. In tui mode, the terminal ui is started as well.
+func (m *Monitor) Serve() error {
+	if m.tui != nil {
+		if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+			return errors.New("the tui command needs a terminal")
+		}
+		m.tuiRunning = true
+		go func() {
+			m.tuiErr = m.tui.RunTerminal(os.Stdin, os.Stdout, m.closed)
+			close(m.quit)
+		}()
+	}
+	muxes := make(map[string]*http.ServeMux)
+	mux := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+	if m.metrics != nil {
+		mux(m.opts.MetricsAddr).Handle("/metrics", m.metrics)
+	}
+	if m.api != nil {
+		mux(m.opts.ServeAddr).Handle("/", m.api.Handler())
+	}
+	if m.viewer != nil {
+		mux(m.opts.GraphAddr).Handle("/graph", m.viewer)
+	}
+
+	for addr, handler := range muxes {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		server := &http.Server{Handler: handler}
+		m.servers = append(m.servers, server)
+		go server.Serve(listener)
+		fmt.Fprintf(m.Stdout(), "Serving at http://%v\n", listener.Addr())
+		if m.viewer != nil && addr == m.opts.GraphAddr {
+			fmt.Fprintf(m.Stdout(), "Serving the config graph at http://%v/graph\n", listener.Addr())
+		}
+	}
+	return nil
+}
+
+// Done checks if the client should stop, i.e. the rollout in converge mode reached the threshold or
+// timed out, or the terminal ui quit. The returned error is not nil if the rollout timed out.
+func (m *Monitor) Done() (bool, error) {
+	select {
+	case <-m.quit:
+		return true, m.tuiErr
+	default:
+	}
+	if m.convergence == nil {
+		return false, nil
+	}
+	return m.convergence.Done(m.now())
+}
+
+// Sleep waits for d between the requests, it returns early if the terminal ui quits
+func (m *Monitor) Sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-m.quit:
+	}
+}
+
+// Wait waits for the terminal ui to quit in tui mode, or for the client to be interrupted while
+// the html viewer is served, e.g. after the responses of a file are processed. It returns
+// immediately otherwise.
+func (m *Monitor) Wait() error {
+	if m.viewer != nil && m.tui == nil {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		defer signal.Stop(interrupt)
+		<-interrupt
+		return nil
+	}
+	if m.tui == nil {
+		return nil
+	}
+	<-m.quit
+	return m.tuiErr
+}
+
+// Record appends a request sent or a response received to the recording if there is one
+func (m *Monitor) Record(message proto.Message) error {
+	if m.recorder == nil {
+		return nil
+	}
+	return m.recorder.Record(m.now(), message)
+}
+
+// ObserveRequest records a csds request that took d and failed with err if it is not nil
+func (m *Monitor) ObserveRequest(d time.Duration, err error) {
+	if m.metrics != nil {
+		m.metrics.ObserveRequest(d, err)
+	}
+}
+
+// Close releases the resources held by the monitor, e.g. the event log, the recording and the http servers
+func (m *Monitor) Close() error {
+	close(m.closed)
+	if m.tuiRunning {
+		// wait for the terminal to be restored
+		<-m.quit
+	}
+	for _, server := range m.servers {
+		server.Close()
+	}
+	if m.recorder != nil {
+		m.recorder.Close()
+	}
+	if m.eventLog != nil {
+		return m.eventLog.Close()
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package util
+
+import (
+	"encoding/json"
+	"envoy-tools/csds-client/client"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	envoy_admin_v3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Formats of the detailed config output
+const (
+	// OutputJson outputs the csds response as it is
+	OutputJson string = "json"
+	// OutputConfigDump outputs the config of each client as an Envoy admin /config_dump document
+	OutputConfigDump string = "config_dump"
+)
+
+// configDumpOrder is the order of the xds config dumps in the config dump of Envoy admin
+var configDumpOrder = map[string]int{"CDS": 0, "EDS": 1, "LDS": 2, "SRDS": 3, "RDS": 4}
+
+// indexFileName is the name of the file listing the clients saved to -output_dir
+const indexFileName = "index.json"
+
+// unsafeFileNameChars matches the characters that are replaced when a node id is used as a file name
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// clientIndexEntry is an entry of the index file saved to -output_dir
+type clientIndexEntry struct {
+	ID            string            `json:"id"`
+	XdsStreamType string            `json:"xds_stream_type,omitempty"`
+	ConfigStatus  map[string]string `json:"config_status,omitempty"`
+	Path          string            `json:"path"`
+}
+
+// marshalConfig formats message to json and resolves google.protobuf.Any types
+func marshalConfig(message proto.Message) ([]byte, error) {
+	m := protojson.MarshalOptions{Multiline: true, Indent: "  ", Resolver: &TypeResolver{}}
+	return m.Marshal(message)
+}
+
+// ClientConfigs returns the ClientConfig messages of a ClientStatusResponse of any xds api version
+func ClientConfigs(response proto.Message) []proto.Message {
+	return repeatedMessages(response, "config")
+}
+
+// repeatedMessages returns the elements of the repeated message field name of message
+func repeatedMessages(message proto.Message, name string) []proto.Message {
+	m := message.ProtoReflect()
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if fd == nil || !fd.IsList() {
+		return nil
+	}
+	list := m.Get(fd).List()
+	messages := make([]proto.Message, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		messages = append(messages, list.Get(i).Message().Interface())
+	}
+	return messages
+}
+
+// ConvertMessage converts src to dst of another xds api version.
+// The xds messages of different api versions share the same wire format, so the conversion is
+// done through the binary encoding. Fields that only exist in the api version of src are dropped.
+func ConvertMessage(src proto.Message, dst proto.Message) error {
+	b, err := proto.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return proto.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(b, dst)
+}
+
+// ToV3ClientConfig converts a ClientConfig message of any xds api version to the v3 ClientConfig
+func ToV3ClientConfig(config proto.Message) (*csdspb_v3.ClientConfig, error) {
+	if v3Config, ok := config.(*csdspb_v3.ClientConfig); ok {
+		return v3Config, nil
+	}
+	v3Config := &csdspb_v3.ClientConfig{}
+	if err := ConvertMessage(config, v3Config); err != nil {
+		return nil, err
+	}
+	return v3Config, nil
+}
+
+// XdsType returns the short name (e.g. LDS, RDS, CDS, ...) of the xds config carried by perXdsConfig
+func XdsType(perXdsConfig *csdspb_v3.PerXdsConfig) string {
+	switch {
+	case perXdsConfig.GetListenerConfig() != nil:
+		return "LDS"
+	case perXdsConfig.GetRouteConfig() != nil:
+		return "RDS"
+	case perXdsConfig.GetScopedRouteConfig() != nil:
+		return "SRDS"
+	case perXdsConfig.GetClusterConfig() != nil:
+		return "CDS"
+	case perXdsConfig.GetEndpointConfig() != nil:
+		return "EDS"
+	default:
+		return ""
+	}
+}
+
+// XdsVersion returns the version of the xds config carried by perXdsConfig of any xds api version.
+// It is the version_info of the config dump if it is set, otherwise the version of the most recently
+// updated dynamic resource.
+func XdsVersion(perXdsConfig proto.Message) string {
+	v3PerXdsConfig, ok := perXdsConfig.(*csdspb_v3.PerXdsConfig)
+	if !ok {
+		v3PerXdsConfig = &csdspb_v3.PerXdsConfig{}
+		if err := ConvertMessage(perXdsConfig, v3PerXdsConfig); err != nil {
+			return ""
+		}
+	}
+
+	var version string
+	var lastUpdated *timestamppb.Timestamp
+	latest := func(v string, updated *timestamppb.Timestamp) {
+		if v == "" {
+			return
+		}
+		if version == "" || lastUpdated == nil || (updated != nil && !updated.AsTime().Before(lastUpdated.AsTime())) {
+			version, lastUpdated = v, updated
+		}
+	}
+	switch {
+	case v3PerXdsConfig.GetListenerConfig() != nil:
+		dump := v3PerXdsConfig.GetListenerConfig()
+		if dump.GetVersionInfo() != "" {
+			return dump.GetVersionInfo()
+		}
+		for _, listener := range dump.GetDynamicListeners() {
+			latest(listener.GetActiveState().GetVersionInfo(), listener.GetActiveState().GetLastUpdated())
+		}
+	case v3PerXdsConfig.GetClusterConfig() != nil:
+		dump := v3PerXdsConfig.GetClusterConfig()
+		if dump.GetVersionInfo() != "" {
+			return dump.GetVersionInfo()
+		}
+		for _, cluster := range dump.GetDynamicActiveClusters() {
+			latest(cluster.GetVersionInfo(), cluster.GetLastUpdated())
+		}
+	case v3PerXdsConfig.GetRouteConfig() != nil:
+		for _, route := range v3PerXdsConfig.GetRouteConfig().GetDynamicRouteConfigs() {
+			latest(route.GetVersionInfo(), route.GetLastUpdated())
+		}
+	case v3PerXdsConfig.GetScopedRouteConfig() != nil:
+		for _, scopedRoute := range v3PerXdsConfig.GetScopedRouteConfig().GetDynamicScopedRouteConfigs() {
+			latest(scopedRoute.GetVersionInfo(), scopedRoute.GetLastUpdated())
+		}
+	case v3PerXdsConfig.GetEndpointConfig() != nil:
+		for _, endpoint := range v3PerXdsConfig.GetEndpointConfig().GetDynamicEndpointConfigs() {
+			latest(endpoint.GetVersionInfo(), endpoint.GetLastUpdated())
+		}
+	}
+	return version
+}
+
+// StreamType returns the xds stream type the control plane reports in the node metadata of config
+func StreamType(config *csdspb_v3.ClientConfig) string {
+	// control plane is expected to use "XDS_STREAM_TYPE" to communicate
+	// the stream type of the connected client in the response.
+	return config.GetNode().GetMetadata().GetFields()["XDS_STREAM_TYPE"].GetStringValue()
+}
+
+// SanitizeFileName turns a node id into a string that is safe to use as a file name
+func SanitizeFileName(id string) string {
+	name := strings.Trim(unsafeFileNameChars.ReplaceAllString(id, "_"), "_.")
+	if name == "" {
+		return "unknown_client"
+	}
+	return name
+}
+
+// SaveConfigToDir saves the config of each client in response to its own file under opts.OutputDir,
+// along with an index file listing the clients and their config status.
+// If opts.SplitOutput is set, the config of each client is further split into per-xDS-type files
+// under a directory named after the client.
+func SaveConfigToDir(response proto.Message, opts client.ClientOptions) error {
+	if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+		return err
+	}
+
+	var index []clientIndexEntry
+	// a client named after the index file gets a suffix instead of overwriting it
+	usedNames := map[string]bool{strings.TrimSuffix(indexFileName, ".json"): true}
+	suffixes := make(map[string]int)
+	for _, config := range ClientConfigs(response) {
+		v3Config, err := ToV3ClientConfig(config)
+		if err != nil {
+			return err
+		}
+		entry := clientIndexEntry{
+			ID:            v3Config.GetNode().GetId(),
+			XdsStreamType: StreamType(v3Config),
+		}
+		for _, perXdsConfig := range v3Config.GetXdsConfig() {
+			if xds := XdsType(perXdsConfig); xds != "" {
+				if entry.ConfigStatus == nil {
+					entry.ConfigStatus = make(map[string]string)
+				}
+				entry.ConfigStatus[xds] = perXdsConfig.GetStatus().String()
+			}
+		}
+
+		// different node ids may be sanitized to the same name, and the name with a suffix may be
+		// the sanitized id of another client
+		base := SanitizeFileName(entry.ID)
+		name := base
+		for usedNames[name] {
+			suffixes[base]++
+			name = base + "-" + strconv.Itoa(suffixes[base])
+		}
+		usedNames[name] = true
+
+		if opts.SplitOutput {
+			entry.Path = name
+			if err := saveSplitConfig(filepath.Join(opts.OutputDir, name), config, v3Config, opts); err != nil {
+				return err
+			}
+		} else {
+			entry.Path = name + ".json"
+			var out proto.Message = config
+			if opts.OutputFormat == OutputConfigDump {
+				if out, err = ToConfigDump(v3Config); err != nil {
+					return err
+				}
+			}
+			if err := saveMessage(filepath.Join(opts.OutputDir, entry.Path), out); err != nil {
+				return err
+			}
+		}
+		index = append(index, entry)
+	}
+
+	out, err := json.MarshalIndent(map[string]interface{}{"clients": index}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(opts.OutputDir, indexFileName), out, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Config has been saved to %v\n", opts.OutputDir)
+	return nil
+}
+
+// saveSplitConfig saves the node of config to node.json and each of its xds configs to
+// <xds type>.json under dir
+func saveSplitConfig(dir string, config proto.Message, v3Config *csdspb_v3.ClientConfig, opts client.ClientOptions) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// marshal the original messages to keep the fields of the client's api version
+	m := config.ProtoReflect()
+	if fd := m.Descriptor().Fields().ByName("node"); fd != nil && m.Has(fd) {
+		if err := saveMessage(filepath.Join(dir, "node.json"), m.Get(fd).Message().Interface()); err != nil {
+			return err
+		}
+	}
+	for i, perXdsConfig := range repeatedMessages(config, "xds_config") {
+		xds := XdsType(v3Config.GetXdsConfig()[i])
+		if xds == "" {
+			continue
+		}
+		if opts.OutputFormat == OutputConfigDump {
+			perXdsConfig = configDumpOf(v3Config.GetXdsConfig()[i])
+		}
+		if err := saveMessage(filepath.Join(dir, strings.ToLower(xds)+".json"), perXdsConfig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveMessage writes message to the file path in json format
+func saveMessage(path string, message proto.Message) error {
+	out, err := marshalConfig(message)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+// configDumpOf returns the Envoy admin config dump carried by perXdsConfig
+func configDumpOf(perXdsConfig *csdspb_v3.PerXdsConfig) proto.Message {
+	switch {
+	case perXdsConfig.GetListenerConfig() != nil:
+		return perXdsConfig.GetListenerConfig()
+	case perXdsConfig.GetRouteConfig() != nil:
+		return perXdsConfig.GetRouteConfig()
+	case perXdsConfig.GetScopedRouteConfig() != nil:
+		return perXdsConfig.GetScopedRouteConfig()
+	case perXdsConfig.GetClusterConfig() != nil:
+		return perXdsConfig.GetClusterConfig()
+	case perXdsConfig.GetEndpointConfig() != nil:
+		return perXdsConfig.GetEndpointConfig()
+	default:
+		return nil
+	}
+}
+
+// ToConfigDump converts the xds configs of a client to the ConfigDump returned by the /config_dump
+// endpoint of Envoy admin, so that the control plane's view can be fed to config_dump analyzers
+func ToConfigDump(config *csdspb_v3.ClientConfig) (*envoy_admin_v3.ConfigDump, error) {
+	xdsConfigs := make([]*csdspb_v3.PerXdsConfig, 0, len(config.GetXdsConfig()))
+	for _, perXdsConfig := range config.GetXdsConfig() {
+		if XdsType(perXdsConfig) != "" {
+			xdsConfigs = append(xdsConfigs, perXdsConfig)
+		}
+	}
+	sort.SliceStable(xdsConfigs, func(i, j int) bool {
+		return configDumpOrder[XdsType(xdsConfigs[i])] < configDumpOrder[XdsType(xdsConfigs[j])]
+	})
+
+	configDump := &envoy_admin_v3.ConfigDump{}
+	for _, perXdsConfig := range xdsConfigs {
+		dump, err := anypb.New(configDumpOf(perXdsConfig))
+		if err != nil {
+			return nil, err
+		}
+		configDump.Configs = append(configDump.Configs, dump)
+	}
+	return configDump, nil
+}
+
+// printConfigDump outputs the config of each client in response as an Envoy admin config dump to
+// stdout, or to opts.ConfigFile if the response has a single client
+func printConfigDump(response proto.Message, opts client.ClientOptions) error {
+	configs := ClientConfigs(response)
+	if opts.ConfigFile != "" && len(configs) > 1 {
+		return fmt.Errorf("config dumps of %d clients cannot be saved to a single file, use -output_dir instead", len(configs))
+	}
+
+	for _, config := range configs {
+		v3Config, err := ToV3ClientConfig(config)
+		if err != nil {
+			return err
+		}
+		configDump, err := ToConfigDump(v3Config)
+		if err != nil {
+			return err
+		}
+		if opts.ConfigFile != "" {
+			if err := saveMessage(opts.ConfigFile, configDump); err != nil {
+				return err
+			}
+			fmt.Printf("Config has been saved to %v\n", opts.ConfigFile)
+			return nil
+		}
+		out, err := marshalConfig(configDump)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Config Dump of %v:\n", v3Config.GetNode().GetId())
+		fmt.Println(string(out))
+	}
+	return nil
+}
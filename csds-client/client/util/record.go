@@ -0,0 +1,192 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Field numbers of a record in a recording, which is serialized as the message
+//
+//	message Record {
+//	  google.protobuf.Timestamp time = 1;
+//	  // a ClientStatusRequest or a ClientStatusResponse of any xds api version
+//	  google.protobuf.Any message = 2;
+//	}
+//
+// and prefixed with its size in varint, the same way as writeDelimitedTo of the protobuf java api
+const (
+	recordTimeField    protowire.Number = 1
+	recordMessageField protowire.Number = 2
+)
+
+// Record is a request or a response in a recording
+type Record struct {
+	Time    time.Time
+	Message *anypb.Any
+}
+
+// Recorder writes the requests and responses of a session to a recording
+type Recorder struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// OpenRecorder creates the recording at path, an existing file is overwritten
+func OpenRecorder(path string) (*Recorder, error) {
+	filename, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: f, writer: bufio.NewWriter(f)}, nil
+}
+
+// Record appends message sent or received at t to the recording
+func (r *Recorder) Record(t time.Time, message proto.Message) error {
+	any, err := anypb.New(message)
+	if err != nil {
+		return err
+	}
+	timestamp, err := proto.Marshal(timestamppb.New(t))
+	if err != nil {
+		return err
+	}
+	value, err := proto.Marshal(any)
+	if err != nil {
+		return err
+	}
+
+	var record []byte
+	record = protowire.AppendTag(record, recordTimeField, protowire.BytesType)
+	record = protowire.AppendBytes(record, timestamp)
+	record = protowire.AppendTag(record, recordMessageField, protowire.BytesType)
+	record = protowire.AppendBytes(record, value)
+	if _, err := r.writer.Write(protowire.AppendVarint(nil, uint64(len(record)))); err != nil {
+		return err
+	}
+	if _, err := r.writer.Write(record); err != nil {
+		return err
+	}
+	// flush each record so that the recording is complete even if the client is killed
+	return r.writer.Flush()
+}
+
+// Close closes the file of the recording
+func (r *Recorder) Close() error {
+	return r.file.Close()
+}
+
+// ReadRecording loads the records of the recording at path
+func ReadRecording(path string) ([]Record, error) {
+	filename, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for len(data) > 0 {
+		size, n := protowire.ConsumeVarint(data)
+		if n < 0 || uint64(len(data)-n) < size {
+			return nil, fmt.Errorf("truncated record %d in %v", len(records), path)
+		}
+		record, err := parseRecord(data[n : n+int(size)])
+		if err != nil {
+			return nil, fmt.Errorf("invalid record %d in %v: %v", len(records), path, err)
+		}
+		records = append(records, record)
+		data = data[n+int(size):]
+	}
+	return records, nil
+}
+
+// parseRecord parses the serialized Record message in b
+func parseRecord(b []byte) (Record, error) {
+	var record Record
+	for len(b) > 0 {
+		number, wireType, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return record, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if wireType != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(number, wireType, b); n < 0 {
+				return record, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return record, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch number {
+		case recordTimeField:
+			timestamp := &timestamppb.Timestamp{}
+			if err := proto.Unmarshal(value, timestamp); err != nil {
+				return record, err
+			}
+			record.Time = timestamp.AsTime()
+		case recordMessageField:
+			record.Message = &anypb.Any{}
+			if err := proto.Unmarshal(value, record.Message); err != nil {
+				return record, err
+			}
+		}
+	}
+	if record.Message == nil {
+		return record, errors.New("missing message")
+	}
+	return record, nil
+}
+
+// IsResponse checks if the message of the record is a ClientStatusResponse of any xds api version
+func (r Record) IsResponse() bool {
+	return strings.HasSuffix(r.Message.GetTypeUrl(), ".ClientStatusResponse")
+}
+
+// UnmarshalResponse parses the response in the record to response, which is a ClientStatusResponse
+// of any xds api version regardless of the version it was recorded with
+func (r Record) UnmarshalResponse(response proto.Message) error {
+	if !r.IsResponse() {
+		return fmt.Errorf("%v is not a csds response", r.Message.GetTypeUrl())
+	}
+	return proto.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(r.Message.GetValue(), response)
+}
+
+// Replay calls handle with each response in records, waiting between them as long as they were
+// received apart, divided by speed. The responses are handled without waiting if speed is 0.
+func Replay(records []Record, speed float64, handle func(Record) error) error {
+	var previous time.Time
+	for _, record := range records {
+		if !record.IsResponse() {
+			continue
+		}
+		if speed > 0 && !previous.IsZero() && record.Time.After(previous) {
+			time.Sleep(time.Duration(float64(record.Time.Sub(previous)) / speed))
+		}
+		previous = record.Time
+		if err := handle(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package util
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	envoy_admin_v3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	// the runtime layers of RTDS are only referenced by type url, so their type is registered here
+	// for the generic xds configs that carry them to be printed out
+	_ "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// States of the resources in a Snapshot
+const (
+	// ResourceStatic is the state of a resource that is statically configured
+	ResourceStatic string = "STATIC"
+	// ResourceActive is the state of a dynamic resource that is in use
+	ResourceActive string = "ACTIVE"
+	// ResourceWarming is the state of a dynamic resource that is warming up before being in use
+	ResourceWarming string = "WARMING"
+	// ResourceDraining is the state of a dynamic listener that is draining connections
+	ResourceDraining string = "DRAINING"
+	// ResourceNacked is the state of a dynamic resource whose last update was rejected by the client
+	ResourceNacked string = "NACKED"
+)
+
+// genericXdsTypes maps the types of the resources in the generic xds configs of a csds response to
+// their xds types
+var genericXdsTypes = map[string]string{
+	"envoy.config.listener.v3.Listener":                "LDS",
+	"envoy.config.route.v3.RouteConfiguration":         "RDS",
+	"envoy.config.route.v3.ScopedRouteConfiguration":   "SRDS",
+	"envoy.config.route.v3.VirtualHost":                "VHDS",
+	"envoy.config.cluster.v3.Cluster":                  "CDS",
+	"envoy.config.endpoint.v3.ClusterLoadAssignment":   "EDS",
+	"envoy.config.core.v3.TypedExtensionConfig":        "ECDS",
+	"envoy.extensions.transport_sockets.tls.v3.Secret": "SDS",
+	"envoy.service.runtime.v3.Runtime":                 "RTDS",
+}
+
+// configStatusOrder orders the config statuses of the generic xds configs of an xds type, so that
+// the xds config takes the most notable one
+var configStatusOrder = map[string]int{"ERROR": 4, "STALE": 3, "NOT_SENT": 2, "SYNCED": 1}
+
+// Snapshot is the version independent view of the clients in a csds response at a point of time
+type Snapshot struct {
+	Time    time.Time
+	Clients []*ClientSnapshot
+}
+
+// ClientSnapshot is the view of a client in a Snapshot
+type ClientSnapshot struct {
+	// ID is the node id of the client, followed by #<index> of the client in the response if it is
+	// empty or an earlier client has it, so that the clients of a snapshot have unique ids
+	ID         string
+	StreamType string
+	Metadata   map[string]interface{}
+	// Xds holds the xds configs of the client in the order of the response
+	Xds []*XdsSnapshot
+}
+
+// XdsSnapshot is the view of an xds config of a client in a Snapshot
+type XdsSnapshot struct {
+	// Type is the short name of the xds, e.g. LDS, RDS, CDS, ...
+	Type    string
+	Status  string
+	Version string
+	// Resources holds the resources of the xds config in the order of the config dump
+	Resources []*ResourceSnapshot
+}
+
+// ResourceSnapshot is the view of an xds resource in a Snapshot
+type ResourceSnapshot struct {
+	Name    string
+	Version string
+	State   string
+	// Error holds the details of the rejected update if State is ResourceNacked
+	Error string
+	// Group is the name of the set the resource is dumped in, e.g. the scoped routes of a scoped
+	// route configuration
+	Group       string
+	LastUpdated time.Time
+	// Config is the resource itself, e.g. a Listener or a Cluster
+	Config *anypb.Any
+}
+
+// Client returns the snapshot of the client with node id, or nil if it is not in the snapshot
+func (s *Snapshot) Client(id string) *ClientSnapshot {
+	for _, c := range s.Clients {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// XdsConfig returns the snapshot of the xds config of type xds, or nil if the client does not have it
+func (c *ClientSnapshot) XdsConfig(xds string) *XdsSnapshot {
+	for _, x := range c.Xds {
+		if x.Type == xds {
+			return x
+		}
+	}
+	return nil
+}
+
+// Resource returns the snapshot of the resource with name, or nil if it is not in the xds config
+func (x *XdsSnapshot) Resource(name string) *ResourceSnapshot {
+	for _, r := range x.Resources {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// NewSnapshot takes a snapshot of response, which is a ClientStatusResponse of any xds api version, at t
+func NewSnapshot(response proto.Message, t time.Time) (*Snapshot, error) {
+	snapshot := &Snapshot{Time: t}
+	ids := make(map[string]bool)
+	for i, config := range ClientConfigs(response) {
+		v3Config, err := ToV3ClientConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		c := &ClientSnapshot{
+			ID:         clientID(v3Config.GetNode().GetId(), i, ids),
+			StreamType: StreamType(v3Config),
+			Metadata:   v3Config.GetNode().GetMetadata().AsMap(),
+		}
+		for _, perXdsConfig := range v3Config.GetXdsConfig() {
+			xds := XdsType(perXdsConfig)
+			if xds == "" {
+				continue
+			}
+			c.Xds = append(c.Xds, &XdsSnapshot{
+				Type:      xds,
+				Status:    perXdsConfig.GetStatus().String(),
+				Version:   XdsVersion(perXdsConfig),
+				Resources: xdsResources(perXdsConfig),
+			})
+		}
+		addGenericXdsConfigs(c, v3Config.GetGenericXdsConfigs())
+		snapshot.Clients = append(snapshot.Clients, c)
+	}
+	return snapshot, nil
+}
+
+// clientID returns the unique id of the i-th client of a response with node id, which is the node
+// id if it is not empty and not in ids, the ids of the earlier clients, and adds it to ids
+func clientID(node string, i int, ids map[string]bool) string {
+	id := node
+	for n := i + 1; id == "" || ids[id]; n++ {
+		id = node + "#" + strconv.Itoa(n)
+	}
+	ids[id] = true
+	return id
+}
+
+// unpackAny parses the resource in a of any xds api version to m of the v3 api version
+func unpackAny(a *anypb.Any, m proto.Message) error {
+	return proto.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(a.GetValue(), m)
+}
+
+// asTime converts t to time.Time, which is zero if t is not set
+func asTime(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.AsTime()
+}
+
+// xdsResources lists the resources in the config dump of perXdsConfig
+func xdsResources(perXdsConfig *csdspb_v3.PerXdsConfig) []*ResourceSnapshot {
+	var resources []*ResourceSnapshot
+	add := func(config *anypb.Any, name string, version string, state string, lastUpdated *timestamppb.Timestamp) *ResourceSnapshot {
+		if config == nil {
+			return nil
+		}
+		resource := &ResourceSnapshot{
+			Name:        name,
+			Version:     version,
+			State:       state,
+			LastUpdated: asTime(lastUpdated),
+			Config:      config,
+		}
+		resources = append(resources, resource)
+		return resource
+	}
+
+	switch {
+	case perXdsConfig.GetListenerConfig() != nil:
+		dump := perXdsConfig.GetListenerConfig()
+		for _, l := range dump.GetStaticListeners() {
+			add(l.GetListener(), listenerName(l.GetListener()), "", ResourceStatic, l.GetLastUpdated())
+		}
+		for _, l := range dump.GetDynamicListeners() {
+			resources = append(resources, dynamicListener(l))
+		}
+	case perXdsConfig.GetRouteConfig() != nil:
+		dump := perXdsConfig.GetRouteConfig()
+		for _, r := range dump.GetStaticRouteConfigs() {
+			add(r.GetRouteConfig(), routeConfigName(r.GetRouteConfig()), "", ResourceStatic, r.GetLastUpdated())
+		}
+		for _, r := range dump.GetDynamicRouteConfigs() {
+			add(r.GetRouteConfig(), routeConfigName(r.GetRouteConfig()), r.GetVersionInfo(), ResourceActive, r.GetLastUpdated())
+		}
+	case perXdsConfig.GetScopedRouteConfig() != nil:
+		dump := perXdsConfig.GetScopedRouteConfig()
+		for _, s := range dump.GetInlineScopedRouteConfigs() {
+			for _, config := range s.GetScopedRouteConfigs() {
+				if resource := add(config, scopedRouteConfigName(config), "", ResourceStatic, s.GetLastUpdated()); resource != nil {
+					resource.Group = s.GetName()
+				}
+			}
+		}
+		for _, s := range dump.GetDynamicScopedRouteConfigs() {
+			for _, config := range s.GetScopedRouteConfigs() {
+				if resource := add(config, scopedRouteConfigName(config), s.GetVersionInfo(), ResourceActive, s.GetLastUpdated()); resource != nil {
+					resource.Group = s.GetName()
+				}
+			}
+		}
+	case perXdsConfig.GetClusterConfig() != nil:
+		dump := perXdsConfig.GetClusterConfig()
+		for _, c := range dump.GetStaticClusters() {
+			add(c.GetCluster(), clusterName(c.GetCluster()), "", ResourceStatic, c.GetLastUpdated())
+		}
+		for _, c := range dump.GetDynamicActiveClusters() {
+			add(c.GetCluster(), clusterName(c.GetCluster()), c.GetVersionInfo(), ResourceActive, c.GetLastUpdated())
+		}
+		for _, c := range dump.GetDynamicWarmingClusters() {
+			add(c.GetCluster(), clusterName(c.GetCluster()), c.GetVersionInfo(), ResourceWarming, c.GetLastUpdated())
+		}
+	case perXdsConfig.GetEndpointConfig() != nil:
+		dump := perXdsConfig.GetEndpointConfig()
+		for _, e := range dump.GetStaticEndpointConfigs() {
+			add(e.GetEndpointConfig(), endpointClusterName(e.GetEndpointConfig()), "", ResourceStatic, e.GetLastUpdated())
+		}
+		for _, e := range dump.GetDynamicEndpointConfigs() {
+			add(e.GetEndpointConfig(), endpointClusterName(e.GetEndpointConfig()), e.GetVersionInfo(), ResourceActive, e.GetLastUpdated())
+		}
+	}
+	return resources
+}
+
+// addGenericXdsConfigs adds the resources of genericXdsConfigs to the xds configs of their types in
+// c. The status of an xds config is the most notable config status of its resources, and its
+// version is the one of the most recently updated resource.
+func addGenericXdsConfigs(c *ClientSnapshot, genericXdsConfigs []*csdspb_v3.ClientConfig_GenericXdsConfig) {
+	lastUpdated := make(map[*XdsSnapshot]time.Time)
+	for _, config := range genericXdsConfigs {
+		typeURL := config.GetTypeUrl()
+		xds := genericXdsTypes[typeURL[strings.LastIndex(typeURL, "/")+1:]]
+		if xds == "" {
+			continue
+		}
+		x := c.XdsConfig(xds)
+		if x == nil {
+			x = &XdsSnapshot{Type: xds}
+			c.Xds = append(c.Xds, x)
+		}
+		if status := config.GetConfigStatus().String(); x.Status == "" || configStatusOrder[status] > configStatusOrder[x.Status] {
+			x.Status = status
+		}
+		resource := genericResource(config)
+		if updated, ok := lastUpdated[x]; resource.Version != "" && (!ok || !resource.LastUpdated.Before(updated)) {
+			x.Version = resource.Version
+			lastUpdated[x] = resource.LastUpdated
+		}
+		x.Resources = append(x.Resources, resource)
+	}
+}
+
+// genericResource takes the snapshot of the resource of a generic xds config, whose state is
+// STATIC for a static resource, NACKED if the client rejected its last update, ACTIVE if the
+// client acked it, and the client status otherwise, e.g. REQUESTED or DOES_NOT_EXIST
+func genericResource(config *csdspb_v3.ClientConfig_GenericXdsConfig) *ResourceSnapshot {
+	resource := &ResourceSnapshot{
+		Name:        config.GetName(),
+		Version:     config.GetVersionInfo(),
+		LastUpdated: asTime(config.GetLastUpdated()),
+		Config:      config.GetXdsConfig(),
+	}
+	switch {
+	case config.GetIsStaticResource():
+		resource.State = ResourceStatic
+	case config.GetErrorState() != nil || config.GetClientStatus() == envoy_admin_v3.ClientResourceStatus_NACKED:
+		resource.State = ResourceNacked
+		resource.Error = config.GetErrorState().GetDetails()
+		if resource.Config == nil {
+			resource.Config = config.GetErrorState().GetFailedConfiguration()
+		}
+	case config.GetClientStatus() == envoy_admin_v3.ClientResourceStatus_ACKED:
+		resource.State = ResourceActive
+	default:
+		resource.State = config.GetClientStatus().String()
+	}
+	return resource
+}
+
+// dynamicListener takes the snapshot of a dynamic listener, whose state is the most notable one
+// among its states: nacked, then warming, then draining, then active. The config, version and update
+// time are the ones of the listener in use, i.e. the active one if there is one, so that a pending
+// listener only shows up in the state.
+func dynamicListener(l *envoy_admin_v3.ListenersConfigDump_DynamicListener) *ResourceSnapshot {
+	resource := &ResourceSnapshot{Name: l.GetName()}
+	for _, s := range []struct {
+		state        string
+		listenerDump *envoy_admin_v3.ListenersConfigDump_DynamicListenerState
+	}{
+		{ResourceActive, l.GetActiveState()},
+		{ResourceDraining, l.GetDrainingState()},
+		{ResourceWarming, l.GetWarmingState()},
+	} {
+		if s.listenerDump == nil {
+			continue
+		}
+		resource.State = s.state
+		if resource.Config == nil {
+			resource.Version = s.listenerDump.GetVersionInfo()
+			resource.LastUpdated = asTime(s.listenerDump.GetLastUpdated())
+			resource.Config = s.listenerDump.GetListener()
+		}
+	}
+	if l.GetErrorState() != nil {
+		resource.State = ResourceNacked
+		resource.Error = l.GetErrorState().GetDetails()
+		if resource.Config == nil {
+			resource.Config = l.GetErrorState().GetFailedConfiguration()
+		}
+	}
+	return resource
+}
+
+// listenerName returns the name of the listener in config
+func listenerName(config *anypb.Any) string {
+	listener := &envoy_config_listener_v3.Listener{}
+	_ = unpackAny(config, listener)
+	return listener.GetName()
+}
+
+// routeConfigName returns the name of the route configuration in config
+func routeConfigName(config *anypb.Any) string {
+	routeConfig := &envoy_config_route_v3.RouteConfiguration{}
+	_ = unpackAny(config, routeConfig)
+	return routeConfig.GetName()
+}
+
+// scopedRouteConfigName returns the name of the scoped route configuration in config
+func scopedRouteConfigName(config *anypb.Any) string {
+	scopedRouteConfig := &envoy_config_route_v3.ScopedRouteConfiguration{}
+	_ = unpackAny(config, scopedRouteConfig)
+	return scopedRouteConfig.GetName()
+}
+
+// clusterName returns the name of the cluster in config
+func clusterName(config *anypb.Any) string {
+	cluster := &envoy_config_cluster_v3.Cluster{}
+	_ = unpackAny(config, cluster)
+	return cluster.GetName()
+}
+
+// endpointClusterName returns the cluster name of the endpoints in config
+func endpointClusterName(config *anypb.Any) string {
+	clusterLoadAssignment := &envoy_config_endpoint_v3.ClusterLoadAssignment{}
+	_ = unpackAny(config, clusterLoadAssignment)
+	return clusterLoadAssignment.GetClusterName()
+}
@@ -0,0 +1,198 @@
+package util
+
+import (
+	"encoding/json"
+	"envoy-tools/csds-client/client"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ANSI escape codes to color the output in terminal
+const (
+	colorReset  string = "\x1b[0m"
+	colorRed    string = "\x1b[31m"
+	colorGreen  string = "\x1b[32m"
+	colorYellow string = "\x1b[33m"
+)
+
+// statusColors maps config status to the color it is shown in
+var statusColors = map[string]string{
+	"SYNCED": colorGreen,
+	"STALE":  colorYellow,
+	"ERROR":  colorRed,
+	"NACKED": colorRed,
+}
+
+// minTruncatedWidth is the minimum width a column is truncated to in order to fit in the terminal
+const minTruncatedWidth = 12
+
+// columnSeparator separates the columns of the client status table
+const columnSeparator = "   "
+
+// ClientStatus is the config status of a client shown in the client status table
+type ClientStatus struct {
+	ID         string
+	StreamType string
+	Metadata   map[string]interface{}
+	// XdsStatus is nil if the response does not carry any xds config of the client
+	XdsStatus []XdsStatus
+}
+
+// XdsStatus is the config status of an xds config of a client
+type XdsStatus struct {
+	Xds     string
+	Status  string
+	Version string
+}
+
+// ColorEnabled checks if the output to f should be colored, which is the case when f is a terminal
+// and the NO_COLOR environment variable is not set
+func ColorEnabled(f *os.File) bool {
+	return isTerminal(f) && os.Getenv("NO_COLOR") == ""
+}
+
+// isTerminal checks if f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// ColorStatus wraps status in the color of it if color is enabled
+func ColorStatus(status string, color bool) string {
+	if c, ok := statusColors[status]; ok && color {
+		return c + status + colorReset
+	}
+	return status
+}
+
+// truncate shortens str to width with an ellipsis if it is longer than width
+func truncate(str string, width int) string {
+	if utf8.RuneCountInString(str) <= width {
+		return str
+	}
+	if width <= 1 {
+		return "…"
+	}
+	return string([]rune(str)[:width-1]) + "…"
+}
+
+// formatMetadata formats metadata of a node as sorted key=value pairs
+func formatMetadata(metadata map[string]interface{}) string {
+	var pairs []string
+	for key, value := range metadata {
+		// the stream type has its own column
+		if key == "XDS_STREAM_TYPE" {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			b, _ := json.Marshal(value)
+			str = string(b)
+		}
+		pairs = append(pairs, key+"="+str)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// PrintClientTable prints out the config status of clients as a table. The columns adapt to the
+// longest value and, if stdout is a terminal, long values are truncated to fit the terminal width.
+// Versions and metadata are shown in extra columns if opts.Wide is set.
+func PrintClientTable(clients []ClientStatus, opts client.ClientOptions) {
+	header := []string{"Client ID", "xDS stream type", "Config Status"}
+	if opts.Wide {
+		header = append(header, "Version", "Metadata")
+	}
+
+	// each row holds the plain text of the cells and the status to color
+	type row struct {
+		cells  []string
+		status string
+	}
+	var rows []row
+	for _, c := range clients {
+		metadata := ""
+		if opts.Wide {
+			metadata = formatMetadata(c.Metadata)
+		}
+		if c.XdsStatus == nil {
+			rows = append(rows, row{cells: []string{c.ID, c.StreamType, "N/A", "", metadata}})
+			continue
+		}
+		if len(c.XdsStatus) == 0 {
+			rows = append(rows, row{cells: []string{c.ID, c.StreamType, "", "", metadata}})
+		}
+		for i, status := range c.XdsStatus {
+			cells := []string{"", "", status.Xds + "   " + status.Status, status.Version, ""}
+			if i == 0 {
+				cells[0], cells[1], cells[4] = c.ID, c.StreamType, metadata
+			}
+			rows = append(rows, row{cells: cells, status: status.Status})
+		}
+	}
+
+	widths := make([]int, len(header))
+	for i, title := range header {
+		widths[i] = utf8.RuneCountInString(title)
+		for _, r := range rows {
+			if w := utf8.RuneCountInString(r.cells[i]); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	fitTerminal(widths, terminalWidth(os.Stdout))
+
+	color := ColorEnabled(os.Stdout)
+	printRow := func(cells []string, status string) {
+		var line strings.Builder
+		for i, width := range widths {
+			cell := truncate(cells[i], width)
+			padding := strings.Repeat(" ", width-utf8.RuneCountInString(cell))
+			if status != "" && i == 2 {
+				cell = strings.TrimSuffix(cell, status) + ColorStatus(status, color)
+			}
+			line.WriteString(cell + padding + columnSeparator)
+		}
+		fmt.Println(strings.TrimRight(line.String(), " "))
+	}
+	printRow(header, "")
+	for _, r := range rows {
+		printRow(r.cells, r.status)
+	}
+}
+
+// fitTerminal shrinks the widths of the client id and metadata columns so that the table fits in
+// a terminal of the given width, a width of 0 means the terminal width is unknown
+func fitTerminal(widths []int, terminal int) {
+	if terminal <= 0 {
+		return
+	}
+	// metadata is the first to shrink, then client id
+	shrinkable := []int{0}
+	if len(widths) > 4 {
+		shrinkable = []int{4, 0}
+	}
+	total := len(columnSeparator) * (len(widths) - 1)
+	for _, width := range widths {
+		total += width
+	}
+	for _, i := range shrinkable {
+		if total <= terminal {
+			return
+		}
+		shrunk := widths[i] - (total - terminal)
+		if shrunk < minTruncatedWidth {
+			shrunk = minTruncatedWidth
+		}
+		if shrunk < widths[i] {
+			total -= widths[i] - shrunk
+			widths[i] = shrunk
+		}
+	}
+}
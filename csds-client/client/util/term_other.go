@@ -0,0 +1,18 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package util
+
+import (
+	"os"
+)
+
+// terminalWidth returns the number of columns of the terminal f is attached to, or 0 if it is unknown
+func terminalWidth(f *os.File) int {
+	return 0
+}
+
+// terminalSize returns the number of columns and rows of the terminal f is attached to, or 0 if they are unknown
+func terminalSize(f *os.File) (int, int) {
+	return 0, 0
+}
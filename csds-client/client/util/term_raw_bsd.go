@@ -0,0 +1,14 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package util
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// ioctl requests to get and set the mode of a terminal
+const (
+	ioctlReadTermios  = unix.TIOCGETA
+	ioctlWriteTermios = unix.TIOCSETA
+)
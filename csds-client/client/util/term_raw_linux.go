@@ -0,0 +1,11 @@
+package util
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// ioctl requests to get and set the mode of a terminal
+const (
+	ioctlReadTermios  = unix.TCGETS
+	ioctlWriteTermios = unix.TCSETS
+)
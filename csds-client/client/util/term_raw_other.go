@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package util
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRaw puts the terminal f is attached to into raw mode, which is not supported on this platform
+func makeRaw(f *os.File) (func() error, error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
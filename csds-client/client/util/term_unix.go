@@ -0,0 +1,25 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package util
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// terminalWidth returns the number of columns of the terminal f is attached to, or 0 if it is unknown
+func terminalWidth(f *os.File) int {
+	width, _ := terminalSize(f)
+	return width
+}
+
+// terminalSize returns the number of columns and rows of the terminal f is attached to, or 0 if they are unknown
+func terminalSize(f *os.File) (int, int) {
+	winsize, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0
+	}
+	return int(winsize.Col), int(winsize.Row)
+}
@@ -0,0 +1,503 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Views of the terminal ui, each one drills down from the previous one
+const (
+	viewClients = iota
+	viewXds
+	viewResources
+	viewConfig
+)
+
+// Keys of the terminal ui that are not single characters
+const (
+	KeyUp        = "up"
+	KeyDown      = "down"
+	KeyLeft      = "left"
+	KeyRight     = "right"
+	KeyEnter     = "enter"
+	KeyBackspace = "backspace"
+	KeyEsc       = "esc"
+	KeyPageUp    = "pgup"
+	KeyPageDown  = "pgdown"
+	// KeyInterrupt is ctrl-c, which quits from any mode, since signals are not generated in raw mode
+	KeyInterrupt = "interrupt"
+)
+
+// ANSI escape codes to highlight the selected row and dim the help
+const (
+	colorReverse string = "\x1b[7m"
+	colorDim     string = "\x1b[2m"
+)
+
+// tuiHelp lists the keys of the terminal ui
+const tuiHelp = "↑/↓ move  enter drill down  ← back  / filter  n nacks  e export  q quit"
+
+// TUI is the state of the interactive terminal ui that browses the clients and their configs. It
+// is driven by the snapshots of the monitor loop and the keys pressed, and rendered as lines.
+type TUI struct {
+	mu       sync.Mutex
+	snapshot *Snapshot
+
+	view int
+	// client, xds and resource are the selected items the current view drills down from
+	client   string
+	xds      string
+	resource string
+	// cursor and scroll hold the selected row and the first shown row of each view
+	cursor [viewConfig + 1]int
+	scroll [viewConfig + 1]int
+
+	filter    string
+	filtering bool
+	showNacks bool
+	message   string
+	// exportDir is the directory resources are exported to
+	exportDir string
+	color     bool
+
+	// updated is notified when the ui needs to be rendered again
+	updated chan struct{}
+}
+
+// tuiSegment is a part of a rendered line with its color
+type tuiSegment struct {
+	text  string
+	color string
+}
+
+// NewTUI creates a terminal ui that exports resources to exportDir, colored if color is set
+func NewTUI(exportDir string, color bool) *TUI {
+	return &TUI{exportDir: exportDir, color: color, updated: make(chan struct{}, 1)}
+}
+
+// notify requests the ui to be rendered again
+func (t *TUI) notify() {
+	select {
+	case t.updated <- struct{}{}:
+	default:
+	}
+}
+
+// Updated returns the channel that is notified when the ui needs to be rendered again
+func (t *TUI) Updated() <-chan struct{} {
+	return t.updated
+}
+
+// Update refreshes the ui with the latest snapshot
+func (t *TUI) Update(snapshot *Snapshot) {
+	t.mu.Lock()
+	t.snapshot = snapshot
+	t.mu.Unlock()
+	t.notify()
+}
+
+// Write implements io.Writer by showing the last line written in the status line of the ui, so
+// that the messages of the client, e.g. failed requests and alerts, do not mess up the screen
+func (t *TUI) Write(p []byte) (int, error) {
+	lines := strings.Split(strings.TrimSpace(string(p)), "\n")
+	if line := strings.TrimSpace(lines[len(lines)-1]); line != "" {
+		t.mu.Lock()
+		t.message = line
+		t.mu.Unlock()
+		t.notify()
+	}
+	return len(p), nil
+}
+
+// tuiRow is a row of a list view
+type tuiRow struct {
+	// name identifies the item of the row when drilling down
+	name     string
+	segments []tuiSegment
+}
+
+// selectedClient returns the client the current view drills down from
+func (t *TUI) selectedClient() *ClientSnapshot {
+	if t.snapshot == nil {
+		return nil
+	}
+	return t.snapshot.Client(t.client)
+}
+
+// selectedXds returns the xds config the current view drills down from
+func (t *TUI) selectedXds() *XdsSnapshot {
+	if c := t.selectedClient(); c != nil {
+		return c.XdsConfig(t.xds)
+	}
+	return nil
+}
+
+// selectedResource returns the resource shown in the config view
+func (t *TUI) selectedResource() *ResourceSnapshot {
+	if x := t.selectedXds(); x != nil {
+		return x.Resource(t.resource)
+	}
+	return nil
+}
+
+// statusSegment is the segment of a status, colored as in the client status table
+func statusSegment(status string) tuiSegment {
+	return tuiSegment{text: status, color: statusColors[status]}
+}
+
+// matchFilter checks if name contains the filter, case insensitively
+func (t *TUI) matchFilter(name string) bool {
+	return strings.Contains(strings.ToLower(name), strings.ToLower(t.filter))
+}
+
+// rows lists the rows of the current list view that match the filter
+func (t *TUI) rows() []tuiRow {
+	var rows []tuiRow
+	switch t.view {
+	case viewClients:
+		if t.snapshot == nil {
+			return nil
+		}
+		for _, c := range t.snapshot.Clients {
+			if !t.matchFilter(c.ID) && !t.matchFilter(c.StreamType) {
+				continue
+			}
+			segments := []tuiSegment{{text: c.ID}, {text: "  " + c.StreamType}}
+			for _, x := range c.Xds {
+				segments = append(segments, tuiSegment{text: "  " + x.Type + " "}, statusSegment(x.Status))
+			}
+			rows = append(rows, tuiRow{name: c.ID, segments: segments})
+		}
+	case viewXds:
+		if c := t.selectedClient(); c != nil {
+			for _, x := range c.Xds {
+				if !t.matchFilter(x.Type) {
+					continue
+				}
+				rows = append(rows, tuiRow{name: x.Type, segments: []tuiSegment{
+					{text: fmt.Sprintf("%-5v", x.Type)},
+					statusSegment(x.Status),
+					{text: fmt.Sprintf("  version %v  %d resources", x.Version, len(x.Resources))},
+				}})
+			}
+		}
+	case viewResources:
+		if x := t.selectedXds(); x != nil {
+			for _, r := range x.Resources {
+				if !t.matchFilter(r.Name) {
+					continue
+				}
+				state := tuiSegment{text: r.State}
+				if r.State == ResourceNacked {
+					state.color = colorRed
+				}
+				segments := []tuiSegment{{text: r.Name + "  "}, state}
+				if r.Version != "" {
+					segments = append(segments, tuiSegment{text: "  version " + r.Version})
+				}
+				rows = append(rows, tuiRow{name: r.Name, segments: segments})
+			}
+		}
+	}
+	return rows
+}
+
+// configLines returns the lines of the pretty-printed config of the selected resource
+func (t *TUI) configLines() []string {
+	r := t.selectedResource()
+	if r == nil {
+		return []string{"The resource is gone."}
+	}
+	var lines []string
+	if r.Error != "" {
+		lines = append(lines, "Error: "+r.Error, "")
+	}
+	if r.Config == nil {
+		return append(lines, "No config.")
+	}
+	config, err := marshalConfig(r.Config)
+	if err != nil {
+		return append(lines, "Failed to parse config: "+err.Error())
+	}
+	return append(lines, strings.Split(string(config), "\n")...)
+}
+
+// nacks lists the nacked xds configs and resources of all the clients
+func (t *TUI) nacks() []string {
+	var nacks []string
+	if t.snapshot == nil {
+		return nil
+	}
+	for _, c := range t.snapshot.Clients {
+		for _, x := range c.Xds {
+			if x.Status == "ERROR" || x.Status == "NACKED" {
+				nacks = append(nacks, fmt.Sprintf("%v %v %v (version %v)", c.ID, x.Type, x.Status, x.Version))
+			}
+			for _, r := range x.Resources {
+				if r.State == ResourceNacked {
+					nacks = append(nacks, fmt.Sprintf("%v %v %v: %v", c.ID, x.Type, r.Name, r.Error))
+				}
+			}
+		}
+	}
+	return nacks
+}
+
+// length returns the number of rows of the current view
+func (t *TUI) length() int {
+	if t.view == viewConfig {
+		return len(t.configLines())
+	}
+	return len(t.rows())
+}
+
+// HandleKey updates the ui with the key pressed, and returns true if the ui should quit
+func (t *TUI) HandleKey(key string) bool {
+	t.mu.Lock()
+	defer func() {
+		t.mu.Unlock()
+		t.notify()
+	}()
+
+	if key == KeyInterrupt {
+		return true
+	}
+	if t.filtering {
+		switch key {
+		case KeyEnter:
+			t.filtering = false
+		case KeyEsc:
+			t.filtering = false
+			t.filter = ""
+		case KeyBackspace:
+			if t.filter != "" {
+				_, size := utf8.DecodeLastRuneInString(t.filter)
+				t.filter = t.filter[:len(t.filter)-size]
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				t.filter += key
+			}
+		}
+		t.cursor[t.view] = 0
+		return false
+	}
+
+	t.message = ""
+	switch key {
+	case "q":
+		return true
+	case KeyUp, "k":
+		t.cursor[t.view]--
+	case KeyDown, "j":
+		t.cursor[t.view]++
+	case KeyPageUp:
+		t.cursor[t.view] -= 10
+	case KeyPageDown:
+		t.cursor[t.view] += 10
+	case KeyEnter, KeyRight, "l":
+		t.drillDown()
+	case KeyLeft, KeyBackspace, KeyEsc, "h":
+		if t.view > viewClients {
+			t.view--
+			t.filter = ""
+		}
+	case "/":
+		if t.view != viewConfig {
+			t.filtering = true
+			t.filter = ""
+		}
+	case "n":
+		t.showNacks = !t.showNacks
+	case "e":
+		t.export()
+	}
+	t.clampCursor()
+	return false
+}
+
+// clampCursor keeps the cursor of the current view within its rows
+func (t *TUI) clampCursor() {
+	if n := t.length(); t.cursor[t.view] >= n {
+		t.cursor[t.view] = n - 1
+	}
+	if t.cursor[t.view] < 0 {
+		t.cursor[t.view] = 0
+	}
+}
+
+// drillDown shows the item under the cursor in the next view
+func (t *TUI) drillDown() {
+	if t.view == viewConfig {
+		return
+	}
+	rows := t.rows()
+	if t.cursor[t.view] >= len(rows) {
+		return
+	}
+	name := rows[t.cursor[t.view]].name
+	switch t.view {
+	case viewClients:
+		t.client = name
+	case viewXds:
+		t.xds = name
+	case viewResources:
+		t.resource = name
+	}
+	t.view++
+	t.filter = ""
+	t.cursor[t.view] = 0
+	t.scroll[t.view] = 0
+}
+
+// export saves the config of the selected resource to a file in the export directory
+func (t *TUI) export() {
+	resource := t.resource
+	if t.view == viewResources {
+		rows := t.rows()
+		if t.cursor[t.view] >= len(rows) {
+			return
+		}
+		resource = rows[t.cursor[t.view]].name
+	} else if t.view != viewConfig {
+		t.message = "Select a resource to export"
+		return
+	}
+	x := t.selectedXds()
+	if x == nil || x.Resource(resource) == nil || x.Resource(resource).Config == nil {
+		t.message = "Nothing to export"
+		return
+	}
+	config, err := marshalConfig(x.Resource(resource).Config)
+	if err != nil {
+		t.message = "Failed to export: " + err.Error()
+		return
+	}
+	name := SanitizeFileName(strings.Join([]string{t.client, strings.ToLower(t.xds), resource}, "_")) + ".json"
+	path := filepath.Join(t.exportDir, name)
+	if err := ioutil.WriteFile(path, config, 0644); err != nil {
+		t.message = "Failed to export: " + err.Error()
+		return
+	}
+	t.message = "Exported to " + path
+}
+
+// renderSegments renders segments within width, colored if color is enabled
+func (t *TUI) renderSegments(segments []tuiSegment, width int, selected bool) string {
+	var line strings.Builder
+	remaining := width
+	for _, segment := range segments {
+		if remaining <= 0 {
+			break
+		}
+		text := truncate(segment.text, remaining)
+		remaining -= utf8.RuneCountInString(text)
+		if t.color && segment.color != "" {
+			line.WriteString(segment.color + text + colorReset)
+			if selected {
+				line.WriteString(colorReverse)
+			}
+		} else {
+			line.WriteString(text)
+		}
+	}
+	if !selected {
+		return line.String()
+	}
+	if !t.color {
+		return "> " + line.String()
+	}
+	return colorReverse + line.String() + strings.Repeat(" ", remaining) + colorReset
+}
+
+// breadcrumb shows the path of the current view
+func (t *TUI) breadcrumb() string {
+	path := []string{"clients", t.client, t.xds, t.resource}
+	return strings.Join(path[:t.view+1], " > ")
+}
+
+// Render renders the ui in a terminal of width columns and height rows
+func (t *TUI) Render(width int, height int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clampCursor()
+
+	header := t.breadcrumb()
+	if t.snapshot != nil {
+		header = fmt.Sprintf("%v  (%d clients, updated %v)", header, len(t.snapshot.Clients), t.snapshot.Time.Format(time.Kitchen))
+	} else {
+		header += "  (waiting for the first response)"
+	}
+	lines := []string{truncate(header, width)}
+	if t.filtering || t.filter != "" {
+		filter := "Filter: " + t.filter
+		if t.filtering {
+			filter += "_"
+		}
+		lines = append(lines, truncate(filter, width))
+	}
+
+	var footer []string
+	if t.showNacks {
+		nacks := t.nacks()
+		footer = append(footer, truncate(fmt.Sprintf("── NACKs (%d) ──", len(nacks)), width))
+		limit := height / 3
+		for i, nack := range nacks {
+			if i == limit {
+				footer = append(footer, fmt.Sprintf("... and %d more", len(nacks)-limit))
+				break
+			}
+			footer = append(footer, t.renderSegments([]tuiSegment{{text: nack, color: colorRed}}, width, false))
+		}
+	}
+	help := tuiHelp
+	if t.message != "" {
+		help = t.message
+	}
+	if t.color {
+		help = colorDim + truncate(help, width) + colorReset
+	}
+	footer = append(footer, help)
+
+	// the body takes the rest of the rows
+	bodyHeight := height - len(lines) - len(footer)
+	if bodyHeight < 1 {
+		bodyHeight = 1
+	}
+	cursor := t.cursor[t.view]
+	if t.view == viewConfig {
+		// the config view scrolls with the cursor
+		t.scroll[t.view] = cursor
+	}
+	if cursor < t.scroll[t.view] {
+		t.scroll[t.view] = cursor
+	}
+	if cursor >= t.scroll[t.view]+bodyHeight {
+		t.scroll[t.view] = cursor - bodyHeight + 1
+	}
+
+	var body []string
+	if t.view == viewConfig {
+		config := t.configLines()
+		for i := t.scroll[t.view]; i < len(config) && len(body) < bodyHeight; i++ {
+			body = append(body, truncate(config[i], width))
+		}
+	} else {
+		rows := t.rows()
+		if len(rows) == 0 {
+			body = append(body, "Nothing to show.")
+		}
+		for i := t.scroll[t.view]; i < len(rows) && len(body) < bodyHeight; i++ {
+			body = append(body, t.renderSegments(rows[i].segments, width, i == cursor))
+		}
+	}
+	for len(body) < bodyHeight {
+		body = append(body, "")
+	}
+	return append(append(lines, body...), footer...)
+}
@@ -0,0 +1,136 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ANSI escape codes to control the terminal of the ui
+const (
+	enterAltScreen string = "\x1b[?1049h"
+	exitAltScreen  string = "\x1b[?1049l"
+	hideCursor     string = "\x1b[?25l"
+	showCursor     string = "\x1b[?25h"
+	clearScreen    string = "\x1b[H\x1b[2J"
+)
+
+// escapeKeys maps the escape sequences of the keys to the keys
+var escapeKeys = map[string]string{
+	"\x1b[A":  KeyUp,
+	"\x1b[B":  KeyDown,
+	"\x1b[C":  KeyRight,
+	"\x1b[D":  KeyLeft,
+	"\x1bOA":  KeyUp,
+	"\x1bOB":  KeyDown,
+	"\x1bOC":  KeyRight,
+	"\x1bOD":  KeyLeft,
+	"\x1b[5~": KeyPageUp,
+	"\x1b[6~": KeyPageDown,
+}
+
+// resizeInterval is the interval of checking if the terminal is resized
+const resizeInterval = 500 * time.Millisecond
+
+// ParseKeys splits the bytes read from a terminal in raw mode into keys
+func ParseKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		switch {
+		case b[0] == 0x1b:
+			key, n := KeyEsc, 1
+			for sequence, k := range escapeKeys {
+				if strings.HasPrefix(string(b), sequence) {
+					key, n = k, len(sequence)
+					break
+				}
+			}
+			keys = append(keys, key)
+			b = b[n:]
+		case b[0] == '\r' || b[0] == '\n':
+			keys = append(keys, KeyEnter)
+			b = b[1:]
+		case b[0] == 0x7f || b[0] == 0x08:
+			keys = append(keys, KeyBackspace)
+			b = b[1:]
+		case b[0] == 0x03:
+			keys = append(keys, KeyInterrupt)
+			b = b[1:]
+		default:
+			r, n := utf8.DecodeRune(b)
+			if r != utf8.RuneError && r >= ' ' {
+				keys = append(keys, string(r))
+			}
+			b = b[n:]
+		}
+	}
+	return keys
+}
+
+// RunTerminal runs the ui in the terminal attached to in and out until it quits or done is closed
+func (t *TUI) RunTerminal(in *os.File, out *os.File, done <-chan struct{}) error {
+	if !isTerminal(in) || !isTerminal(out) {
+		return errors.New("the terminal ui needs a terminal")
+	}
+	restore, err := makeRaw(in)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	fmt.Fprint(out, enterAltScreen+hideCursor)
+	defer fmt.Fprint(out, showCursor+exitAltScreen)
+
+	keys := make(chan []string)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := in.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- ParseKeys(buf[:n])
+		}
+	}()
+
+	writer := bufio.NewWriter(out)
+	width, height := 0, 0
+	render := func() {
+		width, height = terminalSize(out)
+		if width <= 0 || height <= 0 {
+			width, height = 80, 24
+		}
+		writer.WriteString(clearScreen)
+		writer.WriteString(strings.Join(t.Render(width, height), "\r\n"))
+		writer.Flush()
+	}
+	render()
+
+	resize := time.NewTicker(resizeInterval)
+	defer resize.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case pressed, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, key := range pressed {
+				if t.HandleKey(key) {
+					return nil
+				}
+			}
+		case <-t.Updated():
+			render()
+		case <-resize.C:
+			if w, h := terminalSize(out); w != width || h != height {
+				render()
+			}
+		}
+	}
+}
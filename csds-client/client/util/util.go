@@ -15,9 +15,10 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 
 	"github.com/awalterschulze/gographviz"
-	"github.com/emirpasic/gods/sets/treeset"
+	envoy_admin_v3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
 	envoy_api_v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
@@ -25,12 +26,14 @@ import (
 	envoy_config_filter_http_fault_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/fault/v2"
 	envoy_config_filter_http_router_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/router/v2"
 	envoy_config_filter_network_http_connection_manager_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	envoy_config_filter_network_tcp_proxy_v2 "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
 	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	envoy_extensions_filters_http_cors_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/cors/v3"
 	envoy_extensions_filters_http_fault_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
 	envoy_extensions_filters_http_router_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
 	envoy_extensions_filters_network_http_connection_manager_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	envoy_extensions_filters_network_tcp_proxy_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
 	"github.com/ghodss/yaml"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -75,6 +78,12 @@ func (r *TypeResolver) FindMessageByURL(url string) (protoreflect.MessageType, e
 	case "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager":
 		httpConnectionManager := envoy_extensions_filters_network_http_connection_manager_v3.HttpConnectionManager{}
 		return httpConnectionManager.ProtoReflect().Type(), nil
+	case "type.googleapis.com/envoy.config.filter.network.tcp_proxy.v2.TcpProxy":
+		tcpProxy := envoy_config_filter_network_tcp_proxy_v2.TcpProxy{}
+		return tcpProxy.ProtoReflect().Type(), nil
+	case "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy":
+		tcpProxy := envoy_extensions_filters_network_tcp_proxy_v3.TcpProxy{}
+		return tcpProxy.ProtoReflect().Type(), nil
 	case "type.googleapis.com/envoy.api.v2.Cluster":
 		cluster := envoy_api_v2.Cluster{}
 		return cluster.ProtoReflect().Type(), nil
@@ -114,8 +123,24 @@ func (r *TypeResolver) FindMessageByURL(url string) (protoreflect.MessageType, e
 	case "type.googleapis.com/envoy.extensions.filters.http.cors.v3.Cors":
 		cors := envoy_extensions_filters_http_cors_v3.Cors{}
 		return cors.ProtoReflect().Type(), nil
+	case "type.googleapis.com/envoy.admin.v3.ListenersConfigDump":
+		listenersConfigDump := envoy_admin_v3.ListenersConfigDump{}
+		return listenersConfigDump.ProtoReflect().Type(), nil
+	case "type.googleapis.com/envoy.admin.v3.RoutesConfigDump":
+		routesConfigDump := envoy_admin_v3.RoutesConfigDump{}
+		return routesConfigDump.ProtoReflect().Type(), nil
+	case "type.googleapis.com/envoy.admin.v3.ScopedRoutesConfigDump":
+		scopedRoutesConfigDump := envoy_admin_v3.ScopedRoutesConfigDump{}
+		return scopedRoutesConfigDump.ProtoReflect().Type(), nil
+	case "type.googleapis.com/envoy.admin.v3.ClustersConfigDump":
+		clustersConfigDump := envoy_admin_v3.ClustersConfigDump{}
+		return clustersConfigDump.ProtoReflect().Type(), nil
+	case "type.googleapis.com/envoy.admin.v3.EndpointsConfigDump":
+		endpointsConfigDump := envoy_admin_v3.EndpointsConfigDump{}
+		return endpointsConfigDump.ProtoReflect().Type(), nil
 	default:
-		return nil, protoregistry.NotFound
+		// fall back to the message types linked into the binary
+		return protoregistry.GlobalTypes.FindMessageByURL(url)
 	}
 }
 
@@ -127,152 +152,156 @@ func (r *TypeResolver) FindExtensionByNumber(message protoreflect.FullName, fiel
 	return nil, protoregistry.NotFound
 }
 
-// Visualize calls ParseXdsRelationship and use the result to Visualize
-func Visualize(config []byte, monitor bool) error {
-	graphData, err := ParseXdsRelationship(config)
+// parseGraph calls ParseXdsRelationshipDetail on response with opts.GraphDetail, or resources by
+// default, then filters the graph with filterGraph
+func parseGraph(response proto.Message, opts client.ClientOptions) (*Graph, error) {
+	graph, err := parseFullGraph(response, opts)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return filterGraph(graph, opts)
+}
+
+// parseFullGraph calls ParseXdsRelationshipDetail on response with opts.GraphDetail, or resources
+// by default. The tree of the tree visualization mode always goes down to the endpoints.
+func parseFullGraph(response proto.Message, opts client.ClientOptions) (*Graph, error) {
+	detail := opts.GraphDetail
+	if detail == "" {
+		detail = GraphDetailResources
 	}
-	dot, err := GenerateGraph(graphData)
+	if opts.Visualization == VisualizationTree {
+		detail = GraphDetailEndpoints
+	}
+	return ParseXdsRelationshipDetail(response, detail)
+}
+
+// filterGraph keeps only the resources of opts.GraphClient in graph if it is set, then focuses the
+// graph on opts.GraphRoot within opts.GraphDepth if it is set
+func filterGraph(graph *Graph, opts client.ClientOptions) (*Graph, error) {
+	if opts.GraphClient != "" {
+		if graph = graph.ForClient(opts.GraphClient); graph == nil {
+			return nil, fmt.Errorf("client %v is not in the response", opts.GraphClient)
+		}
+	}
+	if opts.GraphRoot != "" {
+		xds, name, err := ParseGraphRoot(opts.GraphRoot)
+		if err != nil {
+			return nil, err
+		}
+		return graph.Focus(xds, name, opts.GraphDepth)
+	}
+	return graph, nil
+}
+
+// Visualize calls ParseXdsRelationship on response and use the result to Visualize. The graph is
+// saved in opts.GraphFormat to opts.GraphFile, or config_graph.<format> by default. A dot graph is
+// rendered to an svg image next to it with the dot command of Graphviz, then the image is opened
+// unless monitor is set. The graph is only sent to Graphviz Online, a third-party website, if
+// opts.VisualizationOnline is set. In html mode, the graph is saved as an interactive html page,
+// which is opened unless monitor is set or it is served at opts.GraphAddr. In tree mode, the graph
+// is printed out as a tree instead.
+func Visualize(response proto.Message, monitor bool, opts client.ClientOptions) error {
+	graph, err := parseGraph(response, opts)
 	if err != nil {
 		return err
 	}
+	return VisualizeGraph(graph, monitor, opts)
+}
 
-	if !monitor {
-		url := "http://dreampuf.github.io/GraphvizOnline/#" + dot
-		if err := OpenBrowser(url); err != nil {
-			return err
-		}
+// VisualizeGraph saves, renders and opens graph as Visualize does, e.g. the diff of two graphs
+func VisualizeGraph(graph *Graph, monitor bool, opts client.ClientOptions) error {
+	if opts.Visualization == VisualizationTree {
+		fmt.Println("Config tree:")
+		fmt.Print(GenerateTree(graph, ColorEnabled(os.Stdout)))
+		return nil
+	}
+	var err error
+	format := opts.GraphFormat
+	if format == "" {
+		format = GraphDot
+	}
+	var out string
+	if opts.Visualization == VisualizationHtml {
+		format = VisualizationHtml
+		out, err = GenerateHtml(graph)
+	} else {
+		out, err = FormatGraph(graph, format)
+	}
+	if err != nil {
+		return err
 	}
 
-	// save dot to file
-	f, err := os.Create("config_graph.dot")
+	// save the graph to file
+	filename := opts.GraphFile
+	if filename == "" {
+		filename = GraphFileName(format)
+	}
+	f, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	_, err = f.Write([]byte(dot))
+	_, err = f.Write([]byte(out))
 	if err != nil {
 		return err
 	}
-	fmt.Println("Config graph has been saved to config_graph.dot")
-	return nil
-}
+	fmt.Printf("Config graph has been saved to %v\n", filename)
 
-// struct stores the nodes and edges maps of graph
-type GraphData struct {
-	nodes     []map[string]string
-	relations []map[string]*treeset.Set
+	if format == VisualizationHtml {
+		if monitor || opts.GraphAddr != "" {
+			return nil
+		}
+		path, err := filepath.Abs(filename)
+		if err != nil {
+			return err
+		}
+		return OpenBrowser(path)
+	}
+	// only the dot graph is rendered
+	if format != GraphDot {
+		return nil
+	}
+	if opts.VisualizationOnline {
+		if monitor {
+			return nil
+		}
+		return OpenBrowser("http://dreampuf.github.io/GraphvizOnline/#" + out)
+	}
+	image, err := RenderGraph(filename, "svg")
+	if err != nil {
+		// the graph is still saved, so a missing Graphviz is not an error
+		fmt.Printf("Failed to render %v: %v\n", filename, err)
+		return nil
+	}
+	fmt.Printf("Config graph has been rendered to %v\n", image)
+	if monitor {
+		return nil
+	}
+	path, err := filepath.Abs(image)
+	if err != nil {
+		return err
+	}
+	return OpenBrowser(path)
 }
 
-// ParseXdsRelationship parses relationship between xds and stores them in GraphData
-func ParseXdsRelationship(js []byte) (GraphData, error) {
-	var data map[string]interface{}
-	err := json.Unmarshal(js, &data)
+// RenderGraph renders the graph in dotFile to an image of format (e.g. svg, png) next to it with
+// the dot command of Graphviz, and returns the path of the image
+func RenderGraph(dotFile string, format string) (string, error) {
+	dot, err := exec.LookPath("dot")
 	if err != nil {
-		return GraphData{}, err
-	}
-	lds := make(map[string]string)
-	rds := make(map[string]string)
-	cds := make(map[string]string)
-	eds := make(map[string]string)
-	ldsToRds := make(map[string]*treeset.Set)
-	rdsToCds := make(map[string]*treeset.Set)
-	cdsToEds := make(map[string]*treeset.Set)
-
-	for _, config := range data["config"].([]interface{}) {
-		configMap := config.(map[string]interface{})
-		for _, xds := range configMap["xdsConfig"].([]interface{}) {
-			for key, value := range xds.(map[string]interface{}) {
-				if key == "status" {
-					continue
-				}
-				switch key {
-				case "listenerConfig":
-					for _, listeners := range value.(map[string]interface{}) {
-						for idx, listener := range listeners.([]interface{}) {
-							detail := listener.(map[string]interface{})["activeState"].(map[string]interface{})["listener"].(map[string]interface{})
-							name := detail["name"].(string)
-							id := "LDS" + strconv.Itoa(idx)
-							lds[name] = id
-							rdsSet := treeset.NewWithStringComparator()
-
-							for _, filterchain := range detail["filterChains"].([]interface{}) {
-								if filters, ok := filterchain.(map[string]interface{})["filters"]; ok {
-									for _, filter := range filters.([]interface{}) {
-										rdsName := filter.(map[string]interface{})["typedConfig"].(map[string]interface{})["rds"].(map[string]interface{})["routeConfigName"].(string)
-										rdsSet.Add(rdsName)
-									}
-								}
-							}
-							ldsToRds[name] = rdsSet
-						}
-					}
-				case "routeConfig":
-					for _, routes := range value.(map[string]interface{}) {
-						for idx, route := range routes.([]interface{}) {
-							routeConfig := route.(map[string]interface{})["routeConfig"].(map[string]interface{})
-							name := routeConfig["name"].(string)
-							id := "RDS" + strconv.Itoa(idx)
-							rds[name] = id
-							cdsSet := treeset.NewWithStringComparator()
-
-							for _, virtualHost := range routeConfig["virtualHosts"].([]interface{}) {
-								for _, virtualRoutes := range virtualHost.(map[string]interface{})["routes"].([]interface{}) {
-									virtualRoute := virtualRoutes.(map[string]interface{})["route"].(map[string]interface{})
-									if weightedClusters, ok := virtualRoute["weightedClusters"]; ok {
-										for _, cluster := range weightedClusters.(map[string]interface{})["clusters"].([]interface{}) {
-											cdsName := cluster.(map[string]interface{})["name"].(string)
-											cdsSet.Add(cdsName)
-										}
-									} else {
-										cdsName := virtualRoute["cluster"].(string)
-										cdsSet.Add(cdsName)
-									}
-								}
-							}
-							rdsToCds[name] = cdsSet
-						}
-					}
-				case "clusterConfig":
-					for _, clusters := range value.(map[string]interface{}) {
-						for idx, cluster := range clusters.([]interface{}) {
-							name := cluster.(map[string]interface{})["cluster"].(map[string]interface{})["name"].(string)
-							id := "CDS" + strconv.Itoa(idx)
-							cds[name] = id
-						}
-					}
-				case "endpointConfig":
-					for _, endpoints := range value.(map[string]interface{}) {
-						for idx, endpoint := range endpoints.([]interface{}) {
-							id := "EDS" + strconv.Itoa(idx)
-							eds[id] = id
-
-							clusterName := endpoint.(map[string]interface{})["endpointConfig"].(map[string]interface{})["clusterName"].(string)
-							if cdsSet, ok := cdsToEds[clusterName]; ok {
-								cdsSet.Add(id)
-							} else {
-								cdsSet = treeset.NewWithStringComparator()
-								cdsSet.Add(id)
-								cdsToEds[clusterName] = cdsSet
-							}
-						}
-					}
-				}
-			}
-		}
+		return "", errors.New("the dot command of Graphviz (https://graphviz.org/download/) is not installed")
 	}
-
-	gData := GraphData{
-		nodes:     []map[string]string{lds, rds, cds, eds},
-		relations: []map[string]*treeset.Set{ldsToRds, rdsToCds, cdsToEds},
+	image := strings.TrimSuffix(dotFile, filepath.Ext(dotFile)) + "." + format
+	if out, err := exec.Command(dot, "-T"+format, "-o", image, dotFile).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
 	}
-
-	return gData, nil
+	return image, nil
 }
 
-// GenerateGraph generates dot string based on GraphData
-func GenerateGraph(data GraphData) (string, error) {
+// GenerateGraph generates dot string based on graph, the resources of each client are drawn in a
+// subgraph if there are many clients. The nodes are styled by the state of their resources, and
+// the references to the resources that are not in the response are drawn as broken links.
+func GenerateGraph(data *Graph) (string, error) {
 	graphAst, err := gographviz.ParseString(`digraph G {}`)
 	if err != nil {
 		return "", err
@@ -286,30 +315,71 @@ func GenerateGraph(data GraphData) (string, error) {
 		return "", err
 	}
 
-	// different colors for xDS nodes
-	colors := map[string]string{"LDS": "#4285F4", "RDS": "#EA4335", "CDS": "#FBBC04", "EDS": "#34A853"}
-
-	for _, xDS := range data.nodes {
-		for name, node := range xDS {
-			if err := graph.AddNode("G", `"`+name+`"`, map[string]string{"label": node, "fontcolor": "white", "fontname": "Roboto", "shape": "box", "style": `"filled,rounded"`, "color": `"` + colors[node[0:3]] + `"`, "fillcolor": `"` + colors[node[0:3]] + `"`}); err != nil {
+	parents := map[string]string{}
+	for i, client := range data.Clients {
+		parents[client] = "G"
+		if data.groupedByClient() {
+			parents[client] = "cluster_" + strconv.Itoa(i)
+			if err := graph.AddSubGraph("G", parents[client], map[string]string{"label": dotQuote(client)}); err != nil {
 				return "", err
 			}
 		}
 	}
-	for _, relations := range data.relations {
-		for src, set := range relations {
-			for _, dst := range set.Values() {
-				if err := graph.AddEdge(`"`+src+`"`, `"`+dst.(string)+`"`, true, map[string]string{"penwidth": "0.3", "arrowsize": "0.3"}); err != nil {
-					return "", err
-				}
-			}
+	for _, node := range data.Nodes {
+		style := node.style()
+		attrs := map[string]string{"label": dotQuote(node.label()), "tooltip": dotQuote(node.tooltip()), "fontcolor": dotQuote(style.font), "fontname": "Roboto", "shape": "box", "color": dotQuote(style.border)}
+		var styles []string
+		if style.fill != "" {
+			styles = append(styles, "filled")
+			attrs["fillcolor"] = dotQuote(style.fill)
+		}
+		styles = append(styles, "rounded")
+		if style.dashed {
+			styles = append(styles, "dashed")
+		}
+		if style.bold {
+			attrs["penwidth"] = "3"
+		}
+		attrs["style"] = dotQuote(strings.Join(styles, ","))
+		if err := graph.AddNode(parents[node.Client], dotQuote(node.ID), attrs); err != nil {
+			return "", err
+		}
+	}
+	for _, edge := range data.Edges {
+		attrs := map[string]string{"penwidth": "0.3", "arrowsize": "0.3"}
+		if edge.Label != "" {
+			attrs["label"] = dotQuote(edge.Label)
+			attrs["fontname"] = "Roboto"
+			attrs["fontsize"] = "10"
+		}
+		// a broken link is a red dashed line ending in a bar, and the changes of a graph diff are
+		// thicker lines in their colors
+		if color := edge.color(); color != "" {
+			attrs["color"] = dotQuote(color)
+		}
+		if edge.dashed() {
+			attrs["style"] = "dashed"
+		}
+		if edge.broken() {
+			attrs["arrowhead"] = "tee"
+		}
+		if edge.Diff != "" {
+			attrs["penwidth"] = "1.5"
+		}
+		if err := graph.AddEdge(dotQuote(edge.From.ID), dotQuote(edge.To.ID), true, attrs); err != nil {
+			return "", err
 		}
 	}
 
 	return graph.String(), nil
 }
 
-// OpenBrowser opens url in browser based on platform
+// dotQuote quotes s as a string of the dot language, the line breaks are escaped as \n
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// OpenBrowser opens url, or a file at an absolute path, in browser based on platform
 // TODO: the url cannot be passed correctly on some platforms because of \" and ",
 //  which need to be solve in the future.
 func OpenBrowser(url string) error {
@@ -340,7 +410,17 @@ func PrintDetailedConfig(response proto.Message, opts client.ClientOptions) erro
 		return err
 	}
 
-	if opts.ConfigFile == "" {
+	if opts.OutputDir != "" {
+		// write the configuration of each client to its own file
+		if err := SaveConfigToDir(response, opts); err != nil {
+			return err
+		}
+	} else if opts.OutputFormat == OutputConfigDump {
+		// output the configuration of each client as an Envoy admin config dump
+		if err := printConfigDump(response, opts); err != nil {
+			return err
+		}
+	} else if opts.ConfigFile == "" {
 		// output the configuration to stdout by default
 		fmt.Println("Detailed Config:")
 		fmt.Println(string(out))
@@ -358,9 +438,11 @@ func PrintDetailedConfig(response proto.Message, opts client.ClientOptions) erro
 		fmt.Printf("Config has been saved to %v\n", opts.ConfigFile)
 	}
 
-	// call visualize to enable visualization
-	if opts.Visualization {
-		if err := Visualize(out, opts.MonitorInterval != 0); err != nil {
+	// call visualize to enable visualization, the changes of the graph in graph-diff mode are
+	// visualized by the monitor, which keeps the previous graph
+	if opts.Visualization != "" && !opts.GraphDiff {
+		// a replay shows many responses as monitor mode does
+		if err := Visualize(response, opts.MonitorInterval != 0 || opts.ReplayFile != "", opts); err != nil {
 			return err
 		}
 	}
@@ -28,6 +28,7 @@ type ClientV2 struct {
 	nodeMatcher []*envoy_type_matcher_v2.NodeMatcher
 	metadata    metadata.MD
 	opts        client.ClientOptions
+	monitor     *clientutil.Monitor
 }
 
 // Field keys that must be presented in the NodeMatcher
@@ -41,6 +42,10 @@ const (
 // merge with the request loaded from -request_file
 func (c *ClientV2) parseNodeMatcher() error {
 	if c.opts.RequestFile == "" && c.opts.RequestYaml == "" {
+		// the request is optional in offline mode and replay
+		if c.opts.InputFile != "" || c.opts.ReplayFile != "" {
+			return nil
+		}
 		return errors.New("missing request yaml")
 	}
 
@@ -51,6 +56,11 @@ func (c *ClientV2) parseNodeMatcher() error {
 
 	c.nodeMatcher = nodematchers
 
+	// in offline mode and replay, nodematchers are only used to filter the clients loaded from -input_file
+	if c.opts.InputFile != "" || c.opts.ReplayFile != "" {
+		return nil
+	}
+
 	// check if required fields exist in NodeMatcher
 	switch c.opts.Platform {
 	case "gcp":
@@ -116,11 +126,38 @@ func New(option client.ClientOptions) (*ClientV2, error) {
 		return nil, err
 	}
 
+	monitor, err := clientutil.NewMonitor(c.opts)
+	if err != nil {
+		return nil, err
+	}
+	c.monitor = monitor
+
 	return c, nil
 }
 
-// Run connects the client to the uri and calls doRequest
+// Run connects the client to the uri and calls doRequest, or calls runOffline in offline mode and
+// runReplay in replay
 func (c *ClientV2) Run() error {
+	defer c.monitor.Close()
+
+	if err := c.monitor.Serve(); err != nil {
+		return err
+	}
+
+	// the terminal ui keeps showing the responses of a file until it quits
+	if c.opts.InputFile != "" {
+		if err := c.runOffline(); err != nil {
+			return err
+		}
+		return c.monitor.Wait()
+	}
+	if c.opts.ReplayFile != "" {
+		if err := c.runReplay(); err != nil {
+			return err
+		}
+		return c.monitor.Wait()
+	}
+
 	if err := c.connWithAuth(); err != nil {
 		return err
 	}
@@ -150,44 +187,140 @@ func (c *ClientV2) Run() error {
 					return err
 				}
 				continue
+			} else if c.opts.MetricsAddr != "" || c.opts.ServeAddr != "" || c.opts.TUI {
+				// keep exporting metrics, serving the api and showing the terminal ui, the error is
+				// counted in the metrics
+				fmt.Fprintf(c.monitor.Stderr(), "CSDS request failed: %v\n", err)
+				if stream, err := c.csdsClient.StreamClientStatus(ctx); err == nil {
+					streamClientStatus = stream
+				}
 			} else {
 				return err
 			}
 		}
+		if done, err := c.monitor.Done(); done {
+			// the error of the monitor, e.g. the rollout timed out, comes first
+			if closeErr := streamClientStatus.CloseSend(); closeErr != nil && err == nil {
+				return closeErr
+			}
+			return err
+		}
 		if c.opts.MonitorInterval != 0 {
-			time.Sleep(c.opts.MonitorInterval)
+			c.monitor.Sleep(c.opts.MonitorInterval)
 		} else {
 			if err = streamClientStatus.CloseSend(); err != nil {
 				return err
 			}
-			return nil
+			return c.monitor.Wait()
+		}
+	}
+}
+
+// runOffline loads the response from -input_file and prints it out without connecting to the control plane.
+// In graph-diff mode with two files, the graph is diffed against the one of the response in the old file.
+func (c *ClientV2) runOffline() error {
+	if c.opts.GraphDiffFile != "" {
+		base := &csdspb_v2.ClientStatusResponse{}
+		if err := clientutil.ReadResponseFile(c.opts.GraphDiffFile, base); err != nil {
+			return err
+		}
+		if err := c.filterResponse(base); err != nil {
+			return err
+		}
+		if err := c.monitor.SetBaseGraph(base); err != nil {
+			return err
 		}
 	}
+
+	response := &csdspb_v2.ClientStatusResponse{}
+	if err := clientutil.ReadResponseFile(c.opts.InputFile, response); err != nil {
+		return err
+	}
+
+	if err := c.filterResponse(response); err != nil {
+		return err
+	}
+
+	if err := c.monitor.Process(response, func() error {
+		return printOutResponse(response, c.opts)
+	}); err != nil {
+		return err
+	}
+	return c.monitor.EndError()
+}
+
+// runReplay feeds the responses recorded in -input_file through the same pipeline as live responses
+func (c *ClientV2) runReplay() error {
+	records, err := clientutil.ReadRecording(c.opts.ReplayFile)
+	if err != nil {
+		return err
+	}
+	if err := clientutil.Replay(records, c.opts.ReplaySpeed, func(record clientutil.Record) error {
+		response := &csdspb_v2.ClientStatusResponse{}
+		if err := record.UnmarshalResponse(response); err != nil {
+			return err
+		}
+		if err := c.filterResponse(response); err != nil {
+			return err
+		}
+		return c.monitor.ProcessAt(response, record.Time, func() error {
+			return printOutResponse(response, c.opts)
+		})
+	}); err != nil {
+		return err
+	}
+	return c.monitor.EndError()
+}
+
+// filterResponse filters the clients in a response that is not returned by the control plane by
+// the nodematchers of the request, as the control plane would do
+func (c *ClientV2) filterResponse(response *csdspb_v2.ClientStatusResponse) error {
+	var nodeMatchers []proto.Message
+	for _, nm := range c.nodeMatcher {
+		nodeMatchers = append(nodeMatchers, nm)
+	}
+	v3NodeMatchers, err := clientutil.ToV3NodeMatchers(nodeMatchers)
+	if err != nil {
+		return err
+	}
+	return clientutil.FilterClients(response, v3NodeMatchers)
 }
 
 // doRequest sends request and prints out the parsed response
 func (c *ClientV2) doRequest(streamClientStatus csdspb_v2.ClientStatusDiscoveryService_StreamClientStatusClient) error {
 
 	req := &csdspb_v2.ClientStatusRequest{NodeMatchers: c.nodeMatcher}
+	if err := c.monitor.Record(req); err != nil {
+		return err
+	}
+	start := time.Now()
 	if err := streamClientStatus.Send(req); err != nil {
+		c.monitor.ObserveRequest(time.Since(start), err)
 		return err
 	}
 
 	resp, err := streamClientStatus.Recv()
 	if err != nil && err != io.EOF {
+		c.monitor.ObserveRequest(time.Since(start), err)
+		return err
+	}
+	c.monitor.ObserveRequest(time.Since(start), nil)
+	if err := c.monitor.Record(resp); err != nil {
 		return err
 	}
 	// post process response
-	if err := printOutResponse(resp, c.opts); err != nil {
+	if err := c.monitor.Process(resp, func() error {
+		return printOutResponse(resp, c.opts)
+	}); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// parseConfigStatus parses each xds config status to string
-func parseConfigStatus(xdsConfig []*csdspb_v2.PerXdsConfig) []string {
-	var configStatus []string
+// parseConfigStatus parses the status and version of each xds config
+func parseConfigStatus(xdsConfig []*csdspb_v2.PerXdsConfig) []clientutil.XdsStatus {
+	configStatus := []clientutil.XdsStatus{}
 	for _, perXdsConfig := range xdsConfig {
 		status := perXdsConfig.GetStatus().String()
 		var xds string
@@ -201,7 +334,11 @@ func parseConfigStatus(xdsConfig []*csdspb_v2.PerXdsConfig) []string {
 			xds = "SRDS"
 		}
 		if status != "" && xds != "" {
-			configStatus = append(configStatus, xds+"   "+status)
+			configStatus = append(configStatus, clientutil.XdsStatus{
+				Xds:     xds,
+				Status:  status,
+				Version: clientutil.XdsVersion(perXdsConfig),
+			})
 		}
 	}
 	return configStatus
@@ -212,18 +349,18 @@ func printOutResponse(response *csdspb_v2.ClientStatusResponse, opts client.Clie
 	if response.GetConfig() == nil || len(response.GetConfig()) == 0 {
 		fmt.Printf("No xDS clients connected.\n")
 		return nil
-	} else {
-		fmt.Printf("%-50s %-30s %-30s \n", "Client ID", "xDS stream type", "Config Status")
 	}
 
 	var hasXdsConfig bool
+	var clients []clientutil.ClientStatus
 
 	for _, config := range response.GetConfig() {
 		var id string
 		var xdsType string
+		var metadata map[string]interface{}
 		if config.GetNode() != nil {
 			id = config.GetNode().GetId()
-			metadata := config.GetNode().GetMetadata().AsMap()
+			metadata = config.GetNode().GetMetadata().AsMap()
 
 			// control plane is expected to use "XDS_STREAM_TYPE" to communicate
 			// the stream type of the connected client in the response.
@@ -234,27 +371,21 @@ func printOutResponse(response *csdspb_v2.ClientStatusResponse, opts client.Clie
 
 		if config.GetXdsConfig() == nil {
 			if config.GetNode() != nil {
-				fmt.Printf("%-50s %-30s %-30s \n", id, xdsType, "N/A")
+				clients = append(clients, clientutil.ClientStatus{ID: id, StreamType: xdsType, Metadata: metadata})
 			}
 		} else {
 			hasXdsConfig = true
 
 			// parse config status
-			configStatus := parseConfigStatus(config.GetXdsConfig())
-			fmt.Printf("%-50s %-30s ", id, xdsType)
-
-			for i := 0; i < len(configStatus); i++ {
-				if i == 0 {
-					fmt.Printf("%-30s \n", configStatus[i])
-				} else {
-					fmt.Printf("%-50s %-30s %-30s \n", "", "", configStatus[i])
-				}
-			}
-			if len(configStatus) == 0 {
-				fmt.Printf("\n")
-			}
+			clients = append(clients, clientutil.ClientStatus{
+				ID:         id,
+				StreamType: xdsType,
+				Metadata:   metadata,
+				XdsStatus:  parseConfigStatus(config.GetXdsConfig()),
+			})
 		}
 	}
+	clientutil.PrintClientTable(clients, opts)
 
 	if hasXdsConfig {
 		if err := clientutil.PrintDetailedConfig(response, opts); err != nil {
@@ -5,7 +5,10 @@ import (
 	"envoy-tools/csds-client/client"
 	clientutil "envoy-tools/csds-client/client/util"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	csdspb_v2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
@@ -108,7 +111,7 @@ func TestParseResponseWithoutNodeId(t *testing.T) {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
-	want := "Client ID                                          xDS stream type                Config Status                  \ntest_node_1                                        test_stream_type1              N/A                            \ntest_node_2                                        test_stream_type2              N/A                            \ntest_node_3                                        test_stream_type3              N/A                            \n"
+	want := "Client ID     xDS stream type     Config Status\ntest_node_1   test_stream_type1   N/A\ntest_node_2   test_stream_type2   N/A\ntest_node_3   test_stream_type3   N/A\n"
 	if out != want {
 		t.Errorf("want\n%vout\n%v", want, out)
 	}
@@ -136,7 +139,7 @@ func TestParseResponseWithNodeId(t *testing.T) {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
-	want := "Client ID                                          xDS stream type                Config Status                  \ntest_nodeid                                        test_stream_type1              RDS   STALE                    \n                                                                                  CDS   STALE                    \nConfig has been saved to test_config.json\n"
+	want := "Client ID     xDS stream type     Config Status\ntest_nodeid   test_stream_type1   RDS   STALE\n                                  CDS   STALE\nConfig has been saved to test_config.json\n"
 	if out != want {
 		t.Errorf("want\n%vout\n%v", want, out)
 	}
@@ -155,18 +158,118 @@ func TestParseResponseWithNodeId(t *testing.T) {
 	}
 }
 
-// TestVisualization tests parsing xds relationship from config and generating .dot
-func TestVisualization(t *testing.T) {
-	filename, _ := filepath.Abs("./response_for_visualization.json")
+// TestParseResponseWithOutputDir tests saving the config of each client to -output_dir
+func TestParseResponseWithOutputDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds_output")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := ClientV2{
+		opts: client.ClientOptions{
+			Platform:    "gcp",
+			OutputDir:   dir,
+			SplitOutput: true,
+		},
+	}
+	filename, _ := filepath.Abs("./response_with_nodeid_test.json")
 	responsejson, err := ioutil.ReadFile(filename)
 	if err != nil {
 		t.Errorf("Read From File Failure: %v", err)
 	}
-	if err := clientutil.Visualize(responsejson, false); err != nil {
+	var response csdspb_v2.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	out := clientutil.CaptureOutput(func() {
+		if err := printOutResponse(&response, c.opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	if !strings.HasSuffix(out, "Config has been saved to "+dir+"\n") {
+		t.Errorf("Output dir is not reported, out\n%v", out)
+	}
+
+	index, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Errorf("Write index to file failure: %v", err)
+	}
+	want := "{\"clients\": [{\"id\": \"test_nodeid\", \"xds_stream_type\": \"test_stream_type1\", \"config_status\": {\"RDS\": \"STALE\", \"CDS\": \"STALE\"}, \"path\": \"test_nodeid\"}]}"
+	if !clientutil.ShouldEqualJSON(t, string(index), want) {
+		t.Errorf("Index = \n%v\n, want: \n%v\n", string(index), want)
+	}
+	for _, name := range []string{"node.json", "rds.json", "cds.json"} {
+		if _, err := os.Stat(filepath.Join(dir, "test_nodeid", name)); err != nil {
+			t.Errorf("Write split config to file failure: %v", err)
+		}
+	}
+}
+
+// TestVisualization tests parsing xds relationship from config and generating .dot
+func TestVisualization(t *testing.T) {
+	response := &csdspb_v2.ClientStatusResponse{}
+	if err := clientutil.ReadResponseFile("response_for_visualization.json", response); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	graph, err := clientutil.ParseXdsRelationship(response)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	if !reflect.DeepEqual(graph.Clients, []string{"test_nodeid"}) {
+		t.Errorf("want clients [test_nodeid], got %v", graph.Clients)
+	}
+	// the ids are the client, the xds type and the name, the client is left out below for brevity
+	var nodes, edges []string
+	for _, node := range graph.Nodes {
+		if node.Client != "test_nodeid" || node.ID != node.Client+"/"+node.Xds+"/"+node.Name {
+			t.Errorf("want the id of %v made of its client, xds type and name, got %v", node.Name, node.ID)
+		}
+		nodes = append(nodes, node.Xds+"/"+node.Name+" "+node.State)
+	}
+	for _, edge := range graph.Edges {
+		edges = append(edges, strings.TrimPrefix(edge.From.ID, "test_nodeid/")+"->"+strings.TrimPrefix(edge.To.ID, "test_nodeid/"))
+	}
+	wantNodes := []string{
+		"LDS/test_lds_0 ACTIVE", "LDS/test_lds_1 WARMING", "LDS/test_lds_2 DRAINING",
+		"RDS/test_rds_0 ACTIVE", "RDS/test_rds_1 ACTIVE", "RDS/test_inline_rds INLINE",
+		"CDS/test_cds_0 ACTIVE", "CDS/test_cds_1 ACTIVE", "CDS/test_cds_2 ",
+	}
+	// the redirect, the direct response and the cluster header have no cluster to refer to
+	wantEdges := []string{
+		"LDS/test_lds_0->RDS/test_rds_0", "LDS/test_lds_0->RDS/test_rds_1", "LDS/test_lds_1->CDS/test_cds_2",
+		"LDS/test_lds_2->RDS/test_inline_rds", "RDS/test_inline_rds->CDS/test_cds_0",
+		"RDS/test_rds_0->CDS/test_cds_0", "RDS/test_rds_0->CDS/test_cds_1", "RDS/test_rds_1->CDS/test_cds_1",
+	}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("want nodes %v, got %v", wantNodes, nodes)
+	}
+	if !reflect.DeepEqual(edges, wantEdges) {
+		t.Errorf("want edges %v, got %v", wantEdges, edges)
+	}
+
+	dot, err := clientutil.GenerateGraph(graph)
+	if err != nil {
+		t.Fatalf("Generate Graph Failure: %v", err)
+	}
+	for _, want := range []string{
+		`"test_nodeid/LDS/test_lds_0"->"test_nodeid/RDS/test_rds_0"`,
+		`"test_nodeid/LDS/test_lds_0" [ color="#4285F4", fillcolor="#4285F4", fontcolor="white", fontname=Roboto, label="test_lds_0", shape=box, style="filled,rounded", tooltip="test_nodeid/LDS/test_lds_0\nACTIVE" ]`,
+		// the warming listener is marked
+		`"test_nodeid/LDS/test_lds_1" [ color="#3C4043", fillcolor="#4285F4", fontcolor="white", fontname=Roboto, label="test_lds_1\n(warming)", penwidth=3`,
+		// the cluster that is not in the response is hollow and dashed, and the link to it is broken
+		`"test_nodeid/CDS/test_cds_2" [ color="#FBBC04", fontcolor="#FBBC04", fontname=Roboto, label="test_cds_2\n(missing)", shape=box, style="rounded,dashed"`,
+		`"test_nodeid/LDS/test_lds_1"->"test_nodeid/CDS/test_cds_2"[ arrowhead=tee, arrowsize=0.3, color="#D93025", penwidth=0.3, style=dashed ]`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("want %v in graph, got %v", want, dot)
+		}
+	}
+	// the graph is saved without being opened, whether or not Graphviz is installed
+	if err := clientutil.Visualize(response, true, client.ClientOptions{}); err != nil {
 		t.Errorf("Visualization Failure: %v", err)
 	}
-	want := "digraph G {\nrankdir=LR;\n\\\"test_lds_0\\\"->\\\"test_rds_0\\\"[ arrowsize=0.3, penwidth=0.3 ];\n\\\"test_lds_0\\\"->\\\"test_rds_1\\\"[ arrowsize=0.3, penwidth=0.3 ];\n\\\"test_rds_0\\\"->\\\"test_cds_0\\\"[ arrowsize=0.3, penwidth=0.3 ];\n\\\"test_rds_0\\\"->\\\"test_cds_1\\\"[ arrowsize=0.3, penwidth=0.3 ];\n\\\"test_rds_1\\\"->\\\"test_cds_1\\\"[ arrowsize=0.3, penwidth=0.3 ];\n\\\"test_cds_0\\\" [ color=\\\"#34A853\\\", fillcolor=\\\"#34A853\\\", fontcolor=white, fontname=Roboto, label=CDS0, shape=box, style=\\\"\"filled,rounded\"\\\" ];\n\\\"test_cds_1\\\" [ color=\\\"#34A853\\\", fillcolor=\\\"#34A853\\\", fontcolor=white, fontname=Roboto, label=CDS1, shape=box, style=\\\"\"filled,rounded\"\\\" ];\n\\\"test_lds_0\\\" [ color=\\\"#4285F4\\\", fillcolor=\\\"#4285F4\\\", fontcolor=white, fontname=Roboto, label=LDS0, shape=box, style=\\\"\"filled,rounded\"\\\" ];\n\\\"test_rds_0\\\" [ color=\\\"#FBBC04\\\", fillcolor=\\\"#FBBC04\\\", fontcolor=white, fontname=Roboto, label=RDS0, shape=box, style=\\\"\"filled,rounded\"\\\" ];\n\\\"test_rds_1\\\" [ color=\\\"#FBBC04\\\", fillcolor=\\\"#FBBC04\\\", fontcolor=white, fontname=Roboto, label=RDS1, shape=box, style=\\\"\"filled,rounded\"\\\" ];\n\n}\n"
-	if err := clientutil.OpenBrowser("http://dreampuf.github.io/GraphvizOnline/#" + want); err != nil {
-		t.Errorf("Open want graph failure: %v", err)
+	if saved, err := ioutil.ReadFile("config_graph.dot"); err != nil || string(saved) != dot {
+		t.Errorf("want the graph saved to config_graph.dot, got %v", err)
 	}
 }
@@ -2,11 +2,22 @@
 package client
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"envoy-tools/csds-client/client"
 	clientUtil "envoy-tools/csds-client/client/util"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -108,7 +119,7 @@ func TestParseResponseWithoutNodeId(t *testing.T) {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
-	want := "Client ID                                          xDS stream type                Config Status                  \ntest_node_1                                        test_stream_type1              N/A                            \ntest_node_2                                        test_stream_type2              N/A                            \ntest_node_3                                        test_stream_type3              N/A                            \n"
+	want := "Client ID     xDS stream type     Config Status\ntest_node_1   test_stream_type1   N/A\ntest_node_2   test_stream_type2   N/A\ntest_node_3   test_stream_type3   N/A\n"
 	if out != want {
 		t.Errorf("want\n%vout\n%v", want, out)
 	}
@@ -136,7 +147,7 @@ func TestParseResponseWithNodeId(t *testing.T) {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
-	want := "Client ID                                          xDS stream type                Config Status                  \ntest_nodeid                                        test_stream_type1              RDS   STALE                    \n                                                                                  CDS   STALE                    \nConfig has been saved to test_config.json\n"
+	want := "Client ID     xDS stream type     Config Status\ntest_nodeid   test_stream_type1   RDS   STALE\n                                  CDS   STALE\nConfig has been saved to test_config.json\n"
 	if out != want {
 		t.Errorf("want\n%vout\n%v", want, out)
 	}
@@ -155,18 +166,2047 @@ func TestParseResponseWithNodeId(t *testing.T) {
 	}
 }
 
-// TestVisualization tests parsing xds relationship from config and generating .dot
-func TestVisualization(t *testing.T) {
-	filename, _ := filepath.Abs("./response_for_visualization.json")
+// TestParseResponseWithWide tests printing versions and metadata with -wide
+func TestParseResponseWithWide(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:   "gcp",
+			ConfigFile: "test_config.json",
+			Wide:       true,
+		},
+	}
+	filename, _ := filepath.Abs("./response_with_nodeid_test.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	var response csdspb_v3.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(&response, c.opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := "Client ID     xDS stream type     Config Status   Version                 Metadata\ntest_nodeid   test_stream_type1   RDS   STALE     fake_route_version2     TRAFFICDIRECTOR_GCP_PROJECT_NUMBER=fake_project_number,TRAFFICDIRECTOR_NETWORK_NAME=fake_network_name\n                                  CDS   STALE     fake_cluster_version2\nConfig has been saved to test_config.json\n"
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
+
+// TestParseResponseWithOutputDir tests saving the config of each client to -output_dir
+func TestParseResponseWithOutputDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds_output")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:    "gcp",
+			OutputDir:   dir,
+			SplitOutput: true,
+		},
+	}
+	filename, _ := filepath.Abs("./response_with_nodeid_test.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	var response csdspb_v3.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(&response, c.opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	if !strings.HasSuffix(out, "Config has been saved to "+dir+"\n") {
+		t.Errorf("Output dir is not reported, out\n%v", out)
+	}
+
+	index, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Errorf("Write index to file failure: %v", err)
+	}
+	want := "{\"clients\": [{\"id\": \"test_nodeid\", \"xds_stream_type\": \"test_stream_type1\", \"config_status\": {\"RDS\": \"STALE\", \"CDS\": \"STALE\"}, \"path\": \"test_nodeid\"}]}"
+	if !clientUtil.ShouldEqualJSON(t, string(index), want) {
+		t.Errorf("Index = \n%v\n, want: \n%v\n", string(index), want)
+	}
+	for _, name := range []string{"node.json", "rds.json", "cds.json"} {
+		if _, err := os.Stat(filepath.Join(dir, "test_nodeid", name)); err != nil {
+			t.Errorf("Write split config to file failure: %v", err)
+		}
+	}
+}
+
+// TestOutputDirNames tests naming the files of the clients in -output_dir uniquely
+func TestOutputDirNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds_output")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// node/a is sanitized to node_a, and the suffix of the second node_a clashes with node_a-1, and
+	// the client index is named after the index file
+	js := `{"config": [{"node": {"id": "node_a"}}, {"node": {"id": "node_a-1"}}, {"node": {"id": "node/a"}}, {"node": {"id": "index"}}]}`
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	if err := clientUtil.SaveConfigToDir(response, client.ClientOptions{OutputDir: dir}); err != nil {
+		t.Fatalf("Save config to dir error: %v", err)
+	}
+	for _, name := range []string{"node_a.json", "node_a-1.json", "node_a-2.json", "index-1.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Write config to file failure: %v", err)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Fatalf("Read index file failure: %v", err)
+	}
+	var index struct {
+		Clients []struct {
+			Path string `json:"path"`
+		} `json:"clients"`
+	}
+	if err := json.Unmarshal(b, &index); err != nil || len(index.Clients) != 4 || index.Clients[3].Path != "index-1.json" {
+		t.Errorf("want the index of 4 clients with index saved to index-1.json, got %v (%v)", string(b), err)
+	}
+}
+
+// TestParseResponseWithConfigDump tests saving the config of a client as an Envoy admin config dump
+func TestParseResponseWithConfigDump(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:     "gcp",
+			ConfigFile:   "test_config_dump.json",
+			OutputFormat: "config_dump",
+		},
+	}
+	filename, _ := filepath.Abs("./response_with_nodeid_test.json")
 	responsejson, err := ioutil.ReadFile(filename)
 	if err != nil {
 		t.Errorf("Read From File Failure: %v", err)
 	}
-	if err := clientUtil.Visualize(responsejson, false); err != nil {
+	var response csdspb_v3.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(&response, c.opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+
+	outfile, _ := filepath.Abs("./test_config_dump.json")
+	outputjson, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		t.Errorf("Write config dump to file failure: %v", err)
+	}
+	want := "{\"configs\": [{\"@type\": \"type.googleapis.com/envoy.admin.v3.ClustersConfigDump\", \"dynamicActiveClusters\": [{\"versionInfo\": \"fake_cluster_version1\"}, {\"versionInfo\": \"fake_cluster_version2\"}]}, {\"@type\": \"type.googleapis.com/envoy.admin.v3.RoutesConfigDump\", \"dynamicRouteConfigs\": [{\"versionInfo\": \"fake_route_version1\"}, {\"versionInfo\": \"fake_route_version2\"}]}]}"
+	if !clientUtil.ShouldEqualJSON(t, string(outputjson), want) {
+		t.Errorf("Config dump = \n%v\n, want: \n%v\n", string(outputjson), want)
+	}
+}
+
+// TestRunOffline tests loading a saved response and filtering it by -request_yaml in offline mode
+func TestRunOffline(t *testing.T) {
+	c, err := New(client.ClientOptions{
+		Platform:    "gcp",
+		InputFile:   "./response_without_nodeid_test.json",
+		RequestYaml: "{\"node_matchers\": [{\"node_id\": {\"prefix\": \"test_node_\"}, \"node_metadatas\": [{\"path\": [{\"key\": \"XDS_STREAM_TYPE\"}], \"value\": {\"string_match\": {\"suffix\": \"type2\"}}}]}]}",
+	})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run offline error: %v", err)
+		}
+	})
+	want := "Client ID     xDS stream type     Config Status\ntest_node_2   test_stream_type2   N/A\n"
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
+
+// TestRunOfflineWithConfigDump tests loading an Envoy admin config dump in offline mode
+func TestRunOfflineWithConfigDump(t *testing.T) {
+	c, err := New(client.ClientOptions{
+		Platform:   "gcp",
+		InputFile:  "./config_dump_test.json",
+		ConfigFile: "test_config.json",
+	})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run offline error: %v", err)
+		}
+	})
+	want := "Client ID    xDS stream type   Config Status\ntest_envoy   ADS               CDS   UNKNOWN\n                               LDS   UNKNOWN\nConfig has been saved to test_config.json\n"
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
+
+// TestVisualization tests parsing xds relationship from config and generating .dot
+func TestVisualization(t *testing.T) {
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := clientUtil.ReadResponseFile("response_for_visualization.json", response); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	graph, err := clientUtil.ParseXdsRelationship(response)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	if !reflect.DeepEqual(graph.Clients, []string{"test_nodeid"}) {
+		t.Errorf("want clients [test_nodeid], got %v", graph.Clients)
+	}
+	// the ids are the client, the xds type and the name, the client is left out below for brevity
+	var nodes, edges []string
+	for _, node := range graph.Nodes {
+		if node.Client != "test_nodeid" || node.ID != node.Client+"/"+node.Xds+"/"+node.Name {
+			t.Errorf("want the id of %v made of its client, xds type and name, got %v", node.Name, node.ID)
+		}
+		nodes = append(nodes, node.Xds+"/"+node.Name+" "+node.State)
+	}
+	for _, edge := range graph.Edges {
+		edges = append(edges, strings.TrimPrefix(edge.From.ID, "test_nodeid/")+"->"+strings.TrimPrefix(edge.To.ID, "test_nodeid/"))
+	}
+	wantNodes := []string{
+		"LDS/test_lds_0 ACTIVE", "LDS/test_lds_1 WARMING", "LDS/test_lds_2 DRAINING",
+		"RDS/test_rds_0 ACTIVE", "RDS/test_rds_1 ACTIVE", "RDS/test_inline_rds INLINE",
+		"CDS/test_cds_0 ACTIVE", "CDS/test_cds_1 ACTIVE", "CDS/test_cds_2 ", "EDS/test_cds_0 STATIC",
+	}
+	// the redirect, the direct response and the cluster header have no cluster to refer to
+	wantEdges := []string{
+		"LDS/test_lds_0->RDS/test_rds_0", "LDS/test_lds_0->RDS/test_rds_1", "LDS/test_lds_1->CDS/test_cds_2",
+		"LDS/test_lds_2->RDS/test_inline_rds", "RDS/test_inline_rds->CDS/test_cds_0",
+		"RDS/test_rds_0->CDS/test_cds_0", "RDS/test_rds_0->CDS/test_cds_1", "RDS/test_rds_1->CDS/test_cds_1", "CDS/test_cds_0->EDS/test_cds_0",
+	}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("want nodes %v, got %v", wantNodes, nodes)
+	}
+	if !reflect.DeepEqual(edges, wantEdges) {
+		t.Errorf("want edges %v, got %v", wantEdges, edges)
+	}
+
+	dot, err := clientUtil.GenerateGraph(graph)
+	if err != nil {
+		t.Fatalf("Generate Graph Failure: %v", err)
+	}
+	for _, want := range []string{
+		`"test_nodeid/LDS/test_lds_0"->"test_nodeid/RDS/test_rds_0"`,
+		`"test_nodeid/LDS/test_lds_0" [ color="#4285F4", fillcolor="#4285F4", fontcolor="white", fontname=Roboto, label="test_lds_0", shape=box, style="filled,rounded", tooltip="test_nodeid/LDS/test_lds_0\nACTIVE" ]`,
+		// the warming listener is marked
+		`"test_nodeid/LDS/test_lds_1" [ color="#3C4043", fillcolor="#4285F4", fontcolor="white", fontname=Roboto, label="test_lds_1\n(warming)", penwidth=3`,
+		// the cluster that is not in the response is hollow and dashed, and the link to it is broken
+		`"test_nodeid/CDS/test_cds_2" [ color="#FBBC04", fontcolor="#FBBC04", fontname=Roboto, label="test_cds_2\n(missing)", shape=box, style="rounded,dashed"`,
+		`"test_nodeid/LDS/test_lds_1"->"test_nodeid/CDS/test_cds_2"[ arrowhead=tee, arrowsize=0.3, color="#D93025", penwidth=0.3, style=dashed ]`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("want %v in graph, got %v", want, dot)
+		}
+	}
+	// the graph is saved without being opened, whether or not Graphviz is installed
+	if err := clientUtil.Visualize(response, true, client.ClientOptions{}); err != nil {
 		t.Errorf("Visualization Failure: %v", err)
 	}
-	want := "digraph G {rankdir=LR;\\\"test_lds_0\\\"->\\\"test_rds_0\\\"[ arrowsize=0.3, penwidth=0.3 ];\\\"test_lds_0\\\"->\\\"test_rds_1\\\"[ arrowsize=0.3, penwidth=0.3 ];\\\"test_rds_0\\\"->\\\"test_cds_0\\\"[ arrowsize=0.3, penwidth=0.3 ];\\\"test_rds_0\\\"->\\\"test_cds_1\\\"[ arrowsize=0.3, penwidth=0.3 ];\\\"test_rds_1\\\"->\\\"test_cds_1\\\"[ arrowsize=0.3, penwidth=0.3 ];\\\"test_cds_0\\\"->\\\"EDS0\\\"[ arrowsize=0.3, penwidth=0.3 ];\\\"EDS0\\\" [ color=\\\"#34A853\\\", fillcolor=\\\"#34A853\\\", fontcolor=white, fontname=Roboto, label=EDS0, shape=box, style=\\\"filled,rounded\\\" ];\\\"test_cds_0\\\" [ color=\\\"#FBBC04\\\", fillcolor=\\\"#FBBC04\\\", fontcolor=white, fontname=Roboto, label=CDS0, shape=box, style=\\\"filled,rounded\\\" ];\\\"test_cds_1\\\" [ color=\\\"#FBBC04\\\", fillcolor=\\\"#FBBC04\\\", fontcolor=white, fontname=Roboto, label=CDS1, shape=box, style=\\\"filled,rounded\\\" ];\\\"test_lds_0\\\" [ color=\\\"#4285F4\\\", fillcolor=\\\"#4285F4\\\", fontcolor=white, fontname=Roboto, label=LDS0, shape=box, style=\\\"filled,rounded\\\" ];\\\"test_rds_0\\\" [ color=\\\"#EA4335\\\", fillcolor=\\\"#EA4335\\\", fontcolor=white, fontname=Roboto, label=RDS0, shape=box, style=\\\"filled,rounded\\\" ];\\\"test_rds_1\\\" [ color=\\\"#EA4335\\\", fillcolor=\\\"#EA4335\\\", fontcolor=white, fontname=Roboto, label=RDS1, shape=box, style=\\\"filled,rounded\\\" ];}"
-	if err := clientUtil.OpenBrowser("http://dreampuf.github.io/GraphvizOnline/#" + want); err != nil {
-		t.Errorf("Open want graph failure: %v", err)
+	if saved, err := ioutil.ReadFile("config_graph.dot"); err != nil || string(saved) != dot {
+		t.Errorf("want the graph saved to config_graph.dot, got %v", err)
+	}
+}
+
+// TestGraphFormats tests generating the graph in the formats other than dot
+func TestGraphFormats(t *testing.T) {
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := clientUtil.ReadResponseFile("response_for_visualization.json", response); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	graph, err := clientUtil.ParseXdsRelationship(response)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	format := func(format string) string {
+		out, err := clientUtil.FormatGraph(graph, format)
+		if err != nil {
+			t.Fatalf("Format Graph %v Failure: %v", format, err)
+		}
+		return out
+	}
+
+	mermaid := format(clientUtil.GraphMermaid)
+	for _, want := range []string{"flowchart LR\n", `  n0["test_lds_0"]`, "  n0 --> n3\n", "  class n0,n1,n2 LDS\n"} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("want %q in mermaid graph, got %v", want, mermaid)
+		}
+	}
+	d2 := format(clientUtil.GraphD2)
+	for _, want := range []string{"direction: right\n", `"LDS/test_lds_0": "test_lds_0" {`, `"LDS/test_lds_0" -> "RDS/test_rds_0"` + "\n"} {
+		if !strings.Contains(d2, want) {
+			t.Errorf("want %q in d2 graph, got %v", want, d2)
+		}
+	}
+
+	var cytoscape struct {
+		Elements struct {
+			Nodes []struct{ Data map[string]string }
+			Edges []struct{ Data map[string]string }
+		}
+	}
+	if err := json.Unmarshal([]byte(format(clientUtil.GraphJson)), &cytoscape); err != nil {
+		t.Fatalf("Parse json graph error: %v", err)
+	}
+	if len(cytoscape.Elements.Nodes) != len(graph.Nodes) || len(cytoscape.Elements.Edges) != len(graph.Edges) {
+		t.Errorf("want %d nodes and %d edges in json graph, got %v", len(graph.Nodes), len(graph.Edges), cytoscape)
+	} else if node := cytoscape.Elements.Nodes[1].Data; node["id"] != "test_nodeid/LDS/test_lds_1" || node["client"] != "test_nodeid" || node["name"] != "test_lds_1" || node["state"] != "WARMING" {
+		t.Errorf("want node test_lds_1 in json graph, got %v", node)
+	}
+
+	var graphML struct {
+		Graph struct {
+			Nodes []struct {
+				ID   string `xml:"id,attr"`
+				Data []struct {
+					Key   string `xml:"key,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"data"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+				Target string `xml:"target,attr"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal([]byte(format(clientUtil.GraphGraphML)), &graphML); err != nil {
+		t.Fatalf("Parse graphml error: %v", err)
+	}
+	if len(graphML.Graph.Nodes) != len(graph.Nodes) || len(graphML.Graph.Edges) != len(graph.Edges) {
+		t.Errorf("want %d nodes and %d edges in graphml, got %v", len(graph.Nodes), len(graph.Edges), graphML)
+	} else if node := graphML.Graph.Nodes[0]; node.ID != "test_nodeid/LDS/test_lds_0" || node.Data[0].Value != "test_nodeid" || node.Data[1].Value != "test_lds_0" {
+		t.Errorf("want node test_lds_0 in graphml, got %v", node)
+	}
+
+	if _, err := clientUtil.FormatGraph(graph, "png"); err == nil {
+		t.Errorf("want an error for an unsupported format")
+	}
+}
+
+// TestGraphHtml tests generating and serving the html viewer of the graph
+func TestGraphHtml(t *testing.T) {
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := clientUtil.ReadResponseFile("response_for_visualization.json", response); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "graph.html")
+	opts := client.ClientOptions{Visualization: clientUtil.VisualizationHtml, GraphFile: filename}
+	if err := clientUtil.Visualize(response, true, opts); err != nil {
+		t.Fatalf("Visualization Failure: %v", err)
+	}
+	page, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("want the viewer saved to %v, got %v", filename, err)
+	}
+	for _, want := range []string{`"id":"test_nodeid/LDS/test_lds_1"`, `"client":"test_nodeid"`, `"name":"test_lds_1"`, `"state":"WARMING"`, `"@type":"type.googleapis.com/envoy.config.listener.v3.Listener"`} {
+		if !strings.Contains(strings.ReplaceAll(string(page), " ", ""), want) {
+			t.Errorf("want %v embedded in the viewer", want)
+		}
+	}
+	// the viewer works offline
+	for _, external := range []string{"src=", "href=", "@import"} {
+		if strings.Contains(string(page), external) {
+			t.Errorf("want no external resource in the viewer, got %v", external)
+		}
+	}
+
+	viewer := clientUtil.NewGraphViewer()
+	recorder := httptest.NewRecorder()
+	viewer.ServeHTTP(recorder, httptest.NewRequest("GET", "/graph", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %v before the first response, got %v", http.StatusServiceUnavailable, recorder.Code)
+	}
+	graph, err := clientUtil.ParseXdsRelationship(response)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	if err := viewer.Update(graph); err != nil {
+		t.Fatalf("Update viewer error: %v", err)
+	}
+	recorder = httptest.NewRecorder()
+	viewer.ServeHTTP(recorder, httptest.NewRequest("GET", "/graph", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != string(page) {
+		t.Errorf("want the viewer served, got %v", recorder.Code)
+	}
+}
+
+// TestGraphClients tests that the same resource of two clients is two nodes, which are grouped by
+// client, and selecting the graph of a client
+func TestGraphClients(t *testing.T) {
+	js := `{"config": [
+		{"node": {"id": "node_a"}, "xdsConfig": [
+			{"status": "SYNCED", "routeConfig": {"dynamicRouteConfigs": [
+				{"routeConfig": {"@type": "type.googleapis.com/envoy.config.route.v3.RouteConfiguration", "name": "route_1", "virtualHosts": [
+					{"name": "host_1", "domains": ["*"], "routes": [{"match": {"prefix": "/"}, "route": {"cluster": "cluster_1"}}]}]}}]}},
+			{"status": "SYNCED", "clusterConfig": {"dynamicActiveClusters": [
+				{"cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_1"}}]}}]},
+		{"node": {"id": "node_b"}, "xdsConfig": [
+			{"status": "SYNCED", "clusterConfig": {"dynamicActiveClusters": [
+				{"cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_1"}}]}}]}]}`
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	graph, err := clientUtil.ParseXdsRelationship(response)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	var nodes, edges []string
+	for _, node := range graph.Nodes {
+		nodes = append(nodes, node.ID)
+	}
+	for _, edge := range graph.Edges {
+		edges = append(edges, edge.From.ID+"->"+edge.To.ID)
+	}
+	wantNodes := []string{"node_a/RDS/route_1", "node_a/CDS/cluster_1", "node_b/CDS/cluster_1"}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("want nodes %v, got %v", wantNodes, nodes)
+	}
+	if want := []string{"node_a/RDS/route_1->node_a/CDS/cluster_1"}; !reflect.DeepEqual(edges, want) {
+		t.Errorf("want edges %v, got %v", want, edges)
+	}
+	if node := graph.Node("node_b/CDS/cluster_1"); node == nil || node.Client != "node_b" || node.State != "ACTIVE" {
+		t.Errorf("want cluster_1 of node_b, got %v", node)
+	}
+
+	// the resources of each client are grouped in the formats
+	dot, err := clientUtil.GenerateGraph(graph)
+	if err != nil {
+		t.Fatalf("Generate Graph Failure: %v", err)
+	}
+	for _, want := range []string{`subgraph cluster_0`, `label="node_a"`, `subgraph cluster_1`, `label="node_b"`} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("want %v in graph, got %v", want, dot)
+		}
+	}
+	mermaid, err := clientUtil.FormatGraph(graph, clientUtil.GraphMermaid)
+	if err != nil {
+		t.Fatalf("Format Graph Failure: %v", err)
+	}
+	for _, want := range []string{`  subgraph c0["node_a"]` + "\n", `  subgraph c1["node_b"]` + "\n", "    n2[\"cluster_1\"]\n", "  n0 --> n1\n"} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("want %q in mermaid graph, got %v", want, mermaid)
+		}
+	}
+	d2, err := clientUtil.FormatGraph(graph, clientUtil.GraphD2)
+	if err != nil {
+		t.Fatalf("Format Graph Failure: %v", err)
+	}
+	if want := `"node_a"."RDS/route_1" -> "node_a"."CDS/cluster_1"`; !strings.Contains(d2, want) {
+		t.Errorf("want %v in d2 graph, got %v", want, d2)
+	}
+
+	opts := client.ClientOptions{GraphClient: "node_b", GraphFile: "config_graph_node_b.dot"}
+	defer os.Remove(opts.GraphFile)
+	if err := clientUtil.Visualize(response, true, opts); err != nil {
+		t.Fatalf("Visualization Failure: %v", err)
+	}
+	saved, err := ioutil.ReadFile(opts.GraphFile)
+	if err != nil {
+		t.Fatalf("want the graph saved to %v, got %v", opts.GraphFile, err)
+	}
+	if !strings.Contains(string(saved), `"node_b/CDS/cluster_1"`) || strings.Contains(string(saved), "node_a") || strings.Contains(string(saved), "subgraph") {
+		t.Errorf("want only the graph of node_b, got %v", string(saved))
+	}
+	opts.GraphClient = "node_c"
+	if err := clientUtil.Visualize(response, true, opts); err == nil {
+		t.Errorf("want an error for a client not in the response")
+	}
+}
+
+// TestGraphDetail tests the parts of the resources in the graphs with more detail
+func TestGraphDetail(t *testing.T) {
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := clientUtil.ReadResponseFile("response_for_visualization.json", response); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	graph, err := clientUtil.ParseXdsRelationshipDetail(response, clientUtil.GraphDetailRoutes)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	node := func(id string) *clientUtil.GraphNode {
+		node := graph.Node("test_nodeid/" + id)
+		if node == nil {
+			t.Fatalf("want node %v in graph", id)
+		}
+		return node
+	}
+	// the request to test.example.com/ is traced from the listener to the cluster
+	if want := []string{"address: 0.0.0.0:80"}; !reflect.DeepEqual(node("LDS/test_lds_0").Detail, want) {
+		t.Errorf("want detail %v of the listener, got %v", want, node("LDS/test_lds_0").Detail)
+	}
+	filterChain := node("LDS/test_lds_0/FilterChain/0")
+	if want := []string{"server names: test.example.com", "http filters: envoy.filters.http.fault, envoy.filters.http.router"}; filterChain.Kind != clientUtil.GraphFilterChain || !reflect.DeepEqual(filterChain.Detail, want) {
+		t.Errorf("want filter chain with detail %v, got %v", want, filterChain)
+	}
+	if host := node("RDS/test_rds_0/VirtualHost/test_vhost_1"); !reflect.DeepEqual(host.Detail, []string{"domains: test.example.com"}) {
+		t.Errorf("want the domains of the virtual host, got %v", host.Detail)
+	}
+	if route := node("RDS/test_rds_0/VirtualHost/test_vhost_1/Route/2"); route.Name != "prefix /header" || !reflect.DeepEqual(route.Detail, []string{"header x-canary = true", "cluster from header x-cluster"}) {
+		t.Errorf("want the match and the action of the route, got %v %v", route.Name, route.Detail)
+	}
+	var edges []string
+	for _, edge := range graph.Edges {
+		edges = append(edges, strings.TrimPrefix(edge.From.ID, "test_nodeid/")+"->"+strings.TrimPrefix(edge.To.ID, "test_nodeid/")+" "+edge.Label)
+	}
+	for _, want := range []string{
+		"LDS/test_lds_0->LDS/test_lds_0/FilterChain/0 ",
+		"LDS/test_lds_0/FilterChain/0->RDS/test_rds_0 ",
+		"RDS/test_rds_0->RDS/test_rds_0/VirtualHost/test_vhost_1 ",
+		"RDS/test_rds_0/VirtualHost/test_vhost_1->RDS/test_rds_0/VirtualHost/test_vhost_1/Route/3 ",
+		"RDS/test_rds_0/VirtualHost/test_vhost_1/Route/3->CDS/test_cds_1 ",
+		"RDS/test_rds_1/VirtualHost/test_vhost_2/Route/0->CDS/test_cds_1 100%",
+	} {
+		found := false
+		for _, edge := range edges {
+			found = found || edge == want
+		}
+		if !found {
+			t.Errorf("want edge %v in graph, got %v", want, edges)
+		}
+	}
+	if graph.Node("test_nodeid/EDS/test_cds_0/Endpoint/0/10.0.0.1:8080") != nil {
+		t.Errorf("want no endpoints in the graph of routes")
+	}
+
+	graph, err = clientUtil.ParseXdsRelationshipDetail(response, clientUtil.GraphDetailEndpoints)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	if endpoint := node("EDS/test_cds_0/Endpoint/0/10.0.0.2:8080"); !reflect.DeepEqual(endpoint.Detail, []string{"locality: us-central1/us-central1-a", "health: UNHEALTHY"}) {
+		t.Errorf("want the locality and the health of the endpoint, got %v", endpoint.Detail)
+	}
+	dot, err := clientUtil.GenerateGraph(graph)
+	if err != nil {
+		t.Fatalf("Generate Graph Failure: %v", err)
+	}
+	if want := `label="10.0.0.1:8080\nlocality: us-central1/us-central1-a\nhealth: HEALTHY"`; !strings.Contains(dot, want) {
+		t.Errorf("want %v in graph, got %v", want, dot)
+	}
+
+	// the weighted clusters and the mirrors are labelled
+	js := `{"config": [{"node": {"id": "node_a"}, "xdsConfig": [
+		{"status": "SYNCED", "routeConfig": {"dynamicRouteConfigs": [
+			{"routeConfig": {"@type": "type.googleapis.com/envoy.config.route.v3.RouteConfiguration", "name": "route_1", "virtualHosts": [
+				{"name": "host_1", "domains": ["*"], "routes": [{"name": "canary", "match": {"path": "/api"}, "route": {
+					"weightedClusters": {"clusters": [{"name": "stable", "weight": 80}, {"name": "canary", "weight": 20}]},
+					"requestMirrorPolicies": [{"cluster": "shadow"}]}}]}]}}]}}]}]}`
+	response = &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	graph, err = clientUtil.ParseXdsRelationshipDetail(response, clientUtil.GraphDetailRoutes)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	var labels []string
+	for _, edge := range graph.Edges {
+		if edge.From.Kind == clientUtil.GraphRoute {
+			labels = append(labels, edge.To.Name+" "+edge.Label)
+		}
+	}
+	if want := []string{"stable 80%", "canary 20%", "shadow mirror"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("want labels %v, got %v", want, labels)
+	}
+	if route := graph.Node("node_a/RDS/route_1/VirtualHost/host_1/Route/0"); route == nil || route.Name != "canary" || !reflect.DeepEqual(route.Detail, []string{"match: path /api"}) {
+		t.Errorf("want the route named canary, got %v", route)
+	}
+	mermaid, err := clientUtil.FormatGraph(graph, clientUtil.GraphMermaid)
+	if err != nil {
+		t.Fatalf("Format Graph Failure: %v", err)
+	}
+	if want := `n2 -.-x|"80%"| n3`; !strings.Contains(mermaid, want) {
+		t.Errorf("want %v in mermaid graph, got %v", want, mermaid)
+	}
+
+	// the same address in two localities, and the virtual hosts of the same name in the unnamed
+	// inline route configurations of two filter chains, are not merged
+	js = `{"config": [{"node": {"id": "node_a"}, "xdsConfig": [
+		{"status": "SYNCED", "listenerConfig": {"dynamicListeners": [
+			{"name": "listener_1", "activeState": {"listener": {"@type": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "listener_1",
+				"filterChains": [` + strings.Repeat(`{"filters": [{"name": "envoy.filters.network.http_connection_manager", "typedConfig": {
+					"@type": "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+					"routeConfig": {"virtualHosts": [{"name": "default", "domains": ["*"], "routes": [{"match": {"prefix": "/"}, "route": {"cluster": "cluster_1"}}]}]}}}]},`, 2) + `
+					{"filters": []}]}}}]}},
+		{"status": "SYNCED", "endpointConfig": {"dynamicEndpointConfigs": [
+			{"endpointConfig": {"@type": "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment", "clusterName": "cluster_1", "endpoints": [
+				{"locality": {"zone": "a"}, "lbEndpoints": [{"endpoint": {"address": {"socketAddress": {"address": "10.0.0.1", "portValue": 80}}}}]},
+				{"locality": {"zone": "b"}, "lbEndpoints": [{"endpoint": {"address": {"socketAddress": {"address": "10.0.0.1", "portValue": 80}}}}]}]}}]}}]}]}`
+	response = &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	graph, err = clientUtil.ParseXdsRelationshipDetail(response, clientUtil.GraphDetailEndpoints)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	for _, id := range []string{
+		"RDS/listener_1/inline/VirtualHost/default",
+		"RDS/listener_1/inline_1/VirtualHost/default",
+		"EDS/cluster_1/Endpoint/0/10.0.0.1:80",
+		"EDS/cluster_1/Endpoint/1/10.0.0.1:80",
+	} {
+		if graph.Node("node_a/"+id) == nil {
+			t.Errorf("want node %v in graph", id)
+		}
+	}
+	if endpoint := graph.Node("node_a/EDS/cluster_1/Endpoint/1/10.0.0.1:80"); endpoint != nil && (endpoint.Name != "10.0.0.1:80" || endpoint.Detail[0] != "locality: b") {
+		t.Errorf("want the endpoint of the locality b, got %v %v", endpoint.Name, endpoint.Detail)
+	}
+
+	if _, err := clientUtil.ParseXdsRelationshipDetail(response, "everything"); err == nil {
+		t.Errorf("want an error for an unsupported detail")
+	}
+}
+
+// TestGraphFocus tests focusing the graph on a root resource within a depth
+func TestGraphFocus(t *testing.T) {
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := clientUtil.ReadResponseFile("response_for_visualization.json", response); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	graph, err := clientUtil.ParseXdsRelationship(response)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	tests := []struct {
+		root  string
+		depth int
+		want  []string
+	}{
+		// a cluster shows the listeners and the route configurations leading to it
+		{"cluster:test_cds_1", 0, []string{"LDS/test_lds_0", "RDS/test_rds_0", "RDS/test_rds_1", "CDS/test_cds_1"}},
+		{"cluster:test_cds_0", 1, []string{"RDS/test_rds_0", "RDS/test_inline_rds", "CDS/test_cds_0", "EDS/test_cds_0"}},
+		{"listener:test_lds_0", 1, []string{"LDS/test_lds_0", "RDS/test_rds_0", "RDS/test_rds_1"}},
+		{"listener:test_lds_0", 0, []string{"LDS/test_lds_0", "RDS/test_rds_0", "RDS/test_rds_1", "CDS/test_cds_0", "CDS/test_cds_1", "EDS/test_cds_0"}},
+		{"route:test_inline_rds", 0, []string{"LDS/test_lds_2", "RDS/test_inline_rds", "CDS/test_cds_0", "EDS/test_cds_0"}},
+	}
+	for _, test := range tests {
+		xds, name, err := clientUtil.ParseGraphRoot(test.root)
+		if err != nil {
+			t.Fatalf("Parse graph root %v error: %v", test.root, err)
+		}
+		focused, err := graph.Focus(xds, name, test.depth)
+		if err != nil {
+			t.Fatalf("Focus on %v error: %v", test.root, err)
+		}
+		var nodes []string
+		for _, node := range focused.Nodes {
+			nodes = append(nodes, strings.TrimPrefix(node.ID, "test_nodeid/"))
+		}
+		if !reflect.DeepEqual(nodes, test.want) {
+			t.Errorf("want nodes %v focused on %v within %d, got %v", test.want, test.root, test.depth, nodes)
+		}
+		for _, edge := range focused.Edges {
+			if focused.Node(edge.From.ID) == nil || focused.Node(edge.To.ID) == nil {
+				t.Errorf("want the edges between the focused nodes, got %v->%v", edge.From.ID, edge.To.ID)
+			}
+		}
+	}
+
+	if _, err := graph.Focus("CDS", "test_cds_9", 0); err == nil {
+		t.Errorf("want an error for a root not in the graph")
+	}
+	for _, root := range []string{"pod:foo", "cluster:", "test_cds_0"} {
+		if _, _, err := clientUtil.ParseGraphRoot(root); err == nil {
+			t.Errorf("want an error for the invalid root %v", root)
+		}
+	}
+
+	opts := client.ClientOptions{GraphRoot: "listener:test_lds_1", GraphFile: "config_graph_focus.dot"}
+	defer os.Remove(opts.GraphFile)
+	if err := clientUtil.Visualize(response, true, opts); err != nil {
+		t.Fatalf("Visualization Failure: %v", err)
+	}
+	saved, err := ioutil.ReadFile(opts.GraphFile)
+	if err != nil {
+		t.Fatalf("want the graph saved to %v, got %v", opts.GraphFile, err)
+	}
+	if !strings.Contains(string(saved), `"test_nodeid/LDS/test_lds_1"->"test_nodeid/CDS/test_cds_2"`) || strings.Contains(string(saved), "test_lds_0") {
+		t.Errorf("want only the graph of test_lds_1, got %v", string(saved))
+	}
+}
+
+// TestGraphStates tests styling the nodes by the state of their resources
+func TestGraphStates(t *testing.T) {
+	js := `{"config": [{"node": {"id": "node_a"}, "xdsConfig": [
+		{"status": "SYNCED", "listenerConfig": {"dynamicListeners": [
+			{"name": "listener_1", "errorState": {"details": "bad port", "failedConfiguration": {
+				"@type": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "listener_1", "filterChains": [{"filters": [
+					{"name": "envoy.filters.network.tcp_proxy", "typedConfig": {"@type": "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy", "statPrefix": "tcp", "cluster": "cluster_1"}}]}]}}}]}},
+		{"status": "SYNCED", "clusterConfig": {"dynamicActiveClusters": [
+			{"cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_1"}}]}}]}]}`
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	graph, err := clientUtil.ParseXdsRelationship(response)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	if node := graph.Node("node_a/LDS/listener_1"); node == nil || node.State != "NACKED" || node.Error != "bad port" {
+		t.Fatalf("want the nacked listener with its error, got %v", node)
+	}
+
+	dot, err := clientUtil.GenerateGraph(graph)
+	if err != nil {
+		t.Fatalf("Generate Graph Failure: %v", err)
+	}
+	for _, want := range []string{
+		`"node_a/LDS/listener_1" [ color="#D93025", fillcolor="#D93025", fontcolor="white", fontname=Roboto, label="listener_1\n(nacked)", shape=box, style="filled,rounded", tooltip="node_a/LDS/listener_1\nNACKED\nbad port" ]`,
+		// the link to a cluster in the response is not broken
+		`"node_a/LDS/listener_1"->"node_a/CDS/cluster_1"[ arrowsize=0.3, penwidth=0.3 ]`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("want %v in graph, got %v", want, dot)
+		}
+	}
+	mermaid, err := clientUtil.FormatGraph(graph, clientUtil.GraphMermaid)
+	if err != nil {
+		t.Fatalf("Format Graph Failure: %v", err)
+	}
+	if want := "  style n0 fill:#D93025,stroke:#D93025,color:white\n"; !strings.Contains(mermaid, want) {
+		t.Errorf("want %q in mermaid graph, got %v", want, mermaid)
+	}
+	page, err := clientUtil.GenerateHtml(graph)
+	if err != nil {
+		t.Fatalf("Generate Html Failure: %v", err)
+	}
+	if want := `"error":"bad port"`; !strings.Contains(strings.ReplaceAll(page, " ", ""), strings.ReplaceAll(want, " ", "")) {
+		t.Errorf("want %v embedded in the viewer", want)
+	}
+}
+
+// TestGraphXdsTypes tests the scoped route configurations, and the references to the resources of
+// VHDS, ECDS and SDS, in the graph
+func TestGraphXdsTypes(t *testing.T) {
+	js := `{"config": [{"node": {"id": "node_a"}, "xdsConfig": [
+		{"status": "SYNCED", "listenerConfig": {"dynamicListeners": [
+			{"name": "listener_1", "activeState": {"listener": {"@type": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "listener_1", "filterChains": [{
+				"filters": [{"name": "envoy.filters.network.http_connection_manager", "typedConfig": {
+					"@type": "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+					"scopedRoutes": {"name": "scopes_1", "scopedRds": {"scopedRdsConfigSource": {"ads": {}}}},
+					"httpFilters": [{"name": "envoy.filters.http.rbac", "configDiscovery": {"configSource": {"ads": {}}}}, {"name": "envoy.filters.http.router"}]}}],
+				"transportSocket": {"name": "envoy.transport_sockets.tls", "typedConfig": {
+					"@type": "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext",
+					"commonTlsContext": {"tlsCertificateSdsSecretConfigs": [{"name": "server_cert"}]}}}}]}}},
+			{"name": "listener_2", "activeState": {"listener": {"@type": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "listener_2", "filterChains": [{
+				"filters": [{"name": "envoy.filters.network.http_connection_manager", "typedConfig": {
+					"@type": "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+					"scopedRoutes": {"name": "scopes_2", "scopedRouteConfigurationsList": {"scopedRouteConfigurations": [{"name": "inline_scope", "routeConfigurationName": "route_2"}]}}}}]}]}}}]}},
+		{"status": "SYNCED", "scopedRouteConfig": {"dynamicScopedRouteConfigs": [
+			{"name": "scopes_1", "scopedRouteConfigs": [
+				{"@type": "type.googleapis.com/envoy.config.route.v3.ScopedRouteConfiguration", "name": "scope_1", "routeConfigurationName": "route_1"}]}]}},
+		{"status": "SYNCED", "routeConfig": {"dynamicRouteConfigs": [
+			{"routeConfig": {"@type": "type.googleapis.com/envoy.config.route.v3.RouteConfiguration", "name": "route_1", "vhds": {"configSource": {"ads": {}}}}}]}},
+		{"status": "SYNCED", "clusterConfig": {"dynamicActiveClusters": [
+			{"cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_1", "transportSocket": {"name": "envoy.transport_sockets.tls", "typedConfig": {
+				"@type": "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext",
+				"commonTlsContext": {"validationContextSdsSecretConfig": {"name": "ca"}}}}}}]}}]}]}`
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	graph, err := clientUtil.ParseXdsRelationship(response)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	var nodes, edges []string
+	for _, node := range graph.Nodes {
+		nodes = append(nodes, strings.TrimPrefix(node.ID, "node_a/")+" "+node.State)
+	}
+	for _, edge := range graph.Edges {
+		edges = append(edges, strings.TrimPrefix(edge.From.ID, "node_a/")+"->"+strings.TrimPrefix(edge.To.ID, "node_a/"))
+	}
+	wantNodes := []string{
+		"LDS/listener_1 ACTIVE", "LDS/listener_2 ACTIVE",
+		"ECDS/envoy.filters.http.rbac REFERENCED",
+		"SRDS/scope_1 ACTIVE", "SRDS/inline_scope INLINE",
+		"RDS/route_1 ACTIVE", "RDS/route_2 ",
+		"VHDS/route_1 REFERENCED",
+		"CDS/cluster_1 ACTIVE",
+		"SDS/server_cert REFERENCED", "SDS/ca REFERENCED",
+	}
+	wantEdges := []string{
+		"LDS/listener_1->SDS/server_cert", "LDS/listener_1->SRDS/scope_1", "LDS/listener_1->ECDS/envoy.filters.http.rbac",
+		"LDS/listener_2->SRDS/inline_scope", "SRDS/inline_scope->RDS/route_2",
+		"SRDS/scope_1->RDS/route_1", "RDS/route_1->VHDS/route_1", "CDS/cluster_1->SDS/ca",
+	}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("want nodes %v, got %v", wantNodes, nodes)
+	}
+	if !reflect.DeepEqual(edges, wantEdges) {
+		t.Errorf("want edges %v, got %v", wantEdges, edges)
+	}
+
+	// the resources the response has no config dump of are hollow, and the links to them are not broken
+	dot, err := clientUtil.GenerateGraph(graph)
+	if err != nil {
+		t.Fatalf("Generate Graph Failure: %v", err)
+	}
+	for _, want := range []string{
+		`"node_a/SDS/ca" [ color="#80868B", fontcolor="#80868B", fontname=Roboto, label="ca\n(referenced)", shape=box, style="rounded"`,
+		`"node_a/CDS/cluster_1"->"node_a/SDS/ca"[ arrowsize=0.3, penwidth=0.3 ]`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("want %v in graph, got %v", want, dot)
+		}
+	}
+}
+
+// TestGraphGenericXdsConfigs tests the graph of a response that only has generic xds configs, whose
+// resources of ECDS, VHDS, SDS and RTDS are drawn rather than referenced
+func TestGraphGenericXdsConfigs(t *testing.T) {
+	js := `{"config": [{"node": {"id": "node_a"}, "genericXdsConfigs": [
+		{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "listener_1", "versionInfo": "v1", "configStatus": "SYNCED", "clientStatus": "ACKED",
+			"xdsConfig": {"@type": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "listener_1", "filterChains": [{
+				"filters": [{"name": "envoy.filters.network.http_connection_manager", "typedConfig": {
+					"@type": "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+					"rds": {"routeConfigName": "route_1", "configSource": {"ads": {}}},
+					"httpFilters": [{"name": "envoy.filters.http.rbac", "configDiscovery": {"configSource": {"ads": {}}}}, {"name": "envoy.filters.http.router"}]}}],
+				"transportSocket": {"name": "envoy.transport_sockets.tls", "typedConfig": {
+					"@type": "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext",
+					"commonTlsContext": {"tlsCertificateSdsSecretConfigs": [{"name": "server_cert"}]}}}}]}},
+		{"typeUrl": "type.googleapis.com/envoy.config.core.v3.TypedExtensionConfig", "name": "envoy.filters.http.rbac", "configStatus": "SYNCED", "clientStatus": "ACKED",
+			"xdsConfig": {"@type": "type.googleapis.com/envoy.config.core.v3.TypedExtensionConfig", "name": "envoy.filters.http.rbac"}},
+		{"typeUrl": "type.googleapis.com/envoy.config.route.v3.RouteConfiguration", "name": "route_1", "configStatus": "SYNCED", "clientStatus": "ACKED",
+			"xdsConfig": {"@type": "type.googleapis.com/envoy.config.route.v3.RouteConfiguration", "name": "route_1", "vhds": {"configSource": {"ads": {}}},
+				"virtualHosts": [{"name": "local", "domains": ["*"], "routes": [{"match": {"prefix": "/"}, "route": {"cluster": "cluster_1"}}]}]}},
+		{"typeUrl": "type.googleapis.com/envoy.config.route.v3.VirtualHost", "name": "route_1/foo.com", "configStatus": "SYNCED", "clientStatus": "ACKED",
+			"xdsConfig": {"@type": "type.googleapis.com/envoy.config.route.v3.VirtualHost", "name": "route_1/foo.com", "domains": ["foo.com"],
+				"routes": [{"match": {"prefix": "/"}, "route": {"cluster": "cluster_2"}}]}},
+		{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_1", "versionInfo": "v1", "lastUpdated": "2021-01-01T00:00:00Z", "configStatus": "SYNCED", "clientStatus": "ACKED",
+			"xdsConfig": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_1", "type": "EDS"}},
+		{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_2", "versionInfo": "v2", "lastUpdated": "2021-01-02T00:00:00Z", "configStatus": "ERROR", "clientStatus": "NACKED",
+			"errorState": {"details": "bad lb policy", "failedConfiguration": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_2"}}},
+		{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_3", "clientStatus": "REQUESTED"},
+		{"typeUrl": "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment", "name": "cluster_1", "configStatus": "SYNCED", "clientStatus": "ACKED",
+			"xdsConfig": {"@type": "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment", "clusterName": "cluster_1"}},
+		{"typeUrl": "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.Secret", "name": "server_cert", "configStatus": "SYNCED", "clientStatus": "ACKED",
+			"xdsConfig": {"@type": "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.Secret", "name": "server_cert"}},
+		{"typeUrl": "type.googleapis.com/envoy.service.runtime.v3.Runtime", "name": "rtds_layer", "configStatus": "SYNCED", "clientStatus": "ACKED",
+			"xdsConfig": {"@type": "type.googleapis.com/envoy.service.runtime.v3.Runtime", "name": "rtds_layer", "layer": {"feature.enabled": true}}},
+		{"typeUrl": "type.googleapis.com/envoy.config.unknown.v3.Unknown", "name": "unknown", "clientStatus": "ACKED"}]}]}`
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+
+	// the xds config of a type takes the most notable status and the latest version of its resources
+	snapshot, err := clientUtil.NewSnapshot(response, time.Time{})
+	if err != nil {
+		t.Fatalf("Take snapshot error: %v", err)
+	}
+	cds := snapshot.Clients[0].XdsConfig("CDS")
+	if cds == nil || cds.Status != "ERROR" || cds.Version != "v2" || len(cds.Resources) != 3 {
+		t.Fatalf("want CDS with status ERROR, version v2 and 3 clusters, got %+v", cds)
+	}
+	if r := cds.Resource("cluster_2"); r.State != "NACKED" || r.Error != "bad lb policy" || r.Config == nil {
+		t.Errorf("want the nacked cluster_2 with its error and failed config, got %+v", r)
+	}
+	if lds := snapshot.Clients[0].XdsConfig("LDS"); lds == nil || lds.Status != "SYNCED" || lds.Version != "v1" {
+		t.Errorf("want LDS with status SYNCED and version v1, got %+v", lds)
+	}
+
+	graph, err := clientUtil.ParseXdsRelationship(response)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	var nodes, edges []string
+	for _, node := range graph.Nodes {
+		nodes = append(nodes, strings.TrimPrefix(node.ID, "node_a/")+" "+node.State)
+	}
+	for _, edge := range graph.Edges {
+		edges = append(edges, strings.TrimPrefix(edge.From.ID, "node_a/")+"->"+strings.TrimPrefix(edge.To.ID, "node_a/"))
+	}
+	wantNodes := []string{
+		"LDS/listener_1 ACTIVE",
+		"ECDS/envoy.filters.http.rbac ACTIVE",
+		"RDS/route_1 ACTIVE",
+		"VHDS/route_1/foo.com ACTIVE",
+		"CDS/cluster_1 ACTIVE", "CDS/cluster_2 NACKED", "CDS/cluster_3 REQUESTED",
+		"EDS/cluster_1 ACTIVE",
+		"SDS/server_cert ACTIVE",
+		"RTDS/rtds_layer ACTIVE",
+	}
+	wantEdges := []string{
+		"LDS/listener_1->SDS/server_cert", "LDS/listener_1->RDS/route_1", "LDS/listener_1->ECDS/envoy.filters.http.rbac",
+		"RDS/route_1->CDS/cluster_1", "RDS/route_1->VHDS/route_1/foo.com", "VHDS/route_1/foo.com->CDS/cluster_2",
+		"CDS/cluster_1->EDS/cluster_1",
+	}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("want nodes %v, got %v", wantNodes, nodes)
+	}
+	if !reflect.DeepEqual(edges, wantEdges) {
+		t.Errorf("want edges %v, got %v", wantEdges, edges)
+	}
+
+	// the runtime layers have a column and a color of their own
+	dot, err := clientUtil.GenerateGraph(graph)
+	if err != nil {
+		t.Fatalf("Generate Graph Failure: %v", err)
+	}
+	if want := `"node_a/RTDS/rtds_layer" [ color="#E8710A"`; !strings.Contains(dot, want) {
+		t.Errorf("want %v in graph, got %v", want, dot)
+	}
+}
+
+// TestGraphDiff tests drawing the changes of the graph between two responses with graph-diff
+func TestGraphDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graphdiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the new response renames test_cds_1, which the route configurations refer to
+	data, err := ioutil.ReadFile("response_for_visualization.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFile := filepath.Join(dir, "new.json")
+	if err := ioutil.WriteFile(newFile, []byte(strings.ReplaceAll(string(data), "test_cds_1", "test_cds_3")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parse := func(path string) *clientUtil.Graph {
+		response := &csdspb_v3.ClientStatusResponse{}
+		if err := clientUtil.ReadResponseFile(path, response); err != nil {
+			t.Fatalf("Read From File Failure: %v", err)
+		}
+		graph, err := clientUtil.ParseXdsRelationship(response)
+		if err != nil {
+			t.Fatalf("Parse Xds Relationship Failure: %v", err)
+		}
+		return graph
+	}
+	old := parse("response_for_visualization.json")
+	graph := clientUtil.DiffGraphs(old, parse(newFile))
+
+	var nodes, edges []string
+	for _, node := range graph.Nodes {
+		if node.Diff != "" {
+			nodes = append(nodes, strings.TrimPrefix(node.ID, "test_nodeid/")+" "+node.Diff)
+		}
+	}
+	for _, edge := range graph.Edges {
+		if edge.Diff != "" {
+			edges = append(edges, strings.TrimPrefix(edge.From.ID, "test_nodeid/")+"->"+strings.TrimPrefix(edge.To.ID, "test_nodeid/")+" "+edge.Diff)
+		}
+	}
+	wantNodes := []string{"RDS/test_rds_0 CHANGED", "RDS/test_rds_1 CHANGED", "CDS/test_cds_3 ADDED", "CDS/test_cds_1 REMOVED"}
+	wantEdges := []string{
+		"RDS/test_rds_0->CDS/test_cds_3 ADDED", "RDS/test_rds_1->CDS/test_cds_3 ADDED",
+		"RDS/test_rds_0->CDS/test_cds_1 REMOVED", "RDS/test_rds_1->CDS/test_cds_1 REMOVED",
+	}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("want changed nodes %v, got %v", wantNodes, nodes)
+	}
+	if !reflect.DeepEqual(edges, wantEdges) {
+		t.Errorf("want changed edges %v, got %v", wantEdges, edges)
+	}
+	if changes := graph.Node("test_nodeid/RDS/test_rds_1").Changes; len(changes) != 1 || !strings.HasSuffix(changes[0], ".name") {
+		t.Errorf("want the name of the weighted cluster changed in test_rds_1, got %v", changes)
+	}
+	// the graphs themselves are not marked
+	if node := old.Node("test_nodeid/CDS/test_cds_1"); node.Diff != "" {
+		t.Errorf("want the old graph unchanged, got %v", node.Diff)
+	}
+
+	dot, err := clientUtil.GenerateGraph(graph)
+	if err != nil {
+		t.Fatalf("Generate Graph Failure: %v", err)
+	}
+	for _, want := range []string{
+		`"test_nodeid/CDS/test_cds_3" [ color="#188038", fillcolor="#188038", fontcolor="white", fontname=Roboto, label="test_cds_3\n(added)"`,
+		`"test_nodeid/CDS/test_cds_1" [ color="#D93025", fillcolor="#D93025", fontcolor="white", fontname=Roboto, label="test_cds_1\n(removed)"`,
+		`"test_nodeid/RDS/test_rds_0" [ color="#F29900", fillcolor="#EA4335", fontcolor="white", fontname=Roboto, label="test_rds_0\n(changed)", penwidth=3`,
+		`"test_nodeid/RDS/test_rds_0"->"test_nodeid/CDS/test_cds_1"[ arrowsize=0.3, color="#D93025", penwidth=1.5, style=dashed ]`,
+		`"test_nodeid/RDS/test_rds_0"->"test_nodeid/CDS/test_cds_0"[ arrowsize=0.3, penwidth=0.3 ]`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("want %v in graph, got %v", want, dot)
+		}
+	}
+
+	// graph-diff of two files draws the diff of the responses in them
+	graphFile := filepath.Join(dir, "diff.mmd")
+	c, err := New(client.ClientOptions{
+		Platform:      "gcp",
+		InputFile:     newFile,
+		ConfigFile:    filepath.Join(dir, "config.json"),
+		Visualization: clientUtil.VisualizationGraph,
+		GraphFormat:   clientUtil.GraphMermaid,
+		GraphFile:     graphFile,
+		GraphDiff:     true,
+		GraphDiffFile: "response_for_visualization.json",
+	})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run graph-diff error: %v", err)
+		}
+	})
+	saved, err := ioutil.ReadFile(graphFile)
+	if err != nil {
+		t.Fatalf("want the graph diff saved to %v, got %v", graphFile, err)
+	}
+	for _, want := range []string{`["test_cds_3<br/>(added)"]`, `["test_cds_1<br/>(removed)"]`, "stroke:#188038,stroke-width:2px\n"} {
+		if !strings.Contains(string(saved), want) {
+			t.Errorf("want %q in the graph diff, got %v", want, string(saved))
+		}
+	}
+
+	// in monitor mode, the first response is drawn as is and the next ones are diffed
+	m, err := clientUtil.NewMonitor(client.ClientOptions{GraphDiff: true, Visualization: clientUtil.VisualizationGraph, GraphFormat: clientUtil.GraphJson, GraphFile: graphFile, MonitorInterval: time.Second})
+	if err != nil {
+		t.Fatalf("New monitor error: %v", err)
+	}
+	for i, path := range []string{"response_for_visualization.json", newFile} {
+		response := &csdspb_v3.ClientStatusResponse{}
+		if err := clientUtil.ReadResponseFile(path, response); err != nil {
+			t.Fatalf("Read From File Failure: %v", err)
+		}
+		clientUtil.CaptureOutput(func() {
+			if err := m.Process(response, func() error { return nil }); err != nil {
+				t.Errorf("Process response error: %v", err)
+			}
+		})
+		saved, err := ioutil.ReadFile(graphFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diffed := strings.Contains(string(saved), `"diff": "REMOVED"`); diffed != (i == 1) {
+			t.Errorf("want the response %d diffed %v, got %v", i, i == 1, string(saved))
+		}
+	}
+}
+
+// TestGraphTree tests printing the graph as a tree in the tree visualization mode
+func TestGraphTree(t *testing.T) {
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := clientUtil.ReadResponseFile("response_for_visualization.json", response); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := clientUtil.Visualize(response, false, client.ClientOptions{Visualization: clientUtil.VisualizationTree}); err != nil {
+			t.Errorf("Visualization Failure: %v", err)
+		}
+	})
+	want := "Config tree:\n" +
+		"test_nodeid\n" +
+		"├── LDS test_lds_0 ACTIVE (address: 0.0.0.0:80)\n" +
+		"│   ├── RDS test_rds_0 ACTIVE [server names: test.example.com, http filters: envoy.filters.http.fault, envoy.filters.http.router]\n" +
+		"│   │   ├── VirtualHost test_vhost_0 (domains: *)\n" +
+		"│   │   │   └── CDS test_cds_0 ACTIVE [prefix /]\n" +
+		"│   │   │       └── EDS test_cds_0 STATIC\n" +
+		"│   │   │           ├── Endpoint 10.0.0.1:8080 (locality: us-central1/us-central1-a, health: HEALTHY)\n" +
+		"│   │   │           └── Endpoint 10.0.0.2:8080 (locality: us-central1/us-central1-a, health: UNHEALTHY)\n" +
+		"│   │   └── VirtualHost test_vhost_1 (domains: test.example.com)\n" +
+		"│   │       └── CDS test_cds_1 ACTIVE [prefix /]\n" +
+		"│   └── RDS test_rds_1 ACTIVE\n" +
+		"│       └── VirtualHost test_vhost_2 (domains: *)\n" +
+		"│           └── CDS test_cds_1 ACTIVE [prefix /, 100%]\n" +
+		"├── LDS test_lds_1 WARMING\n" +
+		"│   └── CDS test_cds_2 MISSING\n" +
+		"└── LDS test_lds_2 DRAINING\n" +
+		"    └── RDS test_inline_rds INLINE\n" +
+		"        └── VirtualHost test_vhost_3 (domains: *)\n" +
+		"            └── CDS test_cds_0 ACTIVE [prefix /]\n" +
+		"                └── EDS test_cds_0 STATIC\n" +
+		"                    ├── Endpoint 10.0.0.1:8080 (locality: us-central1/us-central1-a, health: HEALTHY)\n" +
+		"                    └── Endpoint 10.0.0.2:8080 (locality: us-central1/us-central1-a, health: UNHEALTHY)\n"
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	// the tree is focused on -graph_root as the graph is
+	out = clientUtil.CaptureOutput(func() {
+		if err := clientUtil.Visualize(response, false, client.ClientOptions{Visualization: clientUtil.VisualizationTree, GraphRoot: "cluster:test_cds_2"}); err != nil {
+			t.Errorf("Visualization Failure: %v", err)
+		}
+	})
+	want = "Config tree:\n" +
+		"test_nodeid\n" +
+		"└── LDS test_lds_1 WARMING\n" +
+		"    └── CDS test_cds_2 MISSING\n"
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	// the states are colored in a terminal
+	graph, err := clientUtil.ParseXdsRelationship(response)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	if tree := clientUtil.GenerateTree(graph, true); !strings.Contains(tree, "CDS test_cds_2 \x1b[31mMISSING\x1b[0m\n") {
+		t.Errorf("want the missing cluster in red, got\n%v", tree)
+	}
+}
+
+// TestGraphDuplicateClients tests drawing the resources of the clients with the same node id separately
+func TestGraphDuplicateClients(t *testing.T) {
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := clientUtil.ReadResponseFile("response_for_visualization.json", response); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	response.Config = append(response.Config, response.Config[0])
+	graph, err := clientUtil.ParseXdsRelationship(response)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	if want := []string{"test_nodeid", "test_nodeid#2"}; !reflect.DeepEqual(graph.Clients, want) {
+		t.Errorf("want clients %v, got %v", want, graph.Clients)
+	}
+	nodes := make(map[string]int)
+	for _, node := range graph.Nodes {
+		nodes[node.Client]++
+	}
+	if nodes["test_nodeid"] == 0 || nodes["test_nodeid"] != nodes["test_nodeid#2"] || len(graph.Nodes) != 2*nodes["test_nodeid"] {
+		t.Errorf("want the resources of each client once, got %v of %d nodes", nodes, len(graph.Nodes))
+	}
+
+	tree := clientUtil.GenerateTree(graph.ForClient("test_nodeid#2"), false)
+	if !strings.HasPrefix(tree, "test_nodeid#2\n") || strings.Count(tree, "LDS test_lds_0 ") != 1 {
+		t.Errorf("want the resources of the second client once, got\n%v", tree)
+	}
+}
+
+// TestGraphWarmingListener tests drawing the references of the active config of a listener that
+// also has a warming one
+func TestGraphWarmingListener(t *testing.T) {
+	listener := func(route string) string {
+		return `{"listener": {"@type": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "listener_1",
+			"filterChains": [{"filters": [{"name": "envoy.filters.network.http_connection_manager", "typedConfig": {
+				"@type": "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+				"rds": {"routeConfigName": "` + route + `"}}}]}]}}`
+	}
+	js := `{"config": [{"node": {"id": "node_a"}, "xdsConfig": [
+		{"status": "SYNCED", "listenerConfig": {"dynamicListeners": [
+			{"name": "listener_1", "activeState": ` + listener("route_active") + `, "warmingState": ` + listener("route_warming") + `}]}}]}]}`
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	graph, err := clientUtil.ParseXdsRelationship(response)
+	if err != nil {
+		t.Fatalf("Parse Xds Relationship Failure: %v", err)
+	}
+	var edges []string
+	for _, edge := range graph.Edges {
+		edges = append(edges, fmt.Sprintf("%v %v -> %v", edge.From.Name, edge.From.State, edge.To.Name))
+	}
+	if want := "listener_1 WARMING -> route_active"; strings.Join(edges, "\n") != want {
+		t.Errorf("want\n%v\nout\n%v", want, strings.Join(edges, "\n"))
+	}
+}
+
+// TestRenderGraph tests rendering a graph with the dot command of Graphviz
+func TestRenderGraph(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+
+	os.Setenv("PATH", dir)
+	if _, err := clientUtil.RenderGraph(filepath.Join(dir, "graph.dot"), "svg"); err == nil || !strings.Contains(err.Error(), "not installed") {
+		t.Errorf("want an error without Graphviz, got %v", err)
+	}
+
+	// a fake dot command that writes its arguments to the image
+	if err := ioutil.WriteFile(filepath.Join(dir, "dot"), []byte("#!/bin/sh\necho \"$@\" > \"$3\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	image, err := clientUtil.RenderGraph(filepath.Join(dir, "graph.dot"), "svg")
+	if err != nil {
+		t.Fatalf("Render Graph Failure: %v", err)
+	}
+	if want := filepath.Join(dir, "graph.svg"); image != want {
+		t.Errorf("want image %v, got %v", want, image)
+	}
+	args, err := ioutil.ReadFile(image)
+	if want := "-Tsvg -o " + image + " " + filepath.Join(dir, "graph.dot") + "\n"; err != nil || string(args) != want {
+		t.Errorf("want dot called with %v, got %v %v", want, string(args), err)
+	}
+}
+
+// TestWatchDiff tests printing only the changes between successive responses with -watch_diff.
+func TestWatchDiff(t *testing.T) {
+	responses := []string{
+		`{"config": [
+			{"node": {"id": "node_a"}, "xdsConfig": [
+				{"status": "SYNCED", "listenerConfig": {"versionInfo": "1", "dynamicListeners": [
+					{"name": "listener_1", "activeState": {"versionInfo": "1", "listener": {"@type": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "listener_1", "address": {"socketAddress": {"address": "0.0.0.0", "portValue": 80}}}}}]}},
+				{"status": "SYNCED", "clusterConfig": {"versionInfo": "1", "dynamicActiveClusters": [
+					{"versionInfo": "1", "cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_1"}}]}}]},
+			{"node": {"id": "node_b"}}]}`,
+		`{"config": [
+			{"node": {"id": "node_a"}, "xdsConfig": [
+				{"status": "STALE", "listenerConfig": {"versionInfo": "2", "dynamicListeners": [
+					{"name": "listener_1", "activeState": {"versionInfo": "2", "listener": {"@type": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "listener_1", "address": {"socketAddress": {"address": "0.0.0.0", "portValue": 8080}}}}}]}},
+				{"status": "SYNCED", "clusterConfig": {"versionInfo": "1", "dynamicWarmingClusters": [
+					{"versionInfo": "1", "cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_2"}}]}}]},
+			{"node": {"id": "node_c"}}]}`,
+	}
+	m, err := clientUtil.NewMonitor(client.ClientOptions{WatchDiff: true})
+	if err != nil {
+		t.Fatalf("New monitor error: %v", err)
+	}
+	var outs []string
+	for _, js := range responses {
+		response := &csdspb_v3.ClientStatusResponse{}
+		if err := protojson.Unmarshal([]byte(js), response); err != nil {
+			t.Fatalf("Parse response error: %v", err)
+		}
+		outs = append(outs, clientUtil.CaptureOutput(func() {
+			if err := m.Process(response, func() error {
+				return printOutResponse(response, client.ClientOptions{})
+			}); err != nil {
+				t.Errorf("Process response error: %v", err)
+			}
+		}))
+	}
+	if !strings.HasPrefix(outs[0], "Client ID   xDS stream type   Config Status\nnode_a") {
+		t.Errorf("the first response is not printed in full:\n%v", outs[0])
+	}
+	lines := strings.SplitN(outs[1], "\n", 2)
+	if !strings.HasSuffix(lines[0], "] 8 change(s)") {
+		t.Errorf("unexpected header of changes: %v", lines[0])
+	}
+	want := "- client node_b disconnected\n" +
+		"~ node_a LDS status: SYNCED -> STALE\n" +
+		"~ node_a LDS version: 1 -> 2\n" +
+		"~ node_a LDS listener_1 version: 1 -> 2\n" +
+		"~ node_a LDS listener_1 changed:\n" +
+		"    address.socketAddress.portValue: 80 -> 8080\n" +
+		"- node_a CDS cluster_1 removed\n" +
+		"+ node_a CDS cluster_2 added (WARMING)\n" +
+		"+ client node_c connected\n"
+	if lines[1] != want {
+		t.Errorf("want\n%vout\n%v", want, lines[1])
+	}
+}
+
+// TestDiffDuplicateClients tests diffing the clients with the same or empty node ids separately.
+func TestDiffDuplicateClients(t *testing.T) {
+	responses := []string{
+		`{"config": [
+			{"node": {"id": "node_a"}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {"versionInfo": "1"}}]},
+			{"node": {"id": "node_a"}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {"versionInfo": "1"}}]},
+			{"node": {}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {"versionInfo": "1"}}]}]}`,
+		`{"config": [
+			{"node": {"id": "node_a"}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {"versionInfo": "1"}}]},
+			{"node": {"id": "node_a"}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {"versionInfo": "2"}}]},
+			{"node": {}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {"versionInfo": "1"}}]}]}`,
+	}
+	var snapshots []*clientUtil.Snapshot
+	for _, js := range responses {
+		response := &csdspb_v3.ClientStatusResponse{}
+		if err := protojson.Unmarshal([]byte(js), response); err != nil {
+			t.Fatalf("Parse response error: %v", err)
+		}
+		snapshot, err := clientUtil.NewSnapshot(response, time.Now())
+		if err != nil {
+			t.Fatalf("New snapshot error: %v", err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	var ids []string
+	for _, c := range snapshots[0].Clients {
+		ids = append(ids, c.ID)
+	}
+	if want := "node_a,node_a#2,#3"; strings.Join(ids, ",") != want {
+		t.Errorf("want client ids %v, got %v", want, strings.Join(ids, ","))
+	}
+	var changes []string
+	for _, change := range clientUtil.DiffSnapshots(snapshots[0], snapshots[1]) {
+		changes = append(changes, change.String())
+	}
+	if want := "~ node_a#2 CDS version: 1 -> 2"; strings.Join(changes, "\n") != want {
+		t.Errorf("want\n%v\nout\n%v", want, strings.Join(changes, "\n"))
+	}
+}
+
+// TestEventLog tests appending the status transitions between successive responses to -event_log.
+func TestEventLog(t *testing.T) {
+	responses := []string{
+		`{"config": [
+			{"node": {"id": "node_a"}, "xdsConfig": [
+				{"status": "SYNCED", "clusterConfig": {"versionInfo": "1", "dynamicActiveClusters": [
+					{"versionInfo": "1", "cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_1"}}]}}]}]}`,
+		`{"config": [
+			{"node": {"id": "node_a"}, "xdsConfig": [
+				{"status": "STALE", "clusterConfig": {"versionInfo": "2", "dynamicActiveClusters": [
+					{"versionInfo": "2", "cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_1"}}]}}]}]}`,
+		`{}`,
+	}
+	os.Remove("test_event_log.jsonl")
+	m, err := clientUtil.NewMonitor(client.ClientOptions{EventLog: "test_event_log.jsonl"})
+	if err != nil {
+		t.Fatalf("New monitor error: %v", err)
+	}
+	for _, js := range responses {
+		response := &csdspb_v3.ClientStatusResponse{}
+		if err := protojson.Unmarshal([]byte(js), response); err != nil {
+			t.Fatalf("Parse response error: %v", err)
+		}
+		if err := m.Process(response, func() error { return nil }); err != nil {
+			t.Errorf("Process response error: %v", err)
+		}
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("Close monitor error: %v", err)
+	}
+
+	data, err := ioutil.ReadFile("test_event_log.jsonl")
+	if err != nil {
+		t.Fatalf("Read event log error: %v", err)
+	}
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var event clientUtil.Event
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Parse event error: %v", err)
+		}
+		if event.Time.IsZero() {
+			t.Errorf("event has no time: %v", line)
+		}
+		events = append(events, strings.Join([]string{event.Kind, event.NodeID, event.Xds, event.Resource, event.Old, event.New, strconv.FormatBool(event.Initial)}, ","))
+	}
+	// the events of the first response are the initial state of the clients
+	want := []string{
+		"CLIENT_CONNECTED,node_a,,,,,true",
+		"STATUS_CHANGED,node_a,CDS,,,SYNCED,true",
+		"VERSION_CHANGED,node_a,CDS,,,1,true",
+		"RESOURCE_ADDED,node_a,CDS,cluster_1,,ACTIVE,true",
+		"STATUS_CHANGED,node_a,CDS,,SYNCED,STALE,false",
+		"VERSION_CHANGED,node_a,CDS,,1,2,false",
+		"RESOURCE_VERSION_CHANGED,node_a,CDS,cluster_1,1,2,false",
+		"CLIENT_DISCONNECTED,node_a,,,,,false",
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want\n%v\nout\n%v", strings.Join(want, "\n"), strings.Join(events, "\n"))
+	}
+}
+
+// TestAlertRules tests firing and resolving alerts in monitor mode and the exit code in one-shot mode.
+func TestAlertRules(t *testing.T) {
+	rules := `rules:
+- name: nacked_clients
+  condition: nacked
+  exit_code: 4
+- name: no_clients
+  condition: no_clients
+command: cat >> test_alerts.jsonl && echo >> test_alerts.jsonl
+`
+	if err := ioutil.WriteFile("test_alert_rules.yaml", []byte(rules), 0644); err != nil {
+		t.Fatalf("Write alert rules error: %v", err)
+	}
+	os.Remove("test_alerts.jsonl")
+	responses := []string{
+		`{"config": [{"node": {"id": "node_a"}, "xdsConfig": [{"status": "ERROR", "clusterConfig": {}}]}]}`,
+		`{"config": [{"node": {"id": "node_a"}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {}}]}]}`,
+	}
+	process := func(m *clientUtil.Monitor, js string) error {
+		response := &csdspb_v3.ClientStatusResponse{}
+		if err := protojson.Unmarshal([]byte(js), response); err != nil {
+			t.Fatalf("Parse response error: %v", err)
+		}
+		var err error
+		clientUtil.CaptureOutput(func() {
+			err = m.Process(response, func() error { return nil })
+		})
+		return err
+	}
+
+	m, err := clientUtil.NewMonitor(client.ClientOptions{AlertRules: "test_alert_rules.yaml", MonitorInterval: time.Second})
+	if err != nil {
+		t.Fatalf("New monitor error: %v", err)
+	}
+	for _, js := range responses {
+		if err := process(m, js); err != nil {
+			t.Errorf("Process response error: %v", err)
+		}
+	}
+	data, err := ioutil.ReadFile("test_alerts.jsonl")
+	if err != nil {
+		t.Fatalf("Read alerts error: %v", err)
+	}
+	var states []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var alert clientUtil.Alert
+		if err := json.Unmarshal([]byte(line), &alert); err != nil {
+			t.Fatalf("Parse alert error: %v", err)
+		}
+		states = append(states, alert.Rule+" "+alert.State+" "+strings.Join(alert.Clients, ","))
+	}
+	want := "nacked_clients firing node_a\nnacked_clients resolved "
+	if strings.Join(states, "\n") != want {
+		t.Errorf("want\n%v\nout\n%v", want, strings.Join(states, "\n"))
+	}
+
+	// one-shot mode
+	m, err = clientUtil.NewMonitor(client.ClientOptions{AlertRules: "test_alert_rules.yaml"})
+	if err != nil {
+		t.Fatalf("New monitor error: %v", err)
+	}
+	err = process(m, responses[0])
+	var exitErr *client.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 4 {
+		t.Errorf("want exit code 4, got error: %v", err)
+	}
+}
+
+// TestAlertRulesPerClient tests tracking how long each client violates a rule with a duration.
+func TestAlertRulesPerClient(t *testing.T) {
+	if err := ioutil.WriteFile("test_alert_rules.yaml", []byte("rules:\n- name: nacked_for_2m\n  condition: nacked\n  for: 2m\n"), 0644); err != nil {
+		t.Fatalf("Write alert rules error: %v", err)
+	}
+	rules, err := clientUtil.ParseAlertRules("test_alert_rules.yaml")
+	if err != nil {
+		t.Fatalf("Parse alert rules error: %v", err)
+	}
+	nacked := `{"node": {"id": "%v"}, "xdsConfig": [{"status": "ERROR", "clusterConfig": {}}]}`
+	synced := `{"node": {"id": "%v"}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {}}]}`
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	var states []string
+	// node_a and node_b nack in turn for a minute each, then node_b keeps nacking
+	for i, clients := range [][2]string{{nacked, synced}, {synced, nacked}, {nacked, synced}, {synced, nacked}, {synced, nacked}, {synced, nacked}} {
+		js := `{"config": [` + fmt.Sprintf(clients[0], "node_a") + "," + fmt.Sprintf(clients[1], "node_b") + `]}`
+		response := &csdspb_v3.ClientStatusResponse{}
+		if err := protojson.Unmarshal([]byte(js), response); err != nil {
+			t.Fatalf("Parse response error: %v", err)
+		}
+		snapshot, err := clientUtil.NewSnapshot(response, start.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatalf("New snapshot error: %v", err)
+		}
+		for _, alert := range rules.Evaluate(snapshot, nil, false) {
+			states = append(states, fmt.Sprintf("%d %v %v", i, alert.State, strings.Join(alert.Clients, ",")))
+		}
+	}
+	if want := "5 firing node_b"; strings.Join(states, "\n") != want {
+		t.Errorf("want\n%v\nout\n%v", want, strings.Join(states, "\n"))
+	}
+
+	// node_b nacks while the alert of node_a is firing, and node_b, then node_a disappear
+	rulesYaml := "rules:\n- name: nacked\n  condition: nacked\n- name: disappeared\n  condition: client_disappeared\n"
+	if err := ioutil.WriteFile("test_alert_rules.yaml", []byte(rulesYaml), 0644); err != nil {
+		t.Fatalf("Write alert rules error: %v", err)
+	}
+	if rules, err = clientUtil.ParseAlertRules("test_alert_rules.yaml"); err != nil {
+		t.Fatalf("Parse alert rules error: %v", err)
+	}
+	seen := make(map[string]bool)
+	states = nil
+	for i, clients := range [][]string{
+		{fmt.Sprintf(nacked, "node_a"), fmt.Sprintf(synced, "node_b")},
+		{fmt.Sprintf(nacked, "node_a"), fmt.Sprintf(nacked, "node_b")},
+		{fmt.Sprintf(synced, "node_a")},
+		{fmt.Sprintf(synced, "node_a")},
+		{},
+		{},
+	} {
+		response := &csdspb_v3.ClientStatusResponse{}
+		if err := protojson.Unmarshal([]byte(`{"config": [`+strings.Join(clients, ",")+`]}`), response); err != nil {
+			t.Fatalf("Parse response error: %v", err)
+		}
+		snapshot, err := clientUtil.NewSnapshot(response, start.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatalf("New snapshot error: %v", err)
+		}
+		for _, alert := range rules.Evaluate(snapshot, seen, false) {
+			states = append(states, fmt.Sprintf("%d %v %v %v", i, alert.Rule, alert.State, strings.Join(alert.Clients, ",")))
+		}
+		for _, c := range snapshot.Clients {
+			seen[c.ID] = true
+		}
+	}
+	want := []string{
+		"0 nacked firing node_a",
+		"1 nacked firing node_b",
+		"2 nacked resolved ",
+		"2 disappeared firing node_b",
+		"3 disappeared resolved ",
+		"4 disappeared firing node_a",
+		"5 disappeared resolved ",
+	}
+	if strings.Join(states, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want\n%v\nout\n%v", strings.Join(want, "\n"), strings.Join(states, "\n"))
+	}
+}
+
+// TestMetrics tests exporting the metrics of the clients and the requests with -metrics_addr.
+func TestMetrics(t *testing.T) {
+	js := `{"config": [
+		{"node": {"id": "node_a", "metadata": {"XDS_STREAM_TYPE": "ADS"}}, "xdsConfig": [
+			{"status": "SYNCED", "listenerConfig": {"versionInfo": "1", "dynamicListeners": [
+				{"name": "listener_1", "errorState": {"details": "rejected"}}]}},
+			{"status": "STALE", "clusterConfig": {"versionInfo": "1"}}]},
+		{"node": {"id": "node_b", "metadata": {"XDS_STREAM_TYPE": "ADS"}}, "xdsConfig": [
+			{"status": "SYNCED", "clusterConfig": {"versionInfo": "2"}}]}]}`
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	snapshot, err := clientUtil.NewSnapshot(response, now)
+	if err != nil {
+		t.Fatalf("New snapshot error: %v", err)
+	}
+	metrics := clientUtil.NewMetrics()
+	metrics.Update(snapshot)
+	metrics.ObserveRequest(30*time.Millisecond, nil)
+	metrics.ObserveRequest(2*time.Second, errors.New("unavailable"))
+
+	var buf strings.Builder
+	metrics.Export(&buf, now.Add(90*time.Second))
+	out := buf.String()
+	for _, want := range []string{
+		"csds_connected_clients{stream_type=\"ADS\"} 2\n",
+		"csds_clients{xds=\"CDS\",status=\"STALE\"} 1\n",
+		"csds_clients{xds=\"CDS\",status=\"SYNCED\"} 1\n",
+		"csds_clients{xds=\"LDS\",status=\"SYNCED\"} 1\n",
+		"csds_nacked_resources{xds=\"LDS\"} 1\n",
+		"csds_nacked_resources{xds=\"CDS\"} 0\n",
+		"csds_seconds_since_last_version_change{node_id=\"node_a\"} 90\n",
+		"csds_request_errors_total 1\n",
+		"csds_request_duration_seconds_bucket{le=\"0.025\"} 0\n",
+		"csds_request_duration_seconds_bucket{le=\"0.05\"} 1\n",
+		"csds_request_duration_seconds_bucket{le=\"+Inf\"} 2\n",
+		"csds_request_duration_seconds_count 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %vin\n%v", want, out)
+		}
+	}
+}
+
+// TestAPI tests answering the queries of the http json api in serve mode.
+func TestAPI(t *testing.T) {
+	js := `{"config": [
+		{"node": {"id": "node_a"}, "xdsConfig": [
+			{"status": "SYNCED", "clusterConfig": {"versionInfo": "1", "dynamicActiveClusters": [
+				{"versionInfo": "1", "cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "foo"}},
+				{"versionInfo": "1", "cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "bar"}}]}}]},
+		{"node": {"id": "node_b"}, "xdsConfig": [
+			{"status": "STALE", "clusterConfig": {"versionInfo": "2", "dynamicActiveClusters": [
+				{"versionInfo": "2", "cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "bar"}}]}}]}]}`
+	api := clientUtil.NewAPI()
+	handler := api.Handler()
+	get := func(url string) (int, interface{}) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+		var body interface{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Errorf("Parse response of %v error: %v", url, err)
+		}
+		return recorder.Code, body
+	}
+
+	if code, _ := get("/clients"); code != http.StatusServiceUnavailable {
+		t.Errorf("want status %v before the first response, got %v", http.StatusServiceUnavailable, code)
+	}
+
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	snapshot, err := clientUtil.NewSnapshot(response, time.Now())
+	if err != nil {
+		t.Fatalf("New snapshot error: %v", err)
+	}
+	api.Update(snapshot)
+
+	for _, test := range []struct {
+		url  string
+		code int
+		want string
+	}{
+		{"/clients", http.StatusOK, `[{"id":"node_a","xds":[{"status":"SYNCED","type":"CDS","version":"1"}]},{"id":"node_b","xds":[{"status":"STALE","type":"CDS","version":"2"}]}]`},
+		{"/clients/node_b", http.StatusOK, `{"id":"node_b","xds":[{"resources":[{"name":"bar","state":"ACTIVE","version":"2"}],"status":"STALE","type":"CDS","version":"2"}]}`},
+		{"/clients/node_a/resources?type=cds&name=foo", http.StatusOK, `[{"config":{"@type":"type.googleapis.com/envoy.config.cluster.v3.Cluster","name":"foo"},"name":"foo","state":"ACTIVE","version":"1","xds":"CDS"}]`},
+		{"/clients/node_c", http.StatusNotFound, `{"error":"client node_c not found"}`},
+		{"/search?cluster=bar", http.StatusOK, `[{"name":"bar","node_id":"node_a","state":"ACTIVE","version":"1","xds":"CDS"},{"name":"bar","node_id":"node_b","state":"ACTIVE","version":"2","xds":"CDS"}]`},
+	} {
+		code, body := get(test.url)
+		if test.url == "/clients" {
+			body = body.(map[string]interface{})["clients"]
+		}
+		out, _ := json.Marshal(body)
+		if code != test.code || string(out) != test.want {
+			t.Errorf("%v: want %v %v, got %v %v", test.url, test.code, test.want, code, string(out))
+		}
+	}
+}
+
+// TestReplay tests replaying a recording through the printing and diffing pipeline.
+func TestReplay(t *testing.T) {
+	responses := []string{
+		`{"config": [{"node": {"id": "node_a"}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {"versionInfo": "1"}}]}]}`,
+		`{"config": [{"node": {"id": "node_a"}, "xdsConfig": [{"status": "STALE", "clusterConfig": {"versionInfo": "2"}}]}]}`,
+	}
+	recorder, err := clientUtil.OpenRecorder("test_recording.bin")
+	if err != nil {
+		t.Fatalf("Open recorder error: %v", err)
+	}
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, js := range responses {
+		if err := recorder.Record(start.Add(time.Duration(i)*time.Minute), &csdspb_v3.ClientStatusRequest{}); err != nil {
+			t.Fatalf("Record request error: %v", err)
+		}
+		response := &csdspb_v3.ClientStatusResponse{}
+		if err := protojson.Unmarshal([]byte(js), response); err != nil {
+			t.Fatalf("Parse response error: %v", err)
+		}
+		if err := recorder.Record(start.Add(time.Duration(i)*time.Minute), response); err != nil {
+			t.Fatalf("Record response error: %v", err)
+		}
+	}
+	recorder.Close()
+
+	// a monitor that fails to start does not replace the recording
+	info, err := os.Stat("test_recording.bin")
+	if err != nil {
+		t.Fatalf("Stat recording error: %v", err)
+	}
+	if _, err := clientUtil.NewMonitor(client.ClientOptions{RecordFile: "test_recording.bin", EventLog: "no_such_dir/events.jsonl"}); err == nil {
+		t.Errorf("want an error for the event log in a missing directory")
+	}
+	if after, err := os.Stat("test_recording.bin"); err != nil || after.Size() != info.Size() {
+		t.Fatalf("want the recording kept, got error: %v", err)
+	}
+
+	c, err := New(client.ClientOptions{
+		Platform:   "gcp",
+		ReplayFile: "test_recording.bin",
+		ConfigFile: "test_config.json",
+		WatchDiff:  true,
+	})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run replay error: %v", err)
+		}
+	})
+	want := "Client ID   xDS stream type   Config Status\nnode_a                        CDS   SYNCED\nConfig has been saved to test_config.json\n" +
+		"[2021-01-01T00:01:00Z] 2 change(s)\n~ node_a CDS status: SYNCED -> STALE\n~ node_a CDS version: 1 -> 2\n"
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
+
+// TestConverge tests tracking the rollout of a config version in converge mode.
+func TestConverge(t *testing.T) {
+	responses := []string{
+		`{"config": [
+			{"node": {"id": "node_a"}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {"versionInfo": "v123"}}]},
+			{"node": {"id": "node_b"}, "xdsConfig": [{"status": "STALE", "clusterConfig": {"versionInfo": "v122"}}]},
+			{"node": {"id": "node_c"}, "xdsConfig": [{"status": "ERROR", "clusterConfig": {"versionInfo": "v122"}}]}]}`,
+		`{"config": [
+			{"node": {"id": "node_a"}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {"versionInfo": "v123"}}]},
+			{"node": {"id": "node_b"}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {"versionInfo": "v123"}}]},
+			{"node": {"id": "node_c"}, "xdsConfig": [{"status": "ERROR", "clusterConfig": {"versionInfo": "v122"}}]}]}`,
+	}
+	m, err := clientUtil.NewMonitor(client.ClientOptions{
+		ConvergeType:      "CDS",
+		ConvergeVersion:   "v123",
+		ConvergeThreshold: 60,
+		ConvergeTimeout:   time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("New monitor error: %v", err)
+	}
+	var outs []string
+	var dones []bool
+	for i, js := range responses {
+		response := &csdspb_v3.ClientStatusResponse{}
+		if err := protojson.Unmarshal([]byte(js), response); err != nil {
+			t.Fatalf("Parse response error: %v", err)
+		}
+		outs = append(outs, clientUtil.CaptureOutput(func() {
+			if err := m.ProcessAt(response, time.Now().Add(time.Duration(i)*time.Minute), func() error {
+				t.Errorf("the response is printed in converge mode")
+				return nil
+			}); err != nil {
+				t.Errorf("Process response error: %v", err)
+			}
+		}))
+		done, err := m.Done()
+		if err != nil {
+			t.Errorf("Converge error: %v", err)
+		}
+		dones = append(dones, done)
+	}
+
+	want := []string{
+		"CDS v123: 1/3 clients (33.3%) converged, 1 lagging, 1 nacked\n  lagging: node_b (v122 STALE)\n  nacked: node_c (v122 ERROR)\n  time to converge: p50 0s, p90 0s, p99 0s\n",
+		"CDS v123: 2/3 clients (66.7%) converged, 0 lagging, 1 nacked\n  nacked: node_c (v122 ERROR)\n  time to converge: p50 0s, p90 1m0s, p99 1m0s\n",
+	}
+	for i, out := range outs {
+		if !strings.HasSuffix(out, want[i]) {
+			t.Errorf("want\n%vout\n%v", want[i], out)
+		}
+	}
+	if dones[0] || !dones[1] {
+		t.Errorf("want the rollout to converge at the second response, got %v", dones)
+	}
+}
+
+// TestConvergeInputFile tests the exit code of converge with -input_file, which has only one response
+func TestConvergeInputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "converge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	js := `{"config": [
+		{"node": {"id": "node_a"}, "xdsConfig": [{"status": "SYNCED", "clusterConfig": {"versionInfo": "v123"}}]},
+		{"node": {"id": "node_b"}, "xdsConfig": [{"status": "STALE", "clusterConfig": {"versionInfo": "v122"}}]}]}`
+	inputFile := filepath.Join(dir, "response.json")
+	if err := ioutil.WriteFile(inputFile, []byte(js), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		threshold float64
+		code      int
+	}{
+		{threshold: 50, code: 0},
+		{threshold: 100, code: 1},
+	} {
+		c, err := New(client.ClientOptions{
+			Platform:          "gcp",
+			InputFile:         inputFile,
+			ConvergeType:      "CDS",
+			ConvergeVersion:   "v123",
+			ConvergeThreshold: test.threshold,
+		})
+		if err != nil {
+			t.Fatalf("New client error: %v", err)
+		}
+		clientUtil.CaptureOutput(func() {
+			err = c.Run()
+		})
+		var exitErr *client.ExitError
+		if test.code == 0 && err != nil || test.code != 0 && (!errors.As(err, &exitErr) || exitErr.Code != test.code) {
+			t.Errorf("threshold %v: want exit code %v, got error: %v", test.threshold, test.code, err)
+		}
+	}
+}
+
+// TestTUI tests browsing the clients and their configs with the keys of the terminal ui.
+func TestTUI(t *testing.T) {
+	js := `{"config": [
+		{"node": {"id": "node_a"}, "xdsConfig": [
+			{"status": "SYNCED", "clusterConfig": {"versionInfo": "1", "dynamicActiveClusters": [
+				{"versionInfo": "1", "cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "foo"}},
+				{"versionInfo": "1", "cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "bar"}}]}}]},
+		{"node": {"id": "node_b"}, "xdsConfig": [
+			{"status": "SYNCED", "listenerConfig": {"versionInfo": "2", "dynamicListeners": [
+				{"name": "listener_1", "errorState": {"details": "rejected"}}]}}]}]}`
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	snapshot, err := clientUtil.NewSnapshot(response, time.Now())
+	if err != nil {
+		t.Fatalf("New snapshot error: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "tui")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tui := clientUtil.NewTUI(dir, false)
+	tui.Update(snapshot)
+	contains := func(lines []string, want string) bool {
+		for _, line := range lines {
+			if strings.Contains(line, want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// filter the clients, then drill down into the cluster foo of node_a
+	for _, key := range []string{"/", "_", "a", clientUtil.KeyEnter} {
+		tui.HandleKey(key)
+	}
+	lines := tui.Render(80, 24)
+	if !contains(lines, "> node_a") || contains(lines, "node_b") {
+		t.Errorf("want only node_a selected, got %v", lines)
+	}
+	for _, key := range []string{clientUtil.KeyEnter, clientUtil.KeyEnter, clientUtil.KeyEnter} {
+		tui.HandleKey(key)
+	}
+	lines = tui.Render(80, 24)
+	if !strings.HasPrefix(lines[0], "clients > node_a > CDS > foo") || !contains(lines, `"foo"`) {
+		t.Errorf("want the config of cluster foo, got %v", lines)
+	}
+
+	tui.HandleKey("e")
+	exported := filepath.Join(dir, "node_a_cds_foo.json")
+	if data, err := ioutil.ReadFile(exported); err != nil || !strings.Contains(string(data), `"foo"`) {
+		t.Errorf("want cluster foo exported to %v, got %v %v", exported, string(data), err)
+	}
+
+	tui.HandleKey("n")
+	lines = tui.Render(80, 24)
+	if !contains(lines, "NACKs (1)") || !contains(lines, "node_b LDS listener_1: rejected") {
+		t.Errorf("want the nack pane, got %v", lines)
+	}
+
+	// the messages of the client are shown in the status line instead of being printed out
+	fmt.Fprintf(tui, "CSDS request failed: %v\n", errors.New("unavailable"))
+	lines = tui.Render(80, 24)
+	if last := lines[len(lines)-1]; last != "CSDS request failed: unavailable" {
+		t.Errorf("want the failed request in the status line, got %v", last)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "rules.yaml"), []byte("rules:\n- name: nacked_clients\n  condition: nacked\n"), 0644); err != nil {
+		t.Fatalf("Write alert rules error: %v", err)
+	}
+	m, err := clientUtil.NewMonitor(client.ClientOptions{TUI: true, AlertRules: filepath.Join(dir, "rules.yaml"), MonitorInterval: time.Second})
+	if err != nil {
+		t.Fatalf("New monitor error: %v", err)
+	}
+	if out := clientUtil.CaptureOutput(func() {
+		if err := m.Process(response, func() error { return nil }); err != nil {
+			t.Errorf("Process response error: %v", err)
+		}
+	}); out != "" {
+		t.Errorf("want nothing printed out in tui mode, got %v", out)
+	}
+
+	if quit := tui.HandleKey("q"); !quit {
+		t.Errorf("want q to quit")
+	}
+	// ctrl-c quits while filtering, where q is part of the filter
+	tui.HandleKey(clientUtil.KeyEsc)
+	tui.HandleKey("/")
+	if quit := tui.HandleKey("q"); quit {
+		t.Errorf("want q to be filtered for while filtering")
+	}
+	if quit := tui.HandleKey(clientUtil.KeyInterrupt); !quit {
+		t.Errorf("want ctrl-c to quit while filtering")
+	}
+	keys := clientUtil.ParseKeys([]byte("\x1b[Aj\r\x1b\x7f\x03"))
+	want := []string{clientUtil.KeyUp, "j", clientUtil.KeyEnter, clientUtil.KeyEsc, clientUtil.KeyBackspace, clientUtil.KeyInterrupt}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("want keys %v, got %v", want, keys)
+	}
+}
+
+// TestLint tests checking the config of the clients with the lint rules in lint mode.
+func TestLint(t *testing.T) {
+	js := `{"config": [
+		{"node": {"id": "node_a"}, "xdsConfig": [
+			{"status": "SYNCED", "listenerConfig": {"dynamicListeners": [
+				{"name": "listener_1", "activeState": {"listener": {"@type": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "listener_1",
+					"filterChains": [{"filters": [{"name": "envoy.filters.network.http_connection_manager", "typedConfig": {
+						"@type": "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+						"rds": {"routeConfigName": "route_1"}}}]}]}}}]}},
+			{"status": "SYNCED", "routeConfig": {"dynamicRouteConfigs": [
+				{"routeConfig": {"@type": "type.googleapis.com/envoy.config.route.v3.RouteConfiguration", "name": "route_1", "virtualHosts": [
+					{"name": "vhost_1", "domains": ["example.com"], "routes": [
+						{"name": "default", "match": {"prefix": "/"}, "route": {"cluster": "cluster_1", "timeout": "5s"}},
+						{"match": {"prefix": "/missing"}, "route": {"cluster": "cluster_missing"}},
+						{"name": "split", "match": {"prefix": "/split"}, "route": {"timeout": "5s", "retryPolicy": {"numRetries": 10},
+							"weightedClusters": {"clusters": [{"name": "cluster_1", "weight": 30}, {"name": "cluster_2", "weight": 30}]}}}]},
+					{"name": "vhost_2", "domains": ["EXAMPLE.com"]}]}}]}},
+			{"status": "SYNCED", "clusterConfig": {"dynamicActiveClusters": [
+				{"cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_1", "type": "EDS"}},
+				{"cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_2"}},
+				{"cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "cluster_3"}}]}},
+			{"status": "SYNCED", "endpointConfig": {"dynamicEndpointConfigs": [
+				{"endpointConfig": {"@type": "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment", "clusterName": "cluster_2"}}]}}]}]}`
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	process := func(opts client.ClientOptions) (string, error) {
+		opts.Lint = true
+		m, err := clientUtil.NewMonitor(opts)
+		if err != nil {
+			t.Fatalf("New monitor error: %v", err)
+		}
+		var processErr error
+		out := clientUtil.CaptureOutput(func() {
+			processErr = m.Process(response, func() error {
+				t.Errorf("the response is printed in lint mode")
+				return nil
+			})
+		})
+		return out, processErr
+	}
+
+	out, err := process(client.ClientOptions{})
+	want := "Severity   Client   Resource        Rule                     Message\n" +
+		"error      node_a   RDS/route_1     missing-cluster          refers to cluster cluster_missing, which is not in CDS\n" +
+		"warning    node_a   CDS/cluster_1   missing-endpoints        uses EDS but has no cluster load assignment\n" +
+		"info       node_a   CDS/cluster_3   orphaned-resource        is not referred to by any other resource\n" +
+		"error      node_a   RDS/route_1     weighted-cluster-total   virtual host vhost_1 route split: the weights of the clusters add up to 60, not 100\n" +
+		"info       node_a   RDS/route_1     missing-route-timeout    virtual host vhost_1 route 1 (prefix /missing) has no timeout, so the default of 15s applies\n" +
+		"warning    node_a   RDS/route_1     retry-storm              virtual host vhost_1 route split retries up to 10 times, which multiplies the load on a failing upstream by up to 11\n" +
+		"error      node_a   RDS/route_1     duplicate-domain         domain EXAMPLE.com is in virtual hosts vhost_1 and vhost_2\n" +
+		"7 finding(s): 3 error(s), 2 warning(s), 2 info\n"
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+	var exitErr *client.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("want exit code 2, got error: %v", err)
+	}
+
+	// in replay, the lint errors of the last response are returned at the end of the replay
+	m, err := clientUtil.NewMonitor(client.ClientOptions{ReplayFile: "recording.jsonl", Lint: true})
+	if err != nil {
+		t.Fatalf("New monitor error: %v", err)
+	}
+	clientUtil.CaptureOutput(func() {
+		if err := m.Process(response, func() error { return nil }); err != nil {
+			t.Errorf("Process response error in replay: %v", err)
+		}
+	})
+	if err := m.EndError(); !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("want exit code 2 at the end of the replay, got error: %v", err)
+	}
+
+	// the rules of errors are disabled, so lint passes, and the findings are printed as json
+	out, err = process(client.ClientOptions{LintRules: "-missing-cluster,-weighted-cluster-total,-duplicate-domain", LintFormat: clientUtil.LintJson})
+	if err != nil {
+		t.Errorf("Process response error: %v", err)
+	}
+	var findings []clientUtil.LintFinding
+	if err := json.Unmarshal([]byte(out), &findings); err != nil {
+		t.Fatalf("Parse findings error: %v", err)
+	}
+	var rules []string
+	for _, finding := range findings {
+		rules = append(rules, finding.Rule)
+	}
+	if want := "missing-endpoints,orphaned-resource,missing-route-timeout,retry-storm"; strings.Join(rules, ",") != want {
+		t.Errorf("want the findings of %v, got %v", want, strings.Join(rules, ","))
+	}
+
+	// only the enabled rule runs
+	out, _ = process(client.ClientOptions{LintRules: "retry-storm"})
+	if !strings.HasSuffix(out, "1 finding(s): 0 error(s), 1 warning(s), 0 info\n") {
+		t.Errorf("want only the finding of retry-storm, got\n%v", out)
+	}
+
+	if _, err := clientUtil.NewLinter("no-such-rule", clientUtil.LintText); err == nil {
+		t.Errorf("want an error for an unsupported rule")
+	}
+}
+
+// TestConsistency tests comparing the configs of the clients in the same group in consistency mode.
+func TestConsistency(t *testing.T) {
+	cluster := func(name string, version string, timeout string) string {
+		return `{"versionInfo": "` + version + `", "cluster": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "` + name + `", "connectTimeout": "` + timeout + `"}}`
+	}
+	node := func(id string, app string, clusters ...string) string {
+		return `{"node": {"id": "` + id + `", "metadata": {"labels": {"app": "` + app + `"}}}, "xdsConfig": [
+			{"status": "SYNCED", "clusterConfig": {"dynamicActiveClusters": [` + strings.Join(clusters, ",") + `]}}]}`
+	}
+	js := `{"config": [` + strings.Join([]string{
+		node("node_a", "foo", cluster("cluster_1", "1", "1s"), cluster("cluster_2", "1", "1s")),
+		node("node_b", "foo", cluster("cluster_1", "1", "1s"), cluster("cluster_2", "1", "1s")),
+		node("node_c", "foo", cluster("cluster_1", "2", "5s"), cluster("cluster_3", "2", "1s")),
+		node("node_d", "bar", cluster("cluster_1", "2", "5s")),
+		`{"node": {"id": "node_e"}}`,
+	}, ",") + `]}`
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response error: %v", err)
+	}
+	m, err := clientUtil.NewMonitor(client.ClientOptions{ConsistencyGroupBy: "labels.app"})
+	if err != nil {
+		t.Fatalf("New monitor error: %v", err)
+	}
+	var processErr error
+	out := clientUtil.CaptureOutput(func() {
+		processErr = m.ProcessAt(response, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), func() error {
+			t.Errorf("the response is printed in consistency mode")
+			return nil
+		})
+	})
+	want := "[2021-01-01T00:00:00Z] 2 group(s) by labels.app\n" +
+		"labels.app=bar: 1 client(s), consistent\n" +
+		"labels.app=foo: 3 client(s), 3 inconsistent resource(s)\n" +
+		"  ~ node_c CDS cluster_1 version 2, majority has version 1 (node_a, node_b)\n" +
+		"      connectTimeout: \"1s\" -> \"5s\"\n" +
+		"  - node_c CDS cluster_2 missing, majority has version 1 (node_a, node_b)\n" +
+		"  + node_c CDS cluster_3 extra, majority does not have it (node_a, node_b)\n" +
+		"without labels.app, not compared: node_e\n"
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+	var exitErr *client.ExitError
+	if !errors.As(processErr, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("want exit code 2, got error: %v", processErr)
+	}
+
+	// in replay, the inconsistent clients of the last response are reported at the end of the replay
+	m, err = clientUtil.NewMonitor(client.ClientOptions{ConsistencyGroupBy: "labels.app", ReplayFile: "recording.jsonl"})
+	if err != nil {
+		t.Fatalf("New monitor error: %v", err)
+	}
+	clientUtil.CaptureOutput(func() {
+		processErr = m.Process(response, func() error { return nil })
+	})
+	if processErr != nil {
+		t.Errorf("Process response error in replay: %v", processErr)
+	}
+	if err := m.EndError(); !errors.As(err, &exitErr) || exitErr.Code != 2 {
+		t.Errorf("want exit code 2 at the end of the replay, got error: %v", err)
+	}
+
+	// the clients holding the same versions and contents are consistent
+	snapshot, err := clientUtil.NewSnapshot(response, time.Now())
+	if err != nil {
+		t.Fatalf("New snapshot error: %v", err)
+	}
+	snapshot.Clients = snapshot.Clients[:2]
+	if report := clientUtil.NewConsistency("labels.app").Check(snapshot); report.Inconsistent() {
+		t.Errorf("want node_a and node_b consistent, got %+v", report)
+	}
+
+	// the same content in a different encoding is consistent, node_b encodes the name of cluster_1,
+	// which is the first field of the message, after the connect timeout
+	config := snapshot.Clients[1].XdsConfig("CDS").Resource("cluster_1").Config
+	name := 2 + len("cluster_1")
+	config.Value = append(append([]byte{}, config.Value[name:]...), config.Value[:name]...)
+	if report := clientUtil.NewConsistency("labels.app").Check(snapshot); report.Inconsistent() {
+		t.Errorf("want node_a and node_b consistent with cluster_1 encoded differently, got %+v", report)
 	}
 }
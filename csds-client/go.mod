@@ -1,19 +1,30 @@
 module envoy-tools/csds-client
 
-go 1.14
+go 1.22
 
 require (
-	cloud.google.com/go v0.64.0 // indirect
 	github.com/awalterschulze/gographviz v2.0.1+incompatible
-	github.com/census-instrumentation/opencensus-proto v0.3.0 // indirect
-	github.com/cncf/udpa/go v0.0.0-20200629203442-efcf912fb354 // indirect
-	github.com/emirpasic/gods v1.12.0
-	github.com/envoyproxy/go-control-plane v0.9.6
-	github.com/envoyproxy/protoc-gen-validate v0.4.1 // indirect
+	github.com/envoyproxy/go-control-plane/envoy v1.32.4
 	github.com/ghodss/yaml v1.0.0
 	github.com/golang/mock v1.4.4
-	golang.org/x/sys v0.0.0-20200819171115-d785dc25833f // indirect
-	google.golang.org/grpc v1.31.0
-	google.golang.org/protobuf v1.25.0
+	golang.org/x/sys v0.29.0
+	google.golang.org/grpc v1.70.0
+	google.golang.org/protobuf v1.36.4
 	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
+
+require (
+	cel.dev/expr v0.19.0 // indirect
+	cloud.google.com/go/compute/metadata v0.5.2 // indirect
+	github.com/cncf/xds/go v0.0.0-20240905190251-b4127c9b8d78 // indirect
+	github.com/envoyproxy/protoc-gen-validate v1.2.1 // indirect
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/planetscale/vtprotobuf v0.6.1-0.20240319094008-0393e58bdf10 // indirect
+	github.com/rogpeppe/go-internal v1.12.0 // indirect
+	golang.org/x/net v0.34.0 // indirect
+	golang.org/x/oauth2 v0.24.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20241202173237-19429a94021a // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20241202173237-19429a94021a // indirect
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+)
@@ -2,10 +2,15 @@ package main
 
 import (
 	"envoy-tools/csds-client/client"
+	"envoy-tools/csds-client/client/util"
 	client_v2 "envoy-tools/csds-client/client/v2"
 	client_v3 "envoy-tools/csds-client/client/v3"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 )
 
@@ -17,22 +22,100 @@ var apiVersion string
 var requestFile string
 var requestYaml string
 var jwt string
+var inputFile string
 var configFile string
+var outputDir string
+var splitOutput bool
+var outputFormat string
 var monitorInterval time.Duration
-var visualization bool
+var watchDiff bool
+var eventLog string
+var alertRules string
+var metricsAddr string
+var listenAddr string
+var recordFile string
+var replaySpeed float64
+var convergeType string
+var convergeVersion string
+var convergeThreshold float64
+var convergeTimeout time.Duration
+var visualization string
+var visualizationOnline bool
+var graphFormat string
+var graphFile string
+var graphAddr string
+var graphClient string
+var graphDetail string
+var graphRoot string
+var graphDepth int
+var lintRules string
+var lintFormat string
+var groupBy string
+var wide bool
 
 // const default values for flag vars
 const (
-	uriDefault             string        = "trafficdirector.googleapis.com:443"
-	platformDefault        string        = "gcp"
-	authnModeDefault       string        = "auto"
-	apiVersionDefault      string        = "v2"
-	requestFileDefault     string        = ""
-	requestYamlDefault     string        = ""
-	jwtDefault             string        = ""
-	configFileDefault      string        = ""
-	monitorIntervalDefault time.Duration = 0
-	visualizationDefault   bool          = false
+	uriDefault                 string        = "trafficdirector.googleapis.com:443"
+	platformDefault            string        = "gcp"
+	authnModeDefault           string        = "auto"
+	apiVersionDefault          string        = "v2"
+	requestFileDefault         string        = ""
+	requestYamlDefault         string        = ""
+	jwtDefault                 string        = ""
+	inputFileDefault           string        = ""
+	configFileDefault          string        = ""
+	outputDirDefault           string        = ""
+	splitOutputDefault         bool          = false
+	outputFormatDefault        string        = "json"
+	monitorIntervalDefault     time.Duration = 0
+	watchDiffDefault           bool          = false
+	eventLogDefault            string        = ""
+	alertRulesDefault          string        = ""
+	metricsAddrDefault         string        = ""
+	listenAddrDefault          string        = "localhost:8080"
+	recordFileDefault          string        = ""
+	replaySpeedDefault         float64       = 1
+	convergeTypeDefault        string        = ""
+	convergeVersionDefault     string        = ""
+	convergeThresholdDefault   float64       = 100
+	convergeTimeoutDefault     time.Duration = 10 * time.Minute
+	visualizationDefault       string        = ""
+	visualizationOnlineDefault bool          = false
+	graphFormatDefault         string        = "dot"
+	graphFileDefault           string        = ""
+	graphAddrDefault           string        = ""
+	graphClientDefault         string        = ""
+	graphDetailDefault         string        = "resources"
+	graphRootDefault           string        = ""
+	graphDepthDefault          int           = 0
+	lintRulesDefault           string        = ""
+	lintFormatDefault          string        = "text"
+	groupByDefault             string        = ""
+	wideDefault                bool          = false
+)
+
+// continuousMonitorInterval is the interval of sending request when the client runs continuously
+// (e.g. with -metrics_addr or in serve mode) and -monitor_interval is not set
+const continuousMonitorInterval time.Duration = 10 * time.Second
+
+// subcommands, the client runs once or in monitor mode without a subcommand
+const (
+	// serveCommand keeps polling and serves the latest response with an http json api
+	serveCommand string = "serve"
+	// replayCommand feeds the responses of a recording in -input_file through the pipeline
+	replayCommand string = "replay"
+	// convergeCommand tracks the rollout of a config version until it converges or times out
+	convergeCommand string = "converge"
+	// tuiCommand browses the clients and their configs in an interactive terminal ui
+	tuiCommand string = "tui"
+	// graphDiffCommand visualizes the changes of the graph from the response in a file to the one
+	// in another file, or from a response to the next one in monitor mode
+	graphDiffCommand string = "graph-diff"
+	// lintCommand checks the config of each client for problems such as references to missing
+	// resources
+	lintCommand string = "lint"
+	// consistencyCommand compares the configs of the clients with the same value of a metadata key
+	consistencyCommand string = "consistency"
 )
 
 // init binds flags with variables
@@ -44,28 +127,207 @@ func init() {
 	flag.StringVar(&requestFile, "request_file", requestFileDefault, "yaml file that defines the csds request")
 	flag.StringVar(&requestYaml, "request_yaml", requestYamlDefault, "yaml string that defines the csds request")
 	flag.StringVar(&jwt, "jwt_file", jwtDefault, "path of the -jwt_file")
+	flag.StringVar(&inputFile, "input_file", inputFileDefault, "saved csds response or Envoy config dump to analyze without connecting to the control plane")
 	flag.StringVar(&configFile, "output_file", configFileDefault, "file name to save configs returned by csds response")
+	flag.StringVar(&outputDir, "output_dir", outputDirDefault, "directory to save the config of each client returned by csds response to its own file")
+	flag.BoolVar(&splitOutput, "split_output", splitOutputDefault, "option to further split the config of each client in -output_dir into per-xDS-type files")
+	flag.StringVar(&outputFormat, "output", outputFormatDefault, "format of the detailed config output (e.g. json, config_dump)")
 	flag.DurationVar(&monitorInterval, "monitor_interval", monitorIntervalDefault, "the interval of sending request in monitor mode (e.g. 500ms, 2s, 1m ...)")
-	flag.BoolVar(&visualization, "visualization", visualizationDefault, "option to visualize the relationship between xDS")
+	flag.BoolVar(&watchDiff, "watch_diff", watchDiffDefault, "option to only print the changes since the previous response in monitor mode")
+	flag.StringVar(&eventLog, "event_log", eventLogDefault, "file to append the status transitions of the clients to as json lines")
+	flag.StringVar(&alertRules, "alert_rules", alertRulesDefault, "yaml file that defines the alert rules to evaluate against the responses")
+	flag.StringVar(&metricsAddr, "metrics_addr", metricsAddrDefault, "address to serve the metrics of the clients at /metrics in the Prometheus format (e.g. :9090)")
+	flag.StringVar(&listenAddr, "listen_addr", listenAddrDefault, "address to serve the http json api at in serve mode")
+	flag.StringVar(&recordFile, "record", recordFileDefault, "file to record the requests and responses of the session to")
+	flag.Float64Var(&replaySpeed, "replay_speed", replaySpeedDefault, "speed of replaying a recording relative to the original one, 0 to replay without waiting")
+	flag.StringVar(&visualization, "visualization", visualizationDefault, "mode to visualize the relationship between xDS in (e.g. graph, html, tree)")
+	flag.BoolVar(&visualizationOnline, "visualization_online", visualizationOnlineDefault, "option to show the graph of -visualization on Graphviz Online, which sends it to a third-party website, instead of rendering it locally")
+	flag.StringVar(&graphFormat, "graph_format", graphFormatDefault, "format of the graph of -visualization (e.g. dot, mermaid, d2, json, graphml)")
+	flag.StringVar(&graphFile, "graph_file", graphFileDefault, "file to save the graph of -visualization to, config_graph.<format> by default")
+	flag.StringVar(&graphAddr, "graph_addr", graphAddrDefault, "address to serve the html viewer of -visualization html at instead of opening the file (e.g. localhost:8081)")
+	flag.StringVar(&graphClient, "graph_client", graphClientDefault, "node id of the client to only show the resources of in the graph of -visualization")
+	flag.StringVar(&graphDetail, "graph_detail", graphDetailDefault, "detail of the graph of -visualization (e.g. resources, routes, endpoints)")
+	flag.StringVar(&graphRoot, "graph_root", graphRootDefault, "resource to only show the resources reachable from or leading to in the graph of -visualization (e.g. listener:NAME, route:NAME, cluster:NAME)")
+	flag.IntVar(&graphDepth, "graph_depth", graphDepthDefault, "number of edges from -graph_root to show the resources within, 0 for any number")
+	flag.StringVar(&lintRules, "lint_rules", lintRulesDefault, "comma separated lint rules to run in lint mode, all by default, or to skip if prefixed with - (e.g. missing-cluster,retry-storm or -orphaned-resource)")
+	flag.StringVar(&lintFormat, "lint_format", lintFormatDefault, "format of the findings in lint mode (e.g. text, json)")
+	flag.StringVar(&groupBy, "group_by", groupByDefault, "metadata key to group the clients by in consistency mode, with dots between the keys of nested structs (e.g. TRAFFICDIRECTOR_NETWORK_NAME, labels.app)")
+	flag.BoolVar(&wide, "wide", wideDefault, "option to show extra columns such as versions and metadata in the client status table")
+}
+
+// commandFlags returns the flags of command, which are the global flags, plus the flags of the
+// rollout for the converge command
+func commandFlags(command string) *flag.FlagSet {
+	if command != convergeCommand {
+		return flag.CommandLine
+	}
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags.Var(f.Value, f.Name, f.Usage)
+	})
+	flags.StringVar(&convergeType, "type", convergeTypeDefault, "xds type whose rollout is tracked (e.g. LDS, RDS, CDS, ...)")
+	flags.StringVar(&convergeVersion, "version", convergeVersionDefault, "config version whose rollout is tracked")
+	flags.Float64Var(&convergeThreshold, "threshold", convergeThresholdDefault, "percentage of clients with the version for the rollout to converge")
+	flags.DurationVar(&convergeTimeout, "timeout", convergeTimeoutDefault, "time for the rollout to converge")
+	return flags
+}
+
+// parseArgs parses the subcommand, which comes before the flags, and the flags in args, and
+// returns the subcommand and the arguments after the flags
+func parseArgs(args []string) (string, []string, error) {
+	command := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	flags := commandFlags(command)
+	if err := flags.Parse(args); err != nil {
+		return "", nil, err
+	}
+	// only graph-diff takes arguments, anything else left after the flags is a mistake, which would
+	// leave the flags after it unparsed
+	if flags.NArg() > 0 && command != graphDiffCommand {
+		return "", nil, fmt.Errorf("unexpected arguments: %v", strings.Join(flags.Args(), " "))
+	}
+	switch visualization {
+	case "", util.VisualizationGraph, util.VisualizationHtml, util.VisualizationTree:
+	default:
+		return "", nil, fmt.Errorf("unsupported visualization mode: %v", visualization)
+	}
+	if graphAddr != "" && visualization != util.VisualizationHtml {
+		return "", nil, errors.New("-graph_addr only works with -visualization html")
+	}
+	return command, flags.Args(), nil
 }
 
 func main() {
-	flag.Parse()
+	command, args, err := parseArgs(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	serveAddr := ""
+	replayFile := ""
+	graphDiffFile := ""
+	consistencyGroupBy := ""
+	switch command {
+	case "":
+	case serveCommand:
+		serveAddr = listenAddr
+	case replayCommand:
+		if inputFile == "" {
+			log.Fatal("Missing -input_file of the recording to replay")
+		}
+		replayFile, inputFile = inputFile, ""
+	case convergeCommand:
+		if convergeType == "" || convergeVersion == "" {
+			log.Fatal("Missing -type or -version of the rollout to track")
+		}
+		convergeType = strings.ToUpper(convergeType)
+	case tuiCommand:
+	case graphDiffCommand:
+		switch len(args) {
+		case 0:
+		case 2:
+			if inputFile != "" {
+				log.Fatal("-input_file cannot be used with the files to diff")
+			}
+			graphDiffFile, inputFile = args[0], args[1]
+		default:
+			log.Fatal("Usage: csds-client graph-diff [flags] [old_file new_file]")
+		}
+		if visualization == "" {
+			visualization = util.VisualizationGraph
+		}
+	case lintCommand:
+	case consistencyCommand:
+		if groupBy == "" {
+			log.Fatal("Missing -group_by metadata key to group the clients by")
+		}
+		consistencyGroupBy = groupBy
+	default:
+		log.Fatalf("Unsupported command: %v", command)
+	}
+
+	// the metrics are exported, the api is served, the rollout is tracked, the terminal ui is refreshed and
+	// the changes of the graph are visualized continuously
+	if (metricsAddr != "" || serveAddr != "" || command == convergeCommand || command == tuiCommand || (command == graphDiffCommand && inputFile == "")) && monitorInterval == 0 {
+		monitorInterval = continuousMonitorInterval
+	}
 
 	clientOpts := client.ClientOptions{
-		Uri:             uri,
-		Platform:        platform,
-		AuthnMode:       authnMode,
-		RequestFile:     requestFile,
-		RequestYaml:     requestYaml,
-		Jwt:             jwt,
-		ConfigFile:      configFile,
-		MonitorInterval: monitorInterval,
-		Visualization:   visualization,
+		Uri:                 uri,
+		Platform:            platform,
+		AuthnMode:           authnMode,
+		RequestFile:         requestFile,
+		RequestYaml:         requestYaml,
+		Jwt:                 jwt,
+		InputFile:           inputFile,
+		ReplayFile:          replayFile,
+		ReplaySpeed:         replaySpeed,
+		RecordFile:          recordFile,
+		ConfigFile:          configFile,
+		OutputDir:           outputDir,
+		SplitOutput:         splitOutput,
+		OutputFormat:        outputFormat,
+		MonitorInterval:     monitorInterval,
+		WatchDiff:           watchDiff,
+		EventLog:            eventLog,
+		AlertRules:          alertRules,
+		MetricsAddr:         metricsAddr,
+		ServeAddr:           serveAddr,
+		ConvergeType:        convergeType,
+		ConvergeVersion:     convergeVersion,
+		ConvergeThreshold:   convergeThreshold,
+		ConvergeTimeout:     convergeTimeout,
+		TUI:                 command == tuiCommand,
+		Visualization:       visualization,
+		VisualizationOnline: visualizationOnline,
+		GraphFormat:         graphFormat,
+		GraphFile:           graphFile,
+		GraphAddr:           graphAddr,
+		GraphClient:         graphClient,
+		GraphDetail:         graphDetail,
+		GraphRoot:           graphRoot,
+		GraphDepth:          graphDepth,
+		GraphDiff:           command == graphDiffCommand,
+		GraphDiffFile:       graphDiffFile,
+		Lint:                command == lintCommand,
+		LintRules:           lintRules,
+		LintFormat:          lintFormat,
+		ConsistencyGroupBy:  consistencyGroupBy,
+		Wide:                wide,
+	}
+
+	switch outputFormat {
+	case "json", "config_dump":
+	default:
+		log.Fatalf("Unsupported output format: %v", outputFormat)
+	}
+	switch graphFormat {
+	case "dot", "mermaid", "d2", "json", "graphml":
+	default:
+		log.Fatalf("Unsupported graph format: %v", graphFormat)
+	}
+	switch lintFormat {
+	case util.LintText, util.LintJson:
+	default:
+		log.Fatalf("Unsupported lint format: %v", lintFormat)
+	}
+	switch graphDetail {
+	case "resources", "routes", "endpoints":
+	default:
+		log.Fatalf("Unsupported graph detail: %v", graphDetail)
+	}
+	if graphRoot != "" {
+		if _, _, err := util.ParseGraphRoot(graphRoot); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if graphDepth < 0 {
+		log.Fatal("-graph_depth cannot be negative")
 	}
 
 	var c client.Client
-	var err error
 	switch apiVersion {
 	case "v2":
 		c, err = client_v2.New(clientOpts)
@@ -80,6 +342,11 @@ func main() {
 	}
 
 	if err := c.Run(); err != nil {
+		var exitErr *client.ExitError
+		if errors.As(err, &exitErr) {
+			log.Println(exitErr.Err)
+			os.Exit(exitErr.Code)
+		}
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,52 @@
+// Unit Tests for the flags of csds-client
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseArgsVisualization tests parsing the mode of -visualization after a space or =, and
+// failing on an unknown mode or leftover arguments.
+func TestParseArgsVisualization(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		want string
+		fail bool
+	}{
+		{args: []string{"-visualization", "html", "-wide"}, want: "html"},
+		{args: []string{"-visualization", "tree", "-wide"}, want: "tree"},
+		{args: []string{"-visualization=graph", "-wide"}, want: "graph"},
+		{args: []string{"-wide"}, want: ""},
+		{args: []string{"-visualization", "typo", "-wide"}, fail: true},
+		{args: []string{"-wide", "tree"}, fail: true},
+	} {
+		visualization, wide = visualizationDefault, wideDefault
+		_, _, err := parseArgs(test.args)
+		if test.fail {
+			if err == nil {
+				t.Errorf("%v: want an error, got visualization %q", test.args, visualization)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: parse args error: %v", test.args, err)
+		} else if visualization != test.want || !wide {
+			t.Errorf("%v: want visualization %q and -wide, got %q and %v", test.args, test.want, visualization, wide)
+		}
+	}
+	visualization, wide = visualizationDefault, wideDefault
+}
+
+// TestParseArgsConverge tests parsing the flags of the rollout after the converge command.
+func TestParseArgsConverge(t *testing.T) {
+	command, _, err := parseArgs([]string{"converge", "-type", "CDS", "-version", "v123", "-threshold", "90", "-timeout", "5m", "-wide"})
+	if err != nil {
+		t.Fatalf("Parse args error: %v", err)
+	}
+	if command != convergeCommand || convergeType != "CDS" || convergeVersion != "v123" || convergeThreshold != 90 || convergeTimeout != 5*time.Minute || !wide {
+		t.Errorf("want the rollout of CDS v123 to 90%% within 5m with -wide, got command %v, %v %v to %v%% within %v with -wide %v",
+			command, convergeType, convergeVersion, convergeThreshold, convergeTimeout, wide)
+	}
+	wide = wideDefault
+}